@@ -0,0 +1,1341 @@
+// Package cpu6502 emulates the 6502 CPU (as the NES's 2A03 has it, without
+// decimal mode) a bus cycle at a time. Whatever it's connected to, memory
+// and devices and the interrupt lines, is behind the Bus interface.
+package cpu6502
+
+// Step runs one instruction, or the interrupt sequence if an interrupt is
+// due, and returns the number of cycles it took. Cycles the bus spends
+// with the CPU halted count too, if it adds them to cpu.Cycles.
+func Step(cpu *CPU) int {
+    startCycles := cpu.Cycles
+    if InterruptPending(cpu) {
+        executeInterrupt(cpu)
+    } else {
+        opcode := read(cpu, cpu.PC)
+        executeInstruction(cpu, opcode)
+    }
+    return int(cpu.Cycles - startCycles)
+}
+
+// InterruptPending says whether Step will run the interrupt sequence
+// rather than the instruction at the PC
+func InterruptPending(cpu *CPU) bool {
+    return cpu.polled == interruptNMI || cpu.polled == interruptIRQ
+}
+
+// InterruptVector is the address of the vector the pending interrupt
+// jumps through, $FFFA for an NMI and $FFFE for an IRQ
+func InterruptVector(cpu *CPU) uint16 {
+    if cpu.polled == interruptNMI {
+        return 0xFFFA
+    }
+    return 0xFFFE
+}
+
+func executeInstruction(cpu *CPU, opcode byte) {
+
+    instruction := Instructions[opcode]
+    mode := instruction.Mode
+
+    // every cycle is a bus access (see read and write), including the
+    // ones whose value the 6502 throws away; those dummy reads and writes
+    // still have side effects on the PPU and APU registers
+    cpu.PC++
+
+    // indexed adds index to base. The 6502 adds the carry into the high
+    // byte a cycle late, reading from the wrong address meanwhile, which it
+    // only skips for a read with no carry
+    indexed := func (base uint16, index byte) uint16 {
+        address := base + uint16(index)
+        if pagesDiffer(base, address) || accessKinds[opcode] != accessRead {
+            read(cpu, base&0xFF00 | address&0x00FF)
+        }
+        return address
+    }
+
+    var address uint16
+    switch mode {
+    case ModeAbsolute:
+        address = uint16(read(cpu, cpu.PC))
+        cpu.PC++
+        if opcode != 0x20 {
+            // JSR fetches the high byte last, see below
+            address |= uint16(read(cpu, cpu.PC)) << 8
+            cpu.PC++
+        }
+    case ModeAbsoluteX:
+        base := read16pc(cpu)
+        address = indexed(base, cpu.X)
+    case ModeAbsoluteY:
+        base := read16pc(cpu)
+        address = indexed(base, cpu.Y)
+    case ModeAccumulator, ModeImplied:
+        read(cpu, cpu.PC)
+    case ModeImmediate:
+        address = cpu.PC
+        cpu.PC++
+    case ModeIndexedIndirect:
+        pointer := read(cpu, cpu.PC)
+        cpu.PC++
+        read(cpu, uint16(pointer))
+        address = read16bug(cpu, uint16(pointer + cpu.X))
+    case ModeIndirect:
+        address = read16bug(cpu, read16pc(cpu))
+    case ModeIndirectIndexed:
+        pointer := read(cpu, cpu.PC)
+        cpu.PC++
+        base := read16bug(cpu, uint16(pointer))
+        address = indexed(base, cpu.Y)
+    case ModeRelative:
+        offset := uint16(read(cpu, cpu.PC))
+        cpu.PC++
+        if offset < 0x80 {
+            address = cpu.PC + offset
+        } else {
+            address = cpu.PC + offset - 0x100
+        }
+    case ModeZeroPage:
+        address = uint16(read(cpu, cpu.PC))
+        cpu.PC++
+    case ModeZeroPageX:
+        base := read(cpu, cpu.PC)
+        cpu.PC++
+        read(cpu, uint16(base))
+        address = uint16(base + cpu.X)
+    case ModeZeroPageY:
+        base := read(cpu, cpu.PC)
+        cpu.PC++
+        read(cpu, uint16(base))
+        address = uint16(base + cpu.Y)
+    }
+
+
+    // OPCODE functions
+
+    // add adds b and the carry to A; SBC adds the complement of its
+    // operand, as A - M - (1 - C) is A + ^M + C
+    add := func (b byte) {
+        a := cpu.A
+        c := cpu.C
+        cpu.A = a + b + c
+        setZN(cpu, cpu.A)
+        if int(a)+int(b)+int(c) > 0xFF {
+            cpu.C = 1
+        } else {
+            cpu.C = 0
+        }
+        if (a^b)&0x80 == 0 && (a^cpu.A)&0x80 != 0 {
+            cpu.V = 1
+        } else {
+            cpu.V = 0
+        }
+    }
+
+    // ADC - Add with Carry
+    adc := func () {
+        add(read(cpu, address))
+    }
+
+    // AND - Logical AND
+    and := func () {
+        cpu.A = cpu.A & read(cpu, address)
+        setZN(cpu, cpu.A)
+    }
+
+    // ASL - Arithmetic Shift Left
+    asl := func () {
+        if mode == ModeAccumulator {
+            cpu.C = (cpu.A >> 7) & 1
+            cpu.A <<= 1
+            setZN(cpu, cpu.A)
+        } else {
+            value := read(cpu, address)
+            write(cpu, address, value)
+            cpu.C = (value >> 7) & 1
+            value <<= 1
+            write(cpu, address, value)
+            setZN(cpu, value)
+        }
+    }
+
+    // BIT - Bit Test
+    bit := func () {
+        value := read(cpu, address)
+        cpu.V = (value >> 6) & 1
+        setZ(cpu, value & cpu.A)
+        setN(cpu, value)
+    }
+
+    // CMP - Compare
+    cmp := func () {
+        value := read(cpu, address)
+        compare(cpu, cpu.A, value)
+    }
+
+    // CPX - Compare X Register
+    cpx := func () {
+        value := read(cpu, address)
+        compare(cpu, cpu.X, value)
+    }
+
+    // CPY - Compare Y Register
+    cpy := func () {
+        value := read(cpu, address)
+        compare(cpu, cpu.Y, value)
+    }
+
+    // DEC - Decrement Memory
+    dec := func () {
+        value := read(cpu, address)
+        write(cpu, address, value)
+        value -= 1
+        write(cpu, address, value)
+        setZN(cpu, value)
+    }
+
+
+    // EOR - Exclusive OR
+    eor := func () {
+        cpu.A = cpu.A ^ read(cpu, address)
+        setZN(cpu, cpu.A)
+    }
+
+    // INC - Increment Memory
+    inc := func () {
+        value := read(cpu, address)
+        write(cpu, address, value)
+        value += 1
+        write(cpu, address, value)
+        setZN(cpu, value)
+    }
+
+    // JMP - Jump
+    jmp := func () {
+        cpu.PC = address
+    }
+
+    // LDA - Load Accumulator
+    lda := func () {
+        cpu.A = read(cpu, address)
+        setZN(cpu, cpu.A)
+    }
+
+    // LDX - Load X Register
+    ldx := func () {
+        cpu.X = read(cpu, address)
+        setZN(cpu, cpu.X)
+    }
+
+    // LDY - Load Y Register
+    ldy := func () {
+        cpu.Y = read(cpu, address)
+        setZN(cpu, cpu.Y)
+    }
+
+    // LSR - Logical Shift Right
+    lsr := func () {
+        if mode == ModeAccumulator {
+            cpu.C = cpu.A & 1
+            cpu.A >>= 1
+            setZN(cpu, cpu.A)
+        } else {
+            value := read(cpu, address)
+            write(cpu, address, value)
+            cpu.C = value & 1
+            value >>= 1
+            write(cpu, address, value)
+            setZN(cpu, value)
+        }
+    }
+
+
+    // ORA - Logical Inclusive OR
+    ora := func () {
+        cpu.A = cpu.A | read(cpu, address)
+        setZN(cpu, cpu.A)
+    }
+
+
+    // PHP - Push Processor Status
+    php := func () {
+        push(cpu, Flags(cpu) | 0x10)
+    }
+
+    // ROL - Rotate Left
+    rol := func () {
+        if mode == ModeAccumulator {
+            c := cpu.C
+            cpu.C = (cpu.A >> 7) & 1
+            cpu.A = (cpu.A << 1) | c
+            setZN(cpu, cpu.A)
+        } else {
+            c := cpu.C
+            value := read(cpu, address)
+            write(cpu, address, value)
+            cpu.C = (value >> 7) & 1
+            value = (value << 1) | c
+            write(cpu, address, value)
+            setZN(cpu, value)
+        }
+    }
+
+    // ROR - Rotate Right
+    ror := func () {
+        if mode == ModeAccumulator {
+            c := cpu.C
+            cpu.C = cpu.A & 1
+            cpu.A = (cpu.A >> 1) | (c << 7)
+            setZN(cpu, cpu.A)
+        } else {
+            c := cpu.C
+            value := read(cpu, address)
+            write(cpu, address, value)
+            cpu.C = value & 1
+            value = (value >> 1) | (c << 7)
+            write(cpu, address, value)
+            setZN(cpu, value)
+        }
+    }
+
+
+    // SBC - Subtract with Carry
+    sbc := func () {
+        add(^read(cpu, address))
+    }
+
+    // SEI - Set Interrupt Disable
+    sei := func () {
+        cpu.I = 1
+    }
+
+    // STA - Store Accumulator
+    sta := func () {
+        write(cpu, address, cpu.A)
+    }
+
+    // STX - Store X Register
+    stx := func () {
+        write(cpu, address, cpu.X)
+    }
+
+    // STY - Store Y Register
+    sty := func () {
+        write(cpu, address, cpu.Y)
+    }
+
+
+    // unofficial opcodes
+
+    // modify makes the accesses of a read-modify-write instruction, writing
+    // the value back unchanged while f works on it, and returns the result
+    modify := func (f func (value byte) byte) byte {
+        value := read(cpu, address)
+        write(cpu, address, value)
+        value = f(value)
+        write(cpu, address, value)
+        return value
+    }
+
+    // ALR - AND then Logical Shift Right
+    alr := func () {
+        cpu.A &= read(cpu, address)
+        cpu.C = cpu.A & 1
+        cpu.A >>= 1
+        setZN(cpu, cpu.A)
+    }
+
+    // ANC - AND, Copying N to C
+    anc := func () {
+        cpu.A &= read(cpu, address)
+        setZN(cpu, cpu.A)
+        cpu.C = cpu.N
+    }
+
+    // ARR - AND then Rotate Right, with C and V from bits 6 and 5
+    arr := func () {
+        cpu.A &= read(cpu, address)
+        cpu.A = (cpu.A >> 1) | (cpu.C << 7)
+        setZN(cpu, cpu.A)
+        cpu.C = (cpu.A >> 6) & 1
+        cpu.V = ((cpu.A >> 6) ^ (cpu.A >> 5)) & 1
+    }
+
+    // AXS - AND X Register with Accumulator then Subtract, without borrow
+    axs := func () {
+        value := read(cpu, address)
+        ax := cpu.A & cpu.X
+        cpu.X = ax - value
+        if ax >= value {
+            cpu.C = 1
+        } else {
+            cpu.C = 0
+        }
+        setZN(cpu, cpu.X)
+    }
+
+    // DCP - Decrement Memory then Compare
+    dcp := func () {
+        value := modify(func (value byte) byte {
+            return value - 1
+        })
+        compare(cpu, cpu.A, value)
+    }
+
+    // ISC - Increment Memory then Subtract with Carry
+    isc := func () {
+        value := modify(func (value byte) byte {
+            return value + 1
+        })
+        add(^value)
+    }
+
+    // LAS - AND Memory with Stack Pointer into A, X and SP
+    las := func () {
+        cpu.SP &= read(cpu, address)
+        cpu.A = cpu.SP
+        cpu.X = cpu.SP
+        setZN(cpu, cpu.SP)
+    }
+
+    // LAX - Load Accumulator and X Register
+    lax := func () {
+        value := read(cpu, address)
+        if mode == ModeImmediate {
+            // unstable: A is ORed with a value that varies from chip to
+            // chip first; $EE is the one usually emulated
+            value &= cpu.A | 0xEE
+        }
+        cpu.A = value
+        cpu.X = value
+        setZN(cpu, value)
+    }
+
+    // RLA - Rotate Left then AND
+    rla := func () {
+        value := modify(func (value byte) byte {
+            c := cpu.C
+            cpu.C = (value >> 7) & 1
+            return (value << 1) | c
+        })
+        cpu.A &= value
+        setZN(cpu, cpu.A)
+    }
+
+    // RRA - Rotate Right then Add with Carry
+    rra := func () {
+        value := modify(func (value byte) byte {
+            c := cpu.C
+            cpu.C = value & 1
+            return (value >> 1) | (c << 7)
+        })
+        add(value)
+    }
+
+    // SLO - Arithmetic Shift Left then Logical Inclusive OR
+    slo := func () {
+        value := modify(func (value byte) byte {
+            cpu.C = (value >> 7) & 1
+            return value << 1
+        })
+        cpu.A |= value
+        setZN(cpu, cpu.A)
+    }
+
+    // SRE - Logical Shift Right then Exclusive OR
+    sre := func () {
+        value := modify(func (value byte) byte {
+            cpu.C = value & 1
+            return value >> 1
+        })
+        cpu.A ^= value
+        setZN(cpu, cpu.A)
+    }
+
+    // XAA - Transfer X to Accumulator then AND; unstable like LAX #imm
+    xaa := func () {
+        cpu.A = (cpu.A | 0xEE) & cpu.X & read(cpu, address)
+        setZN(cpu, cpu.A)
+    }
+
+    // store is SAX, which stores A AND X, and the unstable stores, which
+    // also AND in the high byte of the base address plus one, and when the
+    // indexing crosses a page, store to the page given by the value instead
+    store := func () {
+        value := cpu.A & cpu.X
+        if instruction.Name == "SAX" {
+            write(cpu, address, value)
+            return
+        }
+        index := cpu.Y
+        if instruction.Name == "SHY" {
+            index = cpu.X
+        }
+        base := address - uint16(index)
+        high := byte(base >> 8) + 1
+        switch instruction.Name {
+        case "SHX":
+            value = cpu.X & high
+        case "SHY":
+            value = cpu.Y & high
+        case "AHX":
+            value &= high
+        case "TAS":
+            cpu.SP = value
+            value &= high
+        }
+        if pagesDiffer(base, address) {
+            address = uint16(value) << 8 | address & 0x00FF
+        }
+        write(cpu, address, value)
+    }
+
+
+
+    // branch jumps to address, taking a cycle more, and another if it
+    // lands on a new page
+    branch := func (address uint16) {
+        polled := cpu.polled
+        read(cpu, cpu.PC)
+        if pagesDiffer(cpu.PC, address) {
+            read(cpu, cpu.PC&0xFF00 | address&0x00FF)
+        } else {
+            // a taken branch that stays on its page doesn't poll for
+            // interrupts on its last cycle
+            cpu.polled = polled
+        }
+        cpu.PC = address
+    }
+
+    // nop is NOP, official or not, which still makes the read of its
+    // addressing mode, and KIL
+    nop := func () {
+        switch mode {
+        case ModeAccumulator, ModeImplied, ModeRelative:
+            if instruction.Name == "KIL" {
+                // the CPU jams; keep fetching the same opcode
+                cpu.PC--
+            }
+            return
+        }
+        read(cpu, address)
+    }
+
+    switch opcode {
+    case 0:
+        // BRK - Force Interrupt
+        cpu.PC++
+        push16(cpu, cpu.PC)
+        php()
+        sei()
+        cpu.PC = readVector(cpu, 0xFFFE)
+    case 1:
+        ora()
+    case 2: // KIL
+        nop()
+    case 3:
+        slo()
+    case 4: // NOP
+        nop()
+    case 5:
+        ora()
+    case 6:
+        asl()
+    case 7:
+        slo()
+    case 8:
+        php()
+    case 9:
+        ora()
+    case 10:
+        asl()
+    case 11:
+        anc()
+    case 12: // NOP
+        nop()
+    case 13:
+        ora()
+    case 14:
+        asl()
+    case 15:
+        slo()
+    case 16:
+        // BPL - Branch if Positive
+        if cpu.N == 0 {
+            branch(address)
+        }
+    case 17:
+        ora()
+    case 18: // KIL
+        nop()
+    case 19:
+        slo()
+    case 20: // NOP
+        nop()
+    case 21:
+        ora()
+    case 22:
+        asl()
+    case 23:
+        slo()
+    case 24:
+        // CLC - Clear Carry Flag
+        cpu.C = 0
+    case 25:
+        ora()
+    case 26: // NOP
+        nop()
+    case 27:
+        slo()
+    case 28: // NOP
+        nop()
+    case 29:
+        ora()
+    case 30:
+        asl()
+    case 31:
+        slo()
+    case 32:
+        // JSR - Jump to Subroutine
+        read(cpu, 0x100 | uint16(cpu.SP))
+        push16(cpu, cpu.PC)
+        address |= uint16(read(cpu, cpu.PC)) << 8
+        cpu.PC = address
+    case 33:
+        and()
+    case 34: // KIL
+        nop()
+    case 35:
+        rla()
+    case 36:
+        bit()
+    case 37:
+        and()
+    case 38:
+        rol()
+    case 39:
+        rla()
+    case 40:
+        // PLP - Pull Processor Status
+        read(cpu, 0x100 | uint16(cpu.SP))
+        SetFlags(cpu, pull(cpu)&0xEF | 0x20)
+    case 41:
+        and()
+    case 42:
+        rol()
+    case 43:
+        anc()
+    case 44:
+        bit()
+    case 45:
+        and()
+    case 46:
+        rol()
+    case 47:
+        rla()
+    case 48:
+        // BMI - Branch if Minus
+        if cpu.N != 0 {
+            branch(address)
+        }
+    case 49:
+        and()
+    case 50: // KIL
+        nop()
+    case 51:
+        rla()
+    case 52: // NOP
+        nop()
+    case 53:
+        and()
+    case 54:
+        rol()
+    case 55:
+        rla()
+    case 56:
+        // SEC - Set Carry Flag
+        cpu.C = 1
+    case 57:
+        and()
+    case 58: // NOP
+        nop()
+    case 59:
+        rla()
+    case 60: // NOP
+        nop()
+    case 61:
+        and()
+    case 62:
+        rol()
+    case 63:
+        rla()
+    case 64:
+        // RTI - Return from Interrupt
+        read(cpu, 0x100 | uint16(cpu.SP))
+        SetFlags(cpu, pull(cpu)&0xEF | 0x20)
+        cpu.PC = pull16(cpu)
+    case 65:
+        eor()
+    case 66: // KIL
+        nop()
+    case 67:
+        sre()
+    case 68: // NOP
+        nop()
+    case 69:
+        eor()
+    case 70:
+        lsr()
+    case 71:
+        sre()
+    case 72:
+        // PHA - Push Accumulator
+        push(cpu, cpu.A)
+    case 73:
+        eor()
+    case 74:
+        lsr()
+    case 75:
+        alr()
+    case 76:
+        jmp()
+    case 77:
+        eor()
+    case 78:
+        lsr()
+    case 79:
+        sre()
+    case 80:
+        // BVC - Branch if Overflow Clear
+        if cpu.V == 0 {
+            branch(address)
+        }
+    case 81:
+        eor()
+    case 82: // KIL
+        nop()
+    case 83:
+        sre()
+    case 84: // NOP
+        nop()
+    case 85:
+        eor()
+    case 86:
+        lsr()
+    case 87:
+        sre()
+    case 88:
+        // CLI - Clear Interrupt Disable
+        cpu.I = 0
+    case 89:
+        eor()
+    case 90: // NOP
+        nop()
+    case 91:
+        sre()
+    case 92: // NOP
+        nop()
+    case 93:
+        eor()
+    case 94:
+        lsr()
+    case 95:
+        sre()
+    case 96:
+        // RTS - Return from Subroutine
+        read(cpu, 0x100 | uint16(cpu.SP))
+        cpu.PC = pull16(cpu)
+        read(cpu, cpu.PC)
+        cpu.PC++
+    case 97:
+        adc()
+    case 98: // KIL
+        nop()
+    case 99:
+        rra()
+    case 100: // NOP
+        nop()
+    case 101:
+        adc()
+    case 102:
+        ror()
+    case 103:
+        rra()
+    case 104:
+        // PLA - Pull Accumulator
+        read(cpu, 0x100 | uint16(cpu.SP))
+        cpu.A = pull(cpu)
+        setZN(cpu, cpu.A)
+    case 105:
+        adc()
+    case 106:
+        ror()
+    case 107:
+        arr()
+    case 108:
+        jmp()
+    case 109:
+        adc()
+    case 110:
+        ror()
+    case 111:
+        rra()
+    case 112:
+        // BVS - Branch if Overflow Set
+        if cpu.V != 0 {
+            branch(address)
+        }
+    case 113:
+        adc()
+    case 114: // KIL
+        nop()
+    case 115:
+        rra()
+    case 116: // NOP
+        nop()
+    case 117:
+        adc()
+    case 118:
+        ror()
+    case 119:
+        rra()
+    case 120: // SEI
+        sei()
+    case 121:
+        adc()
+    case 122: // NOP
+        nop()
+    case 123:
+        rra()
+    case 124: // NOP
+        nop()
+    case 125:
+        adc()
+    case 126:
+        ror()
+    case 127:
+        rra()
+    case 128: // NOP
+        nop()
+    case 129: // STA
+        sta()
+    case 130: // NOP
+        nop()
+    case 131:
+        store()
+    case 132: // STY
+        sty()
+    case 133: // STA
+        sta()
+    case 134: // STX
+        stx()
+    case 135:
+        store()
+    case 136:
+        // DEY - Decrement Y Register
+        cpu.Y--
+        setZN(cpu, cpu.Y)
+    case 137: // NOP
+        nop()
+    case 138: // TXA
+        // TXA - Transfer X to Accumulator
+        cpu.A = cpu.X
+        setZN(cpu, cpu.A)
+    case 139:
+        xaa()
+    case 140: // STY
+        sty()
+    case 141: // STA
+        sta()
+    case 142: // STX
+        stx()
+    case 143:
+        store()
+    case 144:
+        // BCC - Branch if Carry Clear
+        if cpu.C == 0 {
+            branch(address)
+        }
+    case 145: // STA
+        sta()
+    case 146: // KIL
+        nop()
+    case 147:
+        store()
+    case 148: // STY
+        sty()
+    case 149: // STA
+        sta()
+    case 150: // STX
+        stx()
+    case 151:
+        store()
+    case 152: // TYA
+        // TYA - Transfer Y to Accumulator
+        cpu.A = cpu.Y
+        setZN(cpu, cpu.A)
+    case 153: // STA
+        sta()
+    case 154:
+        // TXS - Transfer X to Stack Pointer
+        cpu.SP = cpu.X
+    case 155:
+        store()
+    case 156:
+        store()
+    case 157: // STA
+        sta()
+    case 158:
+        store()
+    case 159:
+        store()
+    case 160:
+        ldy()
+    case 161:
+        lda()
+    case 162:
+        ldx()
+    case 163:
+        lax()
+    case 164:
+        ldy()
+    case 165:
+        lda()
+    case 166:
+        ldx()
+    case 167:
+        lax()
+    case 168:
+        // TAY - Transfer Accumulator to Y
+        cpu.Y = cpu.A
+        setZN(cpu, cpu.Y)
+    case 169:
+        lda()
+    case 170:
+        // TAX - Transfer Accumulator to X
+        cpu.X = cpu.A
+        setZN(cpu, cpu.X)
+    case 171:
+        lax()
+    case 172:
+        ldy()
+    case 173:
+        lda()
+    case 174:
+        ldx()
+    case 175:
+        lax()
+    case 176:
+        // BCS - Branch if Carry Set
+        if cpu.C != 0 {
+            branch(address)
+        }
+    case 177:
+        lda()
+    case 178: // KIL
+        nop()
+    case 179:
+        lax()
+    case 180:
+        ldy()
+    case 181:
+        lda()
+    case 182:
+        ldx()
+    case 183:
+        lax()
+    case 184:
+        // CLV - Clear Overflow Flag
+        cpu.V = 0
+    case 185:
+        lda()
+    case 186:
+        // TSX - Transfer Stack Pointer to X    
+        cpu.X = cpu.SP
+        setZN(cpu, cpu.X)
+    case 187:
+        las()
+    case 188:
+        ldy()
+    case 189:
+        lda()
+    case 190:
+        ldx()
+    case 191:
+        lax()
+    case 192:
+        cpy()
+    case 193:
+        cmp()
+    case 194: // NOP
+        nop()
+    case 195:
+        dcp()
+    case 196:
+        cpy()
+    case 197:
+        cmp()
+    case 198:
+        dec()
+    case 199:
+        dcp()
+    case 200:
+        // INY - Increment Y Register
+        cpu.Y++
+        setZN(cpu, cpu.Y)
+    case 201:
+        cmp()
+    case 202:
+        // DEX - Decrement X Register
+        cpu.X--
+        setZN(cpu, cpu.X)
+    case 203:
+        axs()
+    case 204:
+        cpy()
+    case 205:
+        cmp()
+    case 206:
+        dec()
+    case 207:
+        dcp()
+    case 208:
+        // BNE - Branch if Not Equal
+        if cpu.Z == 0 {
+            branch(address)
+        }
+    case 209:
+        cmp()
+    case 210: // KIL
+        nop()
+    case 211:
+        dcp()
+    case 212: // NOP
+        nop()
+    case 213:
+        cmp()
+    case 214:
+        dec()
+    case 215:
+        dcp()
+    case 216:
+        // CLD - Clear Decimal Mode
+        cpu.D = 0
+    case 217:
+        cmp()
+    case 218: // NOP
+        nop()
+    case 219:
+        dcp()
+    case 220: // NOP
+        nop()
+    case 221:
+        cmp()
+    case 222:
+        dec()
+    case 223:
+        dcp()
+    case 224:
+        cpx()
+    case 225:
+        sbc()
+    case 226: // NOP
+        nop()
+    case 227:
+        isc()
+    case 228:
+        cpx()
+    case 229:
+        sbc()
+    case 230:
+        inc()
+    case 231:
+        isc()
+    case 232:
+        // INX - Increment X Register
+        cpu.X++
+        setZN(cpu, cpu.X)
+    case 233:
+        sbc()
+    case 234: // NOP
+        nop()
+    case 235:
+        sbc()
+    case 236:
+        cpx()
+    case 237:
+        sbc()
+    case 238:
+        inc()
+    case 239:
+        isc()
+    case 240:
+        // BEQ - Branch if Equal
+        if cpu.Z != 0 {
+            branch(address)
+        }
+    case 241:
+        sbc()
+    case 242: // KIL
+        nop()
+    case 243:
+        isc()
+    case 244: // NOP
+        nop()
+    case 245:
+        sbc()
+    case 246:
+        inc()
+    case 247:
+        isc()
+    case 248:
+        // SED - Set Decimal Flag
+        cpu.D = 1
+    case 249:
+        sbc()
+    case 250: // NOP
+        nop()
+    case 251:
+        isc()
+    case 252: // NOP
+        nop()
+    case 253:
+        sbc()
+    case 254:
+        inc()
+    case 255:
+        isc()
+
+    }
+
+}
+
+
+// Reset runs the reset sequence: the interrupt sequence with its stack
+// writes turned into reads, so only the stack pointer moves
+func Reset(cpu *CPU) {
+    cpu.nmiPending = false
+    read(cpu, cpu.PC)
+    read(cpu, cpu.PC)
+    for i := 0; i < 3; i++ {
+        read(cpu, 0x100 | uint16(cpu.SP))
+        cpu.SP--
+    }
+    cpu.I = 1
+    lo := uint16(read(cpu, 0xFFFC))
+    hi := uint16(read(cpu, 0xFFFD))
+    cpu.PC = hi<<8 | lo
+    cpu.polled = interruptNone
+}
+
+// instruction helper functions
+
+func compare(cpu *CPU, a, b byte) {
+    setZN(cpu, a - b)
+    if a >= b {
+        cpu.C = 1
+    } else {
+        cpu.C = 0
+    }
+}
+
+// read16bug emulates a 6502 bug that caused the low byte to wrap without
+// incrementing the high byte
+func read16bug(cpu *CPU, address uint16) uint16 {
+    a := address
+    b := (a & 0xFF00) | uint16(byte(a)+1)
+    lo := read(cpu, a)
+    hi := read(cpu, b)
+    return uint16(hi)<<8 | uint16(lo)
+}
+
+// read16pc fetches a two byte operand from the instruction stream
+func read16pc(cpu *CPU) uint16 {
+    lo := uint16(read(cpu, cpu.PC))
+    hi := uint16(read(cpu, cpu.PC + 1))
+    cpu.PC += 2
+    return hi<<8 | lo
+}
+
+// readVector fetches an interrupt vector. An NMI that arrives before the
+// vector is fetched takes it over, even in the middle of a BRK or IRQ.
+func readVector(cpu *CPU, vector uint16) uint16 {
+    if cpu.nmiPending {
+        vector = 0xFFFA
+        cpu.nmiPending = false
+    }
+    lo := uint16(read(cpu, vector))
+    hi := uint16(read(cpu, vector + 1))
+    return hi<<8 | lo
+}
+
+// pagesDiffer returns true if the two addresses reference different pages
+func pagesDiffer(a, b uint16) bool {
+    return a&0xFF00 != b&0xFF00
+}
+
+
+// push pushes a byte onto the stack
+func push(cpu *CPU, value byte) {
+    write(cpu, 0x100|uint16(cpu.SP), value)
+    cpu.SP--
+}
+
+// pull pops a byte from the stack
+func pull(cpu *CPU) byte {
+    cpu.SP++
+    return read(cpu, 0x100 | uint16(cpu.SP))
+}
+
+// push16 pushes two bytes onto the stack
+func push16(cpu *CPU, value uint16) {
+    hi := byte(value >> 8)
+    lo := byte(value & 0xFF)
+    push(cpu, hi)
+    push(cpu, lo)
+}
+
+// pull16 pops two bytes from the stack
+func pull16(cpu *CPU) uint16 {
+    lo := uint16(pull(cpu))
+    hi := uint16(pull(cpu))
+    return hi<<8 | lo
+}
+
+// sets the processor status flags
+func SetFlags(cpu *CPU, flags byte) {
+    cpu.C = (flags >> 0) & 1
+    cpu.Z = (flags >> 1) & 1
+    cpu.I = (flags >> 2) & 1
+    cpu.D = (flags >> 3) & 1
+    cpu.B = (flags >> 4) & 1
+    cpu.U = (flags >> 5) & 1
+    cpu.V = (flags >> 6) & 1
+    cpu.N = (flags >> 7) & 1
+}
+
+// setZ sets the zero flag if the argument is zero
+func setZ(cpu *CPU, value byte) {
+    if value == 0 {
+        cpu.Z = 1
+    } else {
+        cpu.Z = 0
+    }
+}
+
+// setN sets the negative flag if the argument is negative (high bit is set)
+func setN(cpu *CPU, value byte) {
+    if value&0x80 != 0 {
+        cpu.N = 1
+    } else {
+        cpu.N = 0
+    }
+}
+
+// setZN sets the zero flag and the negative flag
+func setZN(cpu *CPU, value byte) {
+    setZ(cpu, value)
+    setN(cpu, value)
+}
+
+// Flags returns the processor status flags packed into a byte
+func Flags(cpu *CPU) byte {
+    var flags byte
+    flags |= cpu.C << 0
+    flags |= cpu.Z << 1
+    flags |= cpu.I << 2
+    flags |= cpu.D << 3
+    flags |= cpu.B << 4
+    flags |= cpu.U << 5
+    flags |= cpu.V << 6
+    flags |= cpu.N << 7
+    return flags
+}
+
+// read is a CPU read cycle. The CPU polls for interrupts on each cycle;
+// what it saw on an instruction's last cycle decides whether an interrupt
+// comes before the next instruction.
+func read(cpu *CPU, address uint16) byte {
+    value := cpu.Bus.Read(address)
+    poll(cpu)
+    cpu.Cycles++
+    return value
+}
+
+// write is a CPU write cycle, see read
+func write(cpu *CPU, address uint16, value byte) {
+    cpu.Bus.Write(address, value)
+    poll(cpu)
+    cpu.Cycles++
+}
+
+// poll samples the interrupt lines. NMI is edge triggered, so one stays
+// pending until it's taken; IRQ is level triggered, and only counts while
+// the I flag is clear.
+func poll(cpu *CPU) {
+    nmi := cpu.Bus.NMI()
+    if nmi && !cpu.nmiLine {
+        cpu.nmiPending = true
+    }
+    cpu.nmiLine = nmi
+    switch {
+    case cpu.nmiPending:
+        cpu.polled = interruptNMI
+    case cpu.I == 0 && cpu.Bus.IRQ():
+        cpu.polled = interruptIRQ
+    default:
+        cpu.polled = interruptNone
+    }
+}
+
+// executeInterrupt runs the 7 cycle interrupt sequence, which is BRK's
+// except that the opcode fetch is thrown away and B is pushed clear
+func executeInterrupt(cpu *CPU) {
+    vector := InterruptVector(cpu)
+    read(cpu, cpu.PC)
+    read(cpu, cpu.PC)
+    push16(cpu, cpu.PC)
+    push(cpu, Flags(cpu) &^ 0x10 | 0x20)
+    cpu.I = 1
+    cpu.PC = readVector(cpu, vector)
+    cpu.polled = interruptNone
+}
+
+// accessKinds says how each opcode uses its operand's memory
+var accessKinds [256]byte
+
+func init() {
+    for i, instruction := range Instructions {
+        switch instruction.Name {
+        case "STA", "STX", "STY", "SAX", "AHX", "SHX", "SHY", "TAS":
+            accessKinds[i] = accessWrite
+        case "ASL", "LSR", "ROL", "ROR", "INC", "DEC",
+                "SLO", "RLA", "SRE", "RRA", "DCP", "ISC":
+            accessKinds[i] = accessModify
+        default:
+            accessKinds[i] = accessRead
+        }
+    }
+}
+
+func (bus *FlatBus) Read(address uint16) byte {
+    value := bus.RAM[address]
+    if bus.Record {
+        bus.Cycles = append(bus.Cycles, BusCycle{address, value, false})
+    }
+    return value
+}
+
+func (bus *FlatBus) Write(address uint16, value byte) {
+    bus.RAM[address] = value
+    if bus.Record {
+        bus.Cycles = append(bus.Cycles, BusCycle{address, value, true})
+    }
+}
+
+func (bus *FlatBus) NMI() bool {
+    return false
+}
+
+func (bus *FlatBus) IRQ() bool {
+    return false
+}
@@ -0,0 +1,349 @@
+package cpu6502
+
+// Bus is what the CPU is connected to. The CPU makes one Read or Write
+// for each of its cycles, dummy accesses included, so a bus that runs
+// other hardware alongside can step it there. After each access the CPU
+// samples the interrupt lines; NMI and IRQ report whether they're
+// asserted.
+type Bus interface {
+    Read(address uint16) byte
+    Write(address uint16, value byte)
+    NMI() bool
+    IRQ() bool
+}
+
+type CPU struct {
+    Bus Bus
+    Cycles uint64 // number of cycles
+    PC uint16 // program counter
+    SP byte   // stack pointer
+    A byte   // accumulator
+    X byte   // x register
+    Y byte   // y register
+    C byte   // carry flag
+    Z byte   // zero flag
+    I byte   // interrupt disable flag
+    D byte   // decimal mode flag
+    B byte   // break command flag
+    U byte   // unused flag
+    V byte   // overflow flag
+    N byte   // negative flag
+    nmiLine bool     // the NMI line when last sampled
+    nmiPending bool  // an NMI edge was seen and the NMI hasn't been taken
+    polled byte      // interrupt pending when last polled, performed before the next instruction
+}
+
+// 64K of plain RAM and no interrupts, for running the CPU on its own
+type FlatBus struct {
+    RAM    [0x10000]byte
+    Cycles []BusCycle // every access, if Record is set
+    Record bool
+}
+
+type BusCycle struct {
+    Address uint16
+    Value   byte
+    Write   bool
+}
+
+type Instruction struct {
+    Opcode byte
+    Name string
+    Mode byte        // the addressing mode
+    Size byte        // the size in bytes
+    Cycles byte      // the number of cycles used (not including conditional cycles)
+    PageCycles byte  // the number of cycles used when a page is crossed
+}
+
+// interrupt types
+const (
+    _ = iota
+    interruptNone
+    interruptNMI
+    interruptIRQ
+)
+
+// kinds of memory access, see accessKinds
+const (
+    accessRead = iota
+    accessWrite
+    accessModify
+)
+
+// addressing modes
+const (
+    _ = iota
+    ModeAbsolute
+    ModeAbsoluteX
+    ModeAbsoluteY
+    ModeAccumulator
+    ModeImmediate
+    ModeImplied
+    ModeIndexedIndirect
+    ModeIndirect
+    ModeIndirectIndexed
+    ModeRelative
+    ModeZeroPage
+    ModeZeroPageX
+    ModeZeroPageY
+)
+
+var Instructions = [256]Instruction{
+    // don't really need .Opcode but makes the list more readable
+    Instruction{Opcode: 0, Name: "BRK", Mode: 6, Size: 1, Cycles: 7, PageCycles: 0},
+    Instruction{Opcode: 1, Name: "ORA", Mode: 7, Size: 2, Cycles: 6, PageCycles: 0},
+    Instruction{Opcode: 2, Name: "KIL", Mode: 6, Size: 1, Cycles: 2, PageCycles: 0},
+    Instruction{Opcode: 3, Name: "SLO", Mode: 7, Size: 2, Cycles: 8, PageCycles: 0},
+    Instruction{Opcode: 4, Name: "NOP", Mode: 11, Size: 2, Cycles: 3, PageCycles: 0},
+    Instruction{Opcode: 5, Name: "ORA", Mode: 11, Size: 2, Cycles: 3, PageCycles: 0},
+    Instruction{Opcode: 6, Name: "ASL", Mode: 11, Size: 2, Cycles: 5, PageCycles: 0},
+    Instruction{Opcode: 7, Name: "SLO", Mode: 11, Size: 2, Cycles: 5, PageCycles: 0},
+    Instruction{Opcode: 8, Name: "PHP", Mode: 6, Size: 1, Cycles: 3, PageCycles: 0},
+    Instruction{Opcode: 9, Name: "ORA", Mode: 5, Size: 2, Cycles: 2, PageCycles: 0},
+    Instruction{Opcode: 10, Name: "ASL", Mode: 4, Size: 1, Cycles: 2, PageCycles: 0},
+    Instruction{Opcode: 11, Name: "ANC", Mode: 5, Size: 2, Cycles: 2, PageCycles: 0},
+    Instruction{Opcode: 12, Name: "NOP", Mode: 1, Size: 3, Cycles: 4, PageCycles: 0},
+    Instruction{Opcode: 13, Name: "ORA", Mode: 1, Size: 3, Cycles: 4, PageCycles: 0},
+    Instruction{Opcode: 14, Name: "ASL", Mode: 1, Size: 3, Cycles: 6, PageCycles: 0},
+    Instruction{Opcode: 15, Name: "SLO", Mode: 1, Size: 3, Cycles: 6, PageCycles: 0},
+    Instruction{Opcode: 16, Name: "BPL", Mode: 10, Size: 2, Cycles: 2, PageCycles: 1},
+    Instruction{Opcode: 17, Name: "ORA", Mode: 9, Size: 2, Cycles: 5, PageCycles: 1},
+    Instruction{Opcode: 18, Name: "KIL", Mode: 6, Size: 1, Cycles: 2, PageCycles: 0},
+    Instruction{Opcode: 19, Name: "SLO", Mode: 9, Size: 2, Cycles: 8, PageCycles: 0},
+    Instruction{Opcode: 20, Name: "NOP", Mode: 12, Size: 2, Cycles: 4, PageCycles: 0},
+    Instruction{Opcode: 21, Name: "ORA", Mode: 12, Size: 2, Cycles: 4, PageCycles: 0},
+    Instruction{Opcode: 22, Name: "ASL", Mode: 12, Size: 2, Cycles: 6, PageCycles: 0},
+    Instruction{Opcode: 23, Name: "SLO", Mode: 12, Size: 2, Cycles: 6, PageCycles: 0},
+    Instruction{Opcode: 24, Name: "CLC", Mode: 6, Size: 1, Cycles: 2, PageCycles: 0},
+    Instruction{Opcode: 25, Name: "ORA", Mode: 3, Size: 3, Cycles: 4, PageCycles: 1},
+    Instruction{Opcode: 26, Name: "NOP", Mode: 6, Size: 1, Cycles: 2, PageCycles: 0},
+    Instruction{Opcode: 27, Name: "SLO", Mode: 3, Size: 3, Cycles: 7, PageCycles: 0},
+    Instruction{Opcode: 28, Name: "NOP", Mode: 2, Size: 3, Cycles: 4, PageCycles: 1},
+    Instruction{Opcode: 29, Name: "ORA", Mode: 2, Size: 3, Cycles: 4, PageCycles: 1},
+    Instruction{Opcode: 30, Name: "ASL", Mode: 2, Size: 3, Cycles: 7, PageCycles: 0},
+    Instruction{Opcode: 31, Name: "SLO", Mode: 2, Size: 3, Cycles: 7, PageCycles: 0},
+    Instruction{Opcode: 32, Name: "JSR", Mode: 1, Size: 3, Cycles: 6, PageCycles: 0},
+    Instruction{Opcode: 33, Name: "AND", Mode: 7, Size: 2, Cycles: 6, PageCycles: 0},
+    Instruction{Opcode: 34, Name: "KIL", Mode: 6, Size: 1, Cycles: 2, PageCycles: 0},
+    Instruction{Opcode: 35, Name: "RLA", Mode: 7, Size: 2, Cycles: 8, PageCycles: 0},
+    Instruction{Opcode: 36, Name: "BIT", Mode: 11, Size: 2, Cycles: 3, PageCycles: 0},
+    Instruction{Opcode: 37, Name: "AND", Mode: 11, Size: 2, Cycles: 3, PageCycles: 0},
+    Instruction{Opcode: 38, Name: "ROL", Mode: 11, Size: 2, Cycles: 5, PageCycles: 0},
+    Instruction{Opcode: 39, Name: "RLA", Mode: 11, Size: 2, Cycles: 5, PageCycles: 0},
+    Instruction{Opcode: 40, Name: "PLP", Mode: 6, Size: 1, Cycles: 4, PageCycles: 0},
+    Instruction{Opcode: 41, Name: "AND", Mode: 5, Size: 2, Cycles: 2, PageCycles: 0},
+    Instruction{Opcode: 42, Name: "ROL", Mode: 4, Size: 1, Cycles: 2, PageCycles: 0},
+    Instruction{Opcode: 43, Name: "ANC", Mode: 5, Size: 2, Cycles: 2, PageCycles: 0},
+    Instruction{Opcode: 44, Name: "BIT", Mode: 1, Size: 3, Cycles: 4, PageCycles: 0},
+    Instruction{Opcode: 45, Name: "AND", Mode: 1, Size: 3, Cycles: 4, PageCycles: 0},
+    Instruction{Opcode: 46, Name: "ROL", Mode: 1, Size: 3, Cycles: 6, PageCycles: 0},
+    Instruction{Opcode: 47, Name: "RLA", Mode: 1, Size: 3, Cycles: 6, PageCycles: 0},
+    Instruction{Opcode: 48, Name: "BMI", Mode: 10, Size: 2, Cycles: 2, PageCycles: 1},
+    Instruction{Opcode: 49, Name: "AND", Mode: 9, Size: 2, Cycles: 5, PageCycles: 1},
+    Instruction{Opcode: 50, Name: "KIL", Mode: 6, Size: 1, Cycles: 2, PageCycles: 0},
+    Instruction{Opcode: 51, Name: "RLA", Mode: 9, Size: 2, Cycles: 8, PageCycles: 0},
+    Instruction{Opcode: 52, Name: "NOP", Mode: 12, Size: 2, Cycles: 4, PageCycles: 0},
+    Instruction{Opcode: 53, Name: "AND", Mode: 12, Size: 2, Cycles: 4, PageCycles: 0},
+    Instruction{Opcode: 54, Name: "ROL", Mode: 12, Size: 2, Cycles: 6, PageCycles: 0},
+    Instruction{Opcode: 55, Name: "RLA", Mode: 12, Size: 2, Cycles: 6, PageCycles: 0},
+    Instruction{Opcode: 56, Name: "SEC", Mode: 6, Size: 1, Cycles: 2, PageCycles: 0},
+    Instruction{Opcode: 57, Name: "AND", Mode: 3, Size: 3, Cycles: 4, PageCycles: 1},
+    Instruction{Opcode: 58, Name: "NOP", Mode: 6, Size: 1, Cycles: 2, PageCycles: 0},
+    Instruction{Opcode: 59, Name: "RLA", Mode: 3, Size: 3, Cycles: 7, PageCycles: 0},
+    Instruction{Opcode: 60, Name: "NOP", Mode: 2, Size: 3, Cycles: 4, PageCycles: 1},
+    Instruction{Opcode: 61, Name: "AND", Mode: 2, Size: 3, Cycles: 4, PageCycles: 1},
+    Instruction{Opcode: 62, Name: "ROL", Mode: 2, Size: 3, Cycles: 7, PageCycles: 0},
+    Instruction{Opcode: 63, Name: "RLA", Mode: 2, Size: 3, Cycles: 7, PageCycles: 0},
+    Instruction{Opcode: 64, Name: "RTI", Mode: 6, Size: 1, Cycles: 6, PageCycles: 0},
+    Instruction{Opcode: 65, Name: "EOR", Mode: 7, Size: 2, Cycles: 6, PageCycles: 0},
+    Instruction{Opcode: 66, Name: "KIL", Mode: 6, Size: 1, Cycles: 2, PageCycles: 0},
+    Instruction{Opcode: 67, Name: "SRE", Mode: 7, Size: 2, Cycles: 8, PageCycles: 0},
+    Instruction{Opcode: 68, Name: "NOP", Mode: 11, Size: 2, Cycles: 3, PageCycles: 0},
+    Instruction{Opcode: 69, Name: "EOR", Mode: 11, Size: 2, Cycles: 3, PageCycles: 0},
+    Instruction{Opcode: 70, Name: "LSR", Mode: 11, Size: 2, Cycles: 5, PageCycles: 0},
+    Instruction{Opcode: 71, Name: "SRE", Mode: 11, Size: 2, Cycles: 5, PageCycles: 0},
+    Instruction{Opcode: 72, Name: "PHA", Mode: 6, Size: 1, Cycles: 3, PageCycles: 0},
+    Instruction{Opcode: 73, Name: "EOR", Mode: 5, Size: 2, Cycles: 2, PageCycles: 0},
+    Instruction{Opcode: 74, Name: "LSR", Mode: 4, Size: 1, Cycles: 2, PageCycles: 0},
+    Instruction{Opcode: 75, Name: "ALR", Mode: 5, Size: 2, Cycles: 2, PageCycles: 0},
+    Instruction{Opcode: 76, Name: "JMP", Mode: 1, Size: 3, Cycles: 3, PageCycles: 0},
+    Instruction{Opcode: 77, Name: "EOR", Mode: 1, Size: 3, Cycles: 4, PageCycles: 0},
+    Instruction{Opcode: 78, Name: "LSR", Mode: 1, Size: 3, Cycles: 6, PageCycles: 0},
+    Instruction{Opcode: 79, Name: "SRE", Mode: 1, Size: 3, Cycles: 6, PageCycles: 0},
+    Instruction{Opcode: 80, Name: "BVC", Mode: 10, Size: 2, Cycles: 2, PageCycles: 1},
+    Instruction{Opcode: 81, Name: "EOR", Mode: 9, Size: 2, Cycles: 5, PageCycles: 1},
+    Instruction{Opcode: 82, Name: "KIL", Mode: 6, Size: 1, Cycles: 2, PageCycles: 0},
+    Instruction{Opcode: 83, Name: "SRE", Mode: 9, Size: 2, Cycles: 8, PageCycles: 0},
+    Instruction{Opcode: 84, Name: "NOP", Mode: 12, Size: 2, Cycles: 4, PageCycles: 0},
+    Instruction{Opcode: 85, Name: "EOR", Mode: 12, Size: 2, Cycles: 4, PageCycles: 0},
+    Instruction{Opcode: 86, Name: "LSR", Mode: 12, Size: 2, Cycles: 6, PageCycles: 0},
+    Instruction{Opcode: 87, Name: "SRE", Mode: 12, Size: 2, Cycles: 6, PageCycles: 0},
+    Instruction{Opcode: 88, Name: "CLI", Mode: 6, Size: 1, Cycles: 2, PageCycles: 0},
+    Instruction{Opcode: 89, Name: "EOR", Mode: 3, Size: 3, Cycles: 4, PageCycles: 1},
+    Instruction{Opcode: 90, Name: "NOP", Mode: 6, Size: 1, Cycles: 2, PageCycles: 0},
+    Instruction{Opcode: 91, Name: "SRE", Mode: 3, Size: 3, Cycles: 7, PageCycles: 0},
+    Instruction{Opcode: 92, Name: "NOP", Mode: 2, Size: 3, Cycles: 4, PageCycles: 1},
+    Instruction{Opcode: 93, Name: "EOR", Mode: 2, Size: 3, Cycles: 4, PageCycles: 1},
+    Instruction{Opcode: 94, Name: "LSR", Mode: 2, Size: 3, Cycles: 7, PageCycles: 0},
+    Instruction{Opcode: 95, Name: "SRE", Mode: 2, Size: 3, Cycles: 7, PageCycles: 0},
+    Instruction{Opcode: 96, Name: "RTS", Mode: 6, Size: 1, Cycles: 6, PageCycles: 0},
+    Instruction{Opcode: 97, Name: "ADC", Mode: 7, Size: 2, Cycles: 6, PageCycles: 0},
+    Instruction{Opcode: 98, Name: "KIL", Mode: 6, Size: 1, Cycles: 2, PageCycles: 0},
+    Instruction{Opcode: 99, Name: "RRA", Mode: 7, Size: 2, Cycles: 8, PageCycles: 0},
+    Instruction{Opcode: 100, Name: "NOP", Mode: 11, Size: 2, Cycles: 3, PageCycles: 0},
+    Instruction{Opcode: 101, Name: "ADC", Mode: 11, Size: 2, Cycles: 3, PageCycles: 0},
+    Instruction{Opcode: 102, Name: "ROR", Mode: 11, Size: 2, Cycles: 5, PageCycles: 0},
+    Instruction{Opcode: 103, Name: "RRA", Mode: 11, Size: 2, Cycles: 5, PageCycles: 0},
+    Instruction{Opcode: 104, Name: "PLA", Mode: 6, Size: 1, Cycles: 4, PageCycles: 0},
+    Instruction{Opcode: 105, Name: "ADC", Mode: 5, Size: 2, Cycles: 2, PageCycles: 0},
+    Instruction{Opcode: 106, Name: "ROR", Mode: 4, Size: 1, Cycles: 2, PageCycles: 0},
+    Instruction{Opcode: 107, Name: "ARR", Mode: 5, Size: 2, Cycles: 2, PageCycles: 0},
+    Instruction{Opcode: 108, Name: "JMP", Mode: 8, Size: 3, Cycles: 5, PageCycles: 0},
+    Instruction{Opcode: 109, Name: "ADC", Mode: 1, Size: 3, Cycles: 4, PageCycles: 0},
+    Instruction{Opcode: 110, Name: "ROR", Mode: 1, Size: 3, Cycles: 6, PageCycles: 0},
+    Instruction{Opcode: 111, Name: "RRA", Mode: 1, Size: 3, Cycles: 6, PageCycles: 0},
+    Instruction{Opcode: 112, Name: "BVS", Mode: 10, Size: 2, Cycles: 2, PageCycles: 1},
+    Instruction{Opcode: 113, Name: "ADC", Mode: 9, Size: 2, Cycles: 5, PageCycles: 1},
+    Instruction{Opcode: 114, Name: "KIL", Mode: 6, Size: 1, Cycles: 2, PageCycles: 0},
+    Instruction{Opcode: 115, Name: "RRA", Mode: 9, Size: 2, Cycles: 8, PageCycles: 0},
+    Instruction{Opcode: 116, Name: "NOP", Mode: 12, Size: 2, Cycles: 4, PageCycles: 0},
+    Instruction{Opcode: 117, Name: "ADC", Mode: 12, Size: 2, Cycles: 4, PageCycles: 0},
+    Instruction{Opcode: 118, Name: "ROR", Mode: 12, Size: 2, Cycles: 6, PageCycles: 0},
+    Instruction{Opcode: 119, Name: "RRA", Mode: 12, Size: 2, Cycles: 6, PageCycles: 0},
+    Instruction{Opcode: 120, Name: "SEI", Mode: 6, Size: 1, Cycles: 2, PageCycles: 0},
+    Instruction{Opcode: 121, Name: "ADC", Mode: 3, Size: 3, Cycles: 4, PageCycles: 1},
+    Instruction{Opcode: 122, Name: "NOP", Mode: 6, Size: 1, Cycles: 2, PageCycles: 0},
+    Instruction{Opcode: 123, Name: "RRA", Mode: 3, Size: 3, Cycles: 7, PageCycles: 0},
+    Instruction{Opcode: 124, Name: "NOP", Mode: 2, Size: 3, Cycles: 4, PageCycles: 1},
+    Instruction{Opcode: 125, Name: "ADC", Mode: 2, Size: 3, Cycles: 4, PageCycles: 1},
+    Instruction{Opcode: 126, Name: "ROR", Mode: 2, Size: 3, Cycles: 7, PageCycles: 0},
+    Instruction{Opcode: 127, Name: "RRA", Mode: 2, Size: 3, Cycles: 7, PageCycles: 0},
+    Instruction{Opcode: 128, Name: "NOP", Mode: 5, Size: 2, Cycles: 2, PageCycles: 0},
+    Instruction{Opcode: 129, Name: "STA", Mode: 7, Size: 2, Cycles: 6, PageCycles: 0},
+    Instruction{Opcode: 130, Name: "NOP", Mode: 5, Size: 2, Cycles: 2, PageCycles: 0},
+    Instruction{Opcode: 131, Name: "SAX", Mode: 7, Size: 2, Cycles: 6, PageCycles: 0},
+    Instruction{Opcode: 132, Name: "STY", Mode: 11, Size: 2, Cycles: 3, PageCycles: 0},
+    Instruction{Opcode: 133, Name: "STA", Mode: 11, Size: 2, Cycles: 3, PageCycles: 0},
+    Instruction{Opcode: 134, Name: "STX", Mode: 11, Size: 2, Cycles: 3, PageCycles: 0},
+    Instruction{Opcode: 135, Name: "SAX", Mode: 11, Size: 2, Cycles: 3, PageCycles: 0},
+    Instruction{Opcode: 136, Name: "DEY", Mode: 6, Size: 1, Cycles: 2, PageCycles: 0},
+    Instruction{Opcode: 137, Name: "NOP", Mode: 5, Size: 2, Cycles: 2, PageCycles: 0},
+    Instruction{Opcode: 138, Name: "TXA", Mode: 6, Size: 1, Cycles: 2, PageCycles: 0},
+    Instruction{Opcode: 139, Name: "XAA", Mode: 5, Size: 2, Cycles: 2, PageCycles: 0},
+    Instruction{Opcode: 140, Name: "STY", Mode: 1, Size: 3, Cycles: 4, PageCycles: 0},
+    Instruction{Opcode: 141, Name: "STA", Mode: 1, Size: 3, Cycles: 4, PageCycles: 0},
+    Instruction{Opcode: 142, Name: "STX", Mode: 1, Size: 3, Cycles: 4, PageCycles: 0},
+    Instruction{Opcode: 143, Name: "SAX", Mode: 1, Size: 3, Cycles: 4, PageCycles: 0},
+    Instruction{Opcode: 144, Name: "BCC", Mode: 10, Size: 2, Cycles: 2, PageCycles: 1},
+    Instruction{Opcode: 145, Name: "STA", Mode: 9, Size: 2, Cycles: 6, PageCycles: 0},
+    Instruction{Opcode: 146, Name: "KIL", Mode: 6, Size: 1, Cycles: 2, PageCycles: 0},
+    Instruction{Opcode: 147, Name: "AHX", Mode: 9, Size: 2, Cycles: 6, PageCycles: 0},
+    Instruction{Opcode: 148, Name: "STY", Mode: 12, Size: 2, Cycles: 4, PageCycles: 0},
+    Instruction{Opcode: 149, Name: "STA", Mode: 12, Size: 2, Cycles: 4, PageCycles: 0},
+    Instruction{Opcode: 150, Name: "STX", Mode: 13, Size: 2, Cycles: 4, PageCycles: 0},
+    Instruction{Opcode: 151, Name: "SAX", Mode: 13, Size: 2, Cycles: 4, PageCycles: 0},
+    Instruction{Opcode: 152, Name: "TYA", Mode: 6, Size: 1, Cycles: 2, PageCycles: 0},
+    Instruction{Opcode: 153, Name: "STA", Mode: 3, Size: 3, Cycles: 5, PageCycles: 0},
+    Instruction{Opcode: 154, Name: "TXS", Mode: 6, Size: 1, Cycles: 2, PageCycles: 0},
+    Instruction{Opcode: 155, Name: "TAS", Mode: 3, Size: 3, Cycles: 5, PageCycles: 0},
+    Instruction{Opcode: 156, Name: "SHY", Mode: 2, Size: 3, Cycles: 5, PageCycles: 0},
+    Instruction{Opcode: 157, Name: "STA", Mode: 2, Size: 3, Cycles: 5, PageCycles: 0},
+    Instruction{Opcode: 158, Name: "SHX", Mode: 3, Size: 3, Cycles: 5, PageCycles: 0},
+    Instruction{Opcode: 159, Name: "AHX", Mode: 3, Size: 3, Cycles: 5, PageCycles: 0},
+    Instruction{Opcode: 160, Name: "LDY", Mode: 5, Size: 2, Cycles: 2, PageCycles: 0},
+    Instruction{Opcode: 161, Name: "LDA", Mode: 7, Size: 2, Cycles: 6, PageCycles: 0},
+    Instruction{Opcode: 162, Name: "LDX", Mode: 5, Size: 2, Cycles: 2, PageCycles: 0},
+    Instruction{Opcode: 163, Name: "LAX", Mode: 7, Size: 2, Cycles: 6, PageCycles: 0},
+    Instruction{Opcode: 164, Name: "LDY", Mode: 11, Size: 2, Cycles: 3, PageCycles: 0},
+    Instruction{Opcode: 165, Name: "LDA", Mode: 11, Size: 2, Cycles: 3, PageCycles: 0},
+    Instruction{Opcode: 166, Name: "LDX", Mode: 11, Size: 2, Cycles: 3, PageCycles: 0},
+    Instruction{Opcode: 167, Name: "LAX", Mode: 11, Size: 2, Cycles: 3, PageCycles: 0},
+    Instruction{Opcode: 168, Name: "TAY", Mode: 6, Size: 1, Cycles: 2, PageCycles: 0},
+    Instruction{Opcode: 169, Name: "LDA", Mode: 5, Size: 2, Cycles: 2, PageCycles: 0},
+    Instruction{Opcode: 170, Name: "TAX", Mode: 6, Size: 1, Cycles: 2, PageCycles: 0},
+    Instruction{Opcode: 171, Name: "LAX", Mode: 5, Size: 2, Cycles: 2, PageCycles: 0},
+    Instruction{Opcode: 172, Name: "LDY", Mode: 1, Size: 3, Cycles: 4, PageCycles: 0},
+    Instruction{Opcode: 173, Name: "LDA", Mode: 1, Size: 3, Cycles: 4, PageCycles: 0},
+    Instruction{Opcode: 174, Name: "LDX", Mode: 1, Size: 3, Cycles: 4, PageCycles: 0},
+    Instruction{Opcode: 175, Name: "LAX", Mode: 1, Size: 3, Cycles: 4, PageCycles: 0},
+    Instruction{Opcode: 176, Name: "BCS", Mode: 10, Size: 2, Cycles: 2, PageCycles: 1},
+    Instruction{Opcode: 177, Name: "LDA", Mode: 9, Size: 2, Cycles: 5, PageCycles: 1},
+    Instruction{Opcode: 178, Name: "KIL", Mode: 6, Size: 1, Cycles: 2, PageCycles: 0},
+    Instruction{Opcode: 179, Name: "LAX", Mode: 9, Size: 2, Cycles: 5, PageCycles: 1},
+    Instruction{Opcode: 180, Name: "LDY", Mode: 12, Size: 2, Cycles: 4, PageCycles: 0},
+    Instruction{Opcode: 181, Name: "LDA", Mode: 12, Size: 2, Cycles: 4, PageCycles: 0},
+    Instruction{Opcode: 182, Name: "LDX", Mode: 13, Size: 2, Cycles: 4, PageCycles: 0},
+    Instruction{Opcode: 183, Name: "LAX", Mode: 13, Size: 2, Cycles: 4, PageCycles: 0},
+    Instruction{Opcode: 184, Name: "CLV", Mode: 6, Size: 1, Cycles: 2, PageCycles: 0},
+    Instruction{Opcode: 185, Name: "LDA", Mode: 3, Size: 3, Cycles: 4, PageCycles: 1},
+    Instruction{Opcode: 186, Name: "TSX", Mode: 6, Size: 1, Cycles: 2, PageCycles: 0},
+    Instruction{Opcode: 187, Name: "LAS", Mode: 3, Size: 3, Cycles: 4, PageCycles: 1},
+    Instruction{Opcode: 188, Name: "LDY", Mode: 2, Size: 3, Cycles: 4, PageCycles: 1},
+    Instruction{Opcode: 189, Name: "LDA", Mode: 2, Size: 3, Cycles: 4, PageCycles: 1},
+    Instruction{Opcode: 190, Name: "LDX", Mode: 3, Size: 3, Cycles: 4, PageCycles: 1},
+    Instruction{Opcode: 191, Name: "LAX", Mode: 3, Size: 3, Cycles: 4, PageCycles: 1},
+    Instruction{Opcode: 192, Name: "CPY", Mode: 5, Size: 2, Cycles: 2, PageCycles: 0},
+    Instruction{Opcode: 193, Name: "CMP", Mode: 7, Size: 2, Cycles: 6, PageCycles: 0},
+    Instruction{Opcode: 194, Name: "NOP", Mode: 5, Size: 2, Cycles: 2, PageCycles: 0},
+    Instruction{Opcode: 195, Name: "DCP", Mode: 7, Size: 2, Cycles: 8, PageCycles: 0},
+    Instruction{Opcode: 196, Name: "CPY", Mode: 11, Size: 2, Cycles: 3, PageCycles: 0},
+    Instruction{Opcode: 197, Name: "CMP", Mode: 11, Size: 2, Cycles: 3, PageCycles: 0},
+    Instruction{Opcode: 198, Name: "DEC", Mode: 11, Size: 2, Cycles: 5, PageCycles: 0},
+    Instruction{Opcode: 199, Name: "DCP", Mode: 11, Size: 2, Cycles: 5, PageCycles: 0},
+    Instruction{Opcode: 200, Name: "INY", Mode: 6, Size: 1, Cycles: 2, PageCycles: 0},
+    Instruction{Opcode: 201, Name: "CMP", Mode: 5, Size: 2, Cycles: 2, PageCycles: 0},
+    Instruction{Opcode: 202, Name: "DEX", Mode: 6, Size: 1, Cycles: 2, PageCycles: 0},
+    Instruction{Opcode: 203, Name: "AXS", Mode: 5, Size: 2, Cycles: 2, PageCycles: 0},
+    Instruction{Opcode: 204, Name: "CPY", Mode: 1, Size: 3, Cycles: 4, PageCycles: 0},
+    Instruction{Opcode: 205, Name: "CMP", Mode: 1, Size: 3, Cycles: 4, PageCycles: 0},
+    Instruction{Opcode: 206, Name: "DEC", Mode: 1, Size: 3, Cycles: 6, PageCycles: 0},
+    Instruction{Opcode: 207, Name: "DCP", Mode: 1, Size: 3, Cycles: 6, PageCycles: 0},
+    Instruction{Opcode: 208, Name: "BNE", Mode: 10, Size: 2, Cycles: 2, PageCycles: 1},
+    Instruction{Opcode: 209, Name: "CMP", Mode: 9, Size: 2, Cycles: 5, PageCycles: 1},
+    Instruction{Opcode: 210, Name: "KIL", Mode: 6, Size: 1, Cycles: 2, PageCycles: 0},
+    Instruction{Opcode: 211, Name: "DCP", Mode: 9, Size: 2, Cycles: 8, PageCycles: 0},
+    Instruction{Opcode: 212, Name: "NOP", Mode: 12, Size: 2, Cycles: 4, PageCycles: 0},
+    Instruction{Opcode: 213, Name: "CMP", Mode: 12, Size: 2, Cycles: 4, PageCycles: 0},
+    Instruction{Opcode: 214, Name: "DEC", Mode: 12, Size: 2, Cycles: 6, PageCycles: 0},
+    Instruction{Opcode: 215, Name: "DCP", Mode: 12, Size: 2, Cycles: 6, PageCycles: 0},
+    Instruction{Opcode: 216, Name: "CLD", Mode: 6, Size: 1, Cycles: 2, PageCycles: 0},
+    Instruction{Opcode: 217, Name: "CMP", Mode: 3, Size: 3, Cycles: 4, PageCycles: 1},
+    Instruction{Opcode: 218, Name: "NOP", Mode: 6, Size: 1, Cycles: 2, PageCycles: 0},
+    Instruction{Opcode: 219, Name: "DCP", Mode: 3, Size: 3, Cycles: 7, PageCycles: 0},
+    Instruction{Opcode: 220, Name: "NOP", Mode: 2, Size: 3, Cycles: 4, PageCycles: 1},
+    Instruction{Opcode: 221, Name: "CMP", Mode: 2, Size: 3, Cycles: 4, PageCycles: 1},
+    Instruction{Opcode: 222, Name: "DEC", Mode: 2, Size: 3, Cycles: 7, PageCycles: 0},
+    Instruction{Opcode: 223, Name: "DCP", Mode: 2, Size: 3, Cycles: 7, PageCycles: 0},
+    Instruction{Opcode: 224, Name: "CPX", Mode: 5, Size: 2, Cycles: 2, PageCycles: 0},
+    Instruction{Opcode: 225, Name: "SBC", Mode: 7, Size: 2, Cycles: 6, PageCycles: 0},
+    Instruction{Opcode: 226, Name: "NOP", Mode: 5, Size: 2, Cycles: 2, PageCycles: 0},
+    Instruction{Opcode: 227, Name: "ISC", Mode: 7, Size: 2, Cycles: 8, PageCycles: 0},
+    Instruction{Opcode: 228, Name: "CPX", Mode: 11, Size: 2, Cycles: 3, PageCycles: 0},
+    Instruction{Opcode: 229, Name: "SBC", Mode: 11, Size: 2, Cycles: 3, PageCycles: 0},
+    Instruction{Opcode: 230, Name: "INC", Mode: 11, Size: 2, Cycles: 5, PageCycles: 0},
+    Instruction{Opcode: 231, Name: "ISC", Mode: 11, Size: 2, Cycles: 5, PageCycles: 0},
+    Instruction{Opcode: 232, Name: "INX", Mode: 6, Size: 1, Cycles: 2, PageCycles: 0},
+    Instruction{Opcode: 233, Name: "SBC", Mode: 5, Size: 2, Cycles: 2, PageCycles: 0},
+    Instruction{Opcode: 234, Name: "NOP", Mode: 6, Size: 1, Cycles: 2, PageCycles: 0},
+    Instruction{Opcode: 235, Name: "SBC", Mode: 5, Size: 2, Cycles: 2, PageCycles: 0},
+    Instruction{Opcode: 236, Name: "CPX", Mode: 1, Size: 3, Cycles: 4, PageCycles: 0},
+    Instruction{Opcode: 237, Name: "SBC", Mode: 1, Size: 3, Cycles: 4, PageCycles: 0},
+    Instruction{Opcode: 238, Name: "INC", Mode: 1, Size: 3, Cycles: 6, PageCycles: 0},
+    Instruction{Opcode: 239, Name: "ISC", Mode: 1, Size: 3, Cycles: 6, PageCycles: 0},
+    Instruction{Opcode: 240, Name: "BEQ", Mode: 10, Size: 2, Cycles: 2, PageCycles: 1},
+    Instruction{Opcode: 241, Name: "SBC", Mode: 9, Size: 2, Cycles: 5, PageCycles: 1},
+    Instruction{Opcode: 242, Name: "KIL", Mode: 6, Size: 1, Cycles: 2, PageCycles: 0},
+    Instruction{Opcode: 243, Name: "ISC", Mode: 9, Size: 2, Cycles: 8, PageCycles: 0},
+    Instruction{Opcode: 244, Name: "NOP", Mode: 12, Size: 2, Cycles: 4, PageCycles: 0},
+    Instruction{Opcode: 245, Name: "SBC", Mode: 12, Size: 2, Cycles: 4, PageCycles: 0},
+    Instruction{Opcode: 246, Name: "INC", Mode: 12, Size: 2, Cycles: 6, PageCycles: 0},
+    Instruction{Opcode: 247, Name: "ISC", Mode: 12, Size: 2, Cycles: 6, PageCycles: 0},
+    Instruction{Opcode: 248, Name: "SED", Mode: 6, Size: 1, Cycles: 2, PageCycles: 0},
+    Instruction{Opcode: 249, Name: "SBC", Mode: 3, Size: 3, Cycles: 4, PageCycles: 1},
+    Instruction{Opcode: 250, Name: "NOP", Mode: 6, Size: 1, Cycles: 2, PageCycles: 0},
+    Instruction{Opcode: 251, Name: "ISC", Mode: 3, Size: 3, Cycles: 7, PageCycles: 0},
+    Instruction{Opcode: 252, Name: "NOP", Mode: 2, Size: 3, Cycles: 4, PageCycles: 1},
+    Instruction{Opcode: 253, Name: "SBC", Mode: 2, Size: 3, Cycles: 4, PageCycles: 1},
+    Instruction{Opcode: 254, Name: "INC", Mode: 2, Size: 3, Cycles: 7, PageCycles: 0},
+    Instruction{Opcode: 255, Name: "ISC", Mode: 2, Size: 3, Cycles: 7, PageCycles: 0},
+}
@@ -0,0 +1,214 @@
+package cpu6502
+
+// Conformance tests for the CPU on its own, against the per-opcode vectors
+// of https://github.com/SingleStepTests/ProcessorTests (the nes6502 set,
+// whose 6502 has no decimal mode). Each case gives the registers and RAM
+// before and after one instruction and every bus cycle in between. The
+// vectors aren't part of the repository: point CPU_TESTS at the directory
+// holding 00.json to ff.json (the default is testdata/nes6502/v1). Opcodes
+// whose file is missing are skipped.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type cpuState struct {
+	PC  uint16
+	S   byte
+	A   byte
+	X   byte
+	Y   byte
+	P   byte
+	RAM [][2]int // address, value
+}
+
+type cpuCase struct {
+	Name    string
+	Initial cpuState
+	Final   cpuState
+	Cycles  [][3]interface{} // address, value, "read" or "write"
+}
+
+// how many failing cases to describe for each opcode
+const cpuFailuresShown = 3
+
+// opcodes known not to match the vectors, and why; they're skipped, and
+// fail once they pass so they come off the list
+var cpuUnimplemented = map[string]string{
+	"KIL": "a jammed CPU only refetches its opcode here, its bus cycles aren't modelled",
+}
+
+func TestCPUVectors(t *testing.T) {
+	dir := os.Getenv("CPU_TESTS")
+	if dir == "" {
+		dir = filepath.Join("testdata", "nes6502", "v1")
+	}
+	found := false
+	for opcode := 0; opcode < 256; opcode++ {
+		data, err := ioutil.ReadFile(filepath.Join(dir, fmt.Sprintf("%02x.json", opcode)))
+		if os.IsNotExist(err) {
+			continue
+		}
+		found = true
+		t.Run(fmt.Sprintf("%02X_%s", opcode, Instructions[opcode].Name), func (t *testing.T) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			var cases []cpuCase
+			if err := json.Unmarshal(data, &cases); err != nil {
+				t.Fatal(err)
+			}
+			failures := 0
+			_, known := cpuUnimplemented[Instructions[opcode].Name]
+			for _, c := range cases {
+				if problems := runCPUCase(c); len(problems) > 0 {
+					failures++
+					if failures <= cpuFailuresShown && !known {
+						t.Errorf("%s: %s", c.Name, strings.Join(problems, "; "))
+					}
+				}
+			}
+			if reason, ok := cpuUnimplemented[Instructions[opcode].Name]; ok {
+				if failures == 0 {
+					t.Fatalf("all %d cases pass; take %s off cpuUnimplemented", len(cases), Instructions[opcode].Name)
+				}
+				t.Skipf("%d of %d cases fail: %s", failures, len(cases), reason)
+			}
+			if failures > cpuFailuresShown {
+				t.Errorf("%d of %d cases failed", failures, len(cases))
+			}
+		})
+	}
+	if !found {
+		t.Skipf("no CPU test vectors in %s (set CPU_TESTS)", dir)
+	}
+}
+
+// runCPUCase runs one instruction on a flat bus and describes how the
+// result differs from the expected one
+func runCPUCase(c cpuCase) []string {
+	bus := &FlatBus{Record: true}
+	cpu := &CPU{Bus: bus}
+	initial := c.Initial
+	cpu.PC, cpu.SP, cpu.A, cpu.X, cpu.Y = initial.PC, initial.S, initial.A, initial.X, initial.Y
+	SetFlags(cpu, initial.P)
+	for _, m := range initial.RAM {
+		bus.RAM[m[0]] = byte(m[1])
+	}
+	Step(cpu)
+
+	var problems []string
+	check := func (name string, got, want int) {
+		if got != want {
+			problems = append(problems, fmt.Sprintf("%s = $%02X, want $%02X", name, got, want))
+		}
+	}
+	final := c.Final
+	check("PC", int(cpu.PC), int(final.PC))
+	check("S", int(cpu.SP), int(final.S))
+	check("A", int(cpu.A), int(final.A))
+	check("X", int(cpu.X), int(final.X))
+	check("Y", int(cpu.Y), int(final.Y))
+	// B and U aren't flags the CPU keeps; they only exist on the stack
+	check("P", int(Flags(cpu) &^ 0x30), int(final.P &^ 0x30))
+	for _, m := range final.RAM {
+		check(fmt.Sprintf("[$%04X]", m[0]), int(bus.RAM[m[0]]), m[1])
+	}
+
+	describe := func (address uint16, value byte, write bool) string {
+		if write {
+			return fmt.Sprintf("write $%02X to $%04X", value, address)
+		}
+		return fmt.Sprintf("read $%02X from $%04X", value, address)
+	}
+	for i, want := range c.Cycles {
+		address := uint16(want[0].(float64))
+		value := byte(want[1].(float64))
+		write := want[2] == "write"
+		if i >= len(bus.Cycles) {
+			problems = append(problems, fmt.Sprintf("cycle %d: missing, want %s", i+1, describe(address, value, write)))
+			break
+		}
+		got := bus.Cycles[i]
+		if got.Address != address || got.Value != value || got.Write != write {
+			problems = append(problems, fmt.Sprintf("cycle %d: %s, want %s",
+				i+1, describe(got.Address, got.Value, got.Write), describe(address, value, write)))
+			break
+		}
+	}
+	if c.Cycles != nil && len(bus.Cycles) > len(c.Cycles) {
+		problems = append(problems, fmt.Sprintf("%d cycles, want %d", len(bus.Cycles), len(c.Cycles)))
+	}
+	return problems
+}
+
+// cases for the unofficial opcodes worked out by hand, so they're checked
+// without the vectors too; the code is at $0200 and only registers and
+// memory are compared
+var unofficialCases = []cpuCase{
+	{Name: "SLO $10",
+		Initial: cpuState{PC: 0x200, S: 0xFD, A: 0x02, P: 0x24, RAM: [][2]int{{0x200, 0x07}, {0x201, 0x10}, {0x10, 0x81}}},
+		Final:   cpuState{PC: 0x202, S: 0xFD, A: 0x02, P: 0x25, RAM: [][2]int{{0x10, 0x02}}}},
+	{Name: "RLA $10",
+		Initial: cpuState{PC: 0x200, S: 0xFD, A: 0x0F, P: 0x25, RAM: [][2]int{{0x200, 0x27}, {0x201, 0x10}, {0x10, 0x81}}},
+		Final:   cpuState{PC: 0x202, S: 0xFD, A: 0x03, P: 0x25, RAM: [][2]int{{0x10, 0x03}}}},
+	{Name: "SRE $10",
+		Initial: cpuState{PC: 0x200, S: 0xFD, A: 0xFF, P: 0x24, RAM: [][2]int{{0x200, 0x47}, {0x201, 0x10}, {0x10, 0x03}}},
+		Final:   cpuState{PC: 0x202, S: 0xFD, A: 0xFE, P: 0xA5, RAM: [][2]int{{0x10, 0x01}}}},
+	{Name: "RRA $10",
+		Initial: cpuState{PC: 0x200, S: 0xFD, A: 0x10, P: 0x25, RAM: [][2]int{{0x200, 0x67}, {0x201, 0x10}, {0x10, 0x02}}},
+		Final:   cpuState{PC: 0x202, S: 0xFD, A: 0x91, P: 0xA4, RAM: [][2]int{{0x10, 0x81}}}},
+	{Name: "DCP $10",
+		Initial: cpuState{PC: 0x200, S: 0xFD, A: 0x40, P: 0x24, RAM: [][2]int{{0x200, 0xC7}, {0x201, 0x10}, {0x10, 0x41}}},
+		Final:   cpuState{PC: 0x202, S: 0xFD, A: 0x40, P: 0x27, RAM: [][2]int{{0x10, 0x40}}}},
+	{Name: "ISC $10",
+		Initial: cpuState{PC: 0x200, S: 0xFD, A: 0x20, P: 0x25, RAM: [][2]int{{0x200, 0xE7}, {0x201, 0x10}, {0x10, 0x0F}}},
+		Final:   cpuState{PC: 0x202, S: 0xFD, A: 0x10, P: 0x25, RAM: [][2]int{{0x10, 0x10}}}},
+	{Name: "LAX $10",
+		Initial: cpuState{PC: 0x200, S: 0xFD, P: 0x24, RAM: [][2]int{{0x200, 0xA7}, {0x201, 0x10}, {0x10, 0x80}}},
+		Final:   cpuState{PC: 0x202, S: 0xFD, A: 0x80, X: 0x80, P: 0xA4}},
+	{Name: "LAX #$F3",
+		Initial: cpuState{PC: 0x200, S: 0xFD, A: 0x11, P: 0x24, RAM: [][2]int{{0x200, 0xAB}, {0x201, 0xF3}}},
+		Final:   cpuState{PC: 0x202, S: 0xFD, A: 0xF3, X: 0xF3, P: 0xA4}},
+	{Name: "ANC #$80",
+		Initial: cpuState{PC: 0x200, S: 0xFD, A: 0xF0, P: 0x24, RAM: [][2]int{{0x200, 0x0B}, {0x201, 0x80}}},
+		Final:   cpuState{PC: 0x202, S: 0xFD, A: 0x80, P: 0xA5}},
+	{Name: "ALR #$03",
+		Initial: cpuState{PC: 0x200, S: 0xFD, A: 0xFF, P: 0x24, RAM: [][2]int{{0x200, 0x4B}, {0x201, 0x03}}},
+		Final:   cpuState{PC: 0x202, S: 0xFD, A: 0x01, P: 0x25}},
+	{Name: "ARR #$80",
+		Initial: cpuState{PC: 0x200, S: 0xFD, A: 0xFF, P: 0x24, RAM: [][2]int{{0x200, 0x6B}, {0x201, 0x80}}},
+		Final:   cpuState{PC: 0x202, S: 0xFD, A: 0x40, P: 0x65}},
+	{Name: "AXS #$10",
+		Initial: cpuState{PC: 0x200, S: 0xFD, A: 0xF0, X: 0x3C, P: 0x24, RAM: [][2]int{{0x200, 0xCB}, {0x201, 0x10}}},
+		Final:   cpuState{PC: 0x202, S: 0xFD, A: 0xF0, X: 0x20, P: 0x25}},
+	{Name: "LAS $0300,Y",
+		Initial: cpuState{PC: 0x200, S: 0xF5, P: 0x24, RAM: [][2]int{{0x200, 0xBB}, {0x201, 0x00}, {0x202, 0x03}, {0x300, 0x5F}}},
+		Final:   cpuState{PC: 0x203, S: 0x55, A: 0x55, X: 0x55, P: 0x24}},
+	{Name: "XAA #$FF",
+		Initial: cpuState{PC: 0x200, S: 0xFD, X: 0x0F, P: 0x24, RAM: [][2]int{{0x200, 0x8B}, {0x201, 0xFF}}},
+		Final:   cpuState{PC: 0x202, S: 0xFD, A: 0x0E, X: 0x0F, P: 0x24}},
+	{Name: "SAX $10",
+		Initial: cpuState{PC: 0x200, S: 0xFD, A: 0xF0, X: 0x3C, P: 0x24, RAM: [][2]int{{0x200, 0x87}, {0x201, 0x10}}},
+		Final:   cpuState{PC: 0x202, S: 0xFD, A: 0xF0, X: 0x3C, P: 0x24, RAM: [][2]int{{0x10, 0x30}}}},
+	{Name: "SHX $0300,Y",
+		Initial: cpuState{PC: 0x200, S: 0xFD, X: 0xFF, Y: 0x01, P: 0x24, RAM: [][2]int{{0x200, 0x9E}, {0x201, 0x00}, {0x202, 0x03}}},
+		Final:   cpuState{PC: 0x203, S: 0xFD, X: 0xFF, Y: 0x01, P: 0x24, RAM: [][2]int{{0x301, 0x04}}}},
+	{Name: "SHX $02FF,Y, crossing a page",
+		Initial: cpuState{PC: 0x200, S: 0xFD, X: 0x05, Y: 0x01, P: 0x24, RAM: [][2]int{{0x200, 0x9E}, {0x201, 0xFF}, {0x202, 0x02}}},
+		Final:   cpuState{PC: 0x203, S: 0xFD, X: 0x05, Y: 0x01, P: 0x24, RAM: [][2]int{{0x100, 0x01}, {0x300, 0x00}}}},
+}
+
+func TestUnofficialOpcodes(t *testing.T) {
+	for _, c := range unofficialCases {
+		if problems := runCPUCase(c); len(problems) > 0 {
+			t.Errorf("%s: %s", c.Name, strings.Join(problems, "; "))
+		}
+	}
+}
@@ -0,0 +1,725 @@
+// Package dap serves the nes package's debugger over the Debug Adapter
+// Protocol (https://microsoft.github.io/debug-adapter-protocol/), so that
+// editors such as VS Code can debug a ROM by its source: set breakpoints on
+// lines of assembly, step a line at a time and look at the registers, RAM
+// and the PPU as variables. Anything to do with source needs debug info
+// next to the ROM (see nes.LoadSymbols).
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BrianWill/nes/cpu6502"
+	"github.com/BrianWill/nes/nes"
+)
+
+// a request from the client
+type request struct {
+	Seq       int             `json:"seq"`
+	Type      string          `json:"type"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+type response struct {
+	Seq        int         `json:"seq"`
+	Type       string      `json:"type"`
+	RequestSeq int         `json:"request_seq"`
+	Success    bool        `json:"success"`
+	Command    string      `json:"command"`
+	Message    string      `json:"message,omitempty"`
+	Body       interface{} `json:"body,omitempty"`
+}
+
+type event struct {
+	Seq   int         `json:"seq"`
+	Type  string      `json:"type"`
+	Event string      `json:"event"`
+	Body  interface{} `json:"body,omitempty"`
+}
+
+type source struct {
+	Name string `json:"name,omitempty"`
+	Path string `json:"path,omitempty"`
+}
+
+type variable struct {
+	Name               string `json:"name"`
+	Value              string `json:"value"`
+	VariablesReference int    `json:"variablesReference"`
+}
+
+// the state of one client's debugging session
+type session struct {
+	conn    io.Writer
+	seq     int // of the last message sent
+	program string
+	console *nes.Console
+	// where source paths in the debug info are relative to: the launch
+	// request's cwd, then the debug info's directory
+	sourceDirs  []string
+	lineBase    int              // 1 if the client counts lines from 1, else 0
+	breakpoints map[string][]int // IDs of the breakpoints set in each source file
+	// while a run has the console, the function it's running; its stop
+	// comes back on stops
+	running     func () nes.Stop
+	stops       chan nes.Stop
+	stopOnEntry bool
+}
+
+// the variables' references: scopes, then each page of RAM
+const (
+	registersReference = 1 + iota
+	ppuReference
+	ramReference
+	labelsReference
+	pageReference = 0x100 // + the page number
+)
+
+// the only thread
+const threadID = 1
+
+// Serve accepts debug adapter clients on l, each debugging its own
+// console, until l is closed
+func Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func () {
+			if err := ServeConn(conn); err != nil {
+				log.Printf("dap: %v", err)
+			}
+		}()
+	}
+}
+
+// ServeConn runs one debugging session with the client on conn, until the
+// client disconnects. It closes conn.
+func ServeConn(conn io.ReadWriteCloser) error {
+	defer conn.Close()
+	s := &session{
+		conn:        conn,
+		lineBase:    1,
+		breakpoints: map[string][]int{},
+		stops:       make(chan nes.Stop),
+	}
+
+	// requests are read on their own goroutine so that they can arrive
+	// while the console runs
+	requests := make(chan request)
+	errs := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+	go func () {
+		r := bufio.NewReader(conn)
+		for {
+			var req request
+			if err := readMessage(r, &req); err != nil {
+				errs <- err
+				return
+			}
+			select {
+			case requests <- req:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case req := <-requests:
+			if req.Type != "request" {
+				continue
+			}
+			quit, err := handle(s, req)
+			if err != nil {
+				return err
+			}
+			if quit {
+				return nil
+			}
+		case stop := <-s.stops:
+			s.running = nil
+			if err := stopped(s, stop); err != nil {
+				return err
+			}
+		case err := <-errs:
+			interrupt(s)
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// handle answers a request, reporting whether the session is over
+func handle(s *session, req request) (bool, error) {
+	// respond answers req; err makes it a failure
+	respond := func (body interface{}, err error) error {
+		r := response{Type: "response", RequestSeq: req.Seq, Success: err == nil, Command: req.Command, Body: body}
+		if err != nil {
+			r.Message = err.Error()
+		}
+		return send(s, &r)
+	}
+	arguments := func (v interface{}) error {
+		if len(req.Arguments) == 0 {
+			return nil
+		}
+		return json.Unmarshal(req.Arguments, v)
+	}
+	if s.console == nil && req.Command != "initialize" && req.Command != "launch" && req.Command != "disconnect" {
+		return false, respond(nil, errors.New("no program launched"))
+	}
+	if s.running != nil {
+		switch req.Command {
+		case "pause", "disconnect", "setBreakpoints", "threads":
+		default:
+			return false, respond(nil, errors.New("the program is running"))
+		}
+	}
+
+	switch req.Command {
+	case "initialize":
+		var args struct {
+			LinesStartAt1 *bool `json:"linesStartAt1"`
+		}
+		if err := arguments(&args); err != nil {
+			return false, respond(nil, err)
+		}
+		if args.LinesStartAt1 != nil && !*args.LinesStartAt1 {
+			s.lineBase = 0
+		}
+		err := respond(map[string]bool{
+			"supportsConfigurationDoneRequest": true,
+			"supportsConditionalBreakpoints":   true,
+			"supportsEvaluateForHovers":        true,
+			"supportsSteppingGranularity":      true,
+		}, nil)
+		if err != nil {
+			return false, err
+		}
+		return false, send(s, &event{Type: "event", Event: "initialized"})
+
+	case "launch":
+		var args struct {
+			Program     string `json:"program"`
+			StopOnEntry bool   `json:"stopOnEntry"`
+			Symbols     string `json:"symbols"` // the debug info, if not next to the ROM
+			Cwd         string `json:"cwd"`
+		}
+		if err := arguments(&args); err != nil {
+			return false, respond(nil, err)
+		}
+		if args.Program == "" {
+			return false, respond(nil, errors.New("launch needs a program, the ROM to run"))
+		}
+		console, err := nes.NewConsole(args.Program)
+		if err != nil {
+			return false, respond(nil, err)
+		}
+		if args.Symbols == "" {
+			args.Symbols = nes.FindSymbols(args.Program)
+		}
+		if args.Symbols != "" {
+			if err := nes.LoadSymbols(console, args.Symbols); err != nil {
+				return false, respond(nil, err)
+			}
+		}
+		nes.EnableDebugger(console)
+		s.console = console
+		s.program = args.Program
+		s.stopOnEntry = args.StopOnEntry
+		if args.Cwd != "" {
+			s.sourceDirs = append(s.sourceDirs, args.Cwd)
+		}
+		if err := respond(nil, nil); err != nil {
+			return false, err
+		}
+		if args.Symbols == "" {
+			return false, output(s, "no debug info found next to %s; breakpoints and stepping by line need it\n", args.Program)
+		}
+		s.sourceDirs = append(s.sourceDirs, filepath.Dir(args.Symbols))
+		if err := output(s, "loaded symbols from %s\n", args.Symbols); err != nil {
+			return false, err
+		}
+
+	case "setBreakpoints":
+		var args struct {
+			Source      source `json:"source"`
+			Breakpoints []struct {
+				Line      int    `json:"line"`
+				Condition string `json:"condition"`
+			} `json:"breakpoints"`
+		}
+		if err := arguments(&args); err != nil {
+			return false, respond(nil, err)
+		}
+		// the console can't be touched while it runs, so a run is paused
+		// and then carries on
+		run := s.running
+		stop, wasRunning := interrupt(s)
+		d := s.console.Debugger
+		for _, id := range s.breakpoints[args.Source.Path] {
+			nes.RemoveBreakpoint(d, id)
+		}
+		s.breakpoints[args.Source.Path] = nil
+		type breakpoint struct {
+			ID       int    `json:"id,omitempty"`
+			Verified bool   `json:"verified"`
+			Message  string `json:"message,omitempty"`
+			Line     int    `json:"line,omitempty"`
+		}
+		breakpoints := []breakpoint{}
+		for _, b := range args.Breakpoints {
+			line := b.Line + 1 - s.lineBase
+			id, found, err := addLineBreakpoint(s, args.Source.Path, line, b.Condition)
+			if err != nil {
+				breakpoints = append(breakpoints, breakpoint{Message: err.Error()})
+				continue
+			}
+			s.breakpoints[args.Source.Path] = append(s.breakpoints[args.Source.Path], id)
+			breakpoints = append(breakpoints, breakpoint{ID: id, Verified: true, Line: found.Line - 1 + s.lineBase})
+		}
+		if err := respond(map[string]interface{}{"breakpoints": breakpoints}, nil); err != nil {
+			return false, err
+		}
+		if wasRunning {
+			if stop.Reason != nes.StopPaused {
+				return false, stopped(s, stop)
+			}
+			start(s, run)
+		}
+
+	case "configurationDone":
+		if err := respond(nil, nil); err != nil {
+			return false, err
+		}
+		if s.stopOnEntry {
+			return false, send(s, &event{Type: "event", Event: "stopped", Body: map[string]interface{}{
+				"reason": "entry", "threadId": threadID, "allThreadsStopped": true,
+			}})
+		}
+		start(s, func () nes.Stop {
+			return nes.Continue(s.console, 0)
+		})
+
+	case "threads":
+		name := "NES"
+		if s.program != "" {
+			name = filepath.Base(s.program)
+		}
+		return false, respond(map[string]interface{}{
+			"threads": []map[string]interface{}{{"id": threadID, "name": name}},
+		}, nil)
+
+	case "stackTrace":
+		// the 6502 keeps no frames to unwind, so there's only where the PC
+		// is
+		pc := s.console.CPU.PC
+		frame := map[string]interface{}{
+			"id":                          1,
+			"name":                        fmt.Sprintf("$%04X", pc),
+			"line":                        0,
+			"column":                      0,
+			"instructionPointerReference": fmt.Sprintf("0x%04X", pc),
+		}
+		if label, ok := nes.SymbolAt(s.console, pc); ok {
+			frame["name"] = label
+		}
+		if line, ok := nes.SourceAt(s.console, pc); ok {
+			frame["source"] = source{Name: filepath.Base(line.File), Path: sourcePath(s, line.File)}
+			frame["line"] = line.Line - 1 + s.lineBase
+			frame["column"] = s.lineBase
+		}
+		return false, respond(map[string]interface{}{
+			"stackFrames": []interface{}{frame},
+			"totalFrames": 1,
+		}, nil)
+
+	case "scopes":
+		scopes := []map[string]interface{}{
+			{"name": "Registers", "variablesReference": registersReference, "expensive": false},
+			{"name": "PPU", "variablesReference": ppuReference, "expensive": false},
+			{"name": "RAM", "variablesReference": ramReference, "expensive": false},
+		}
+		if len(ramLabels(s.console)) > 0 {
+			scopes = append(scopes, map[string]interface{}{"name": "Labels", "variablesReference": labelsReference, "expensive": false})
+		}
+		return false, respond(map[string]interface{}{"scopes": scopes}, nil)
+
+	case "variables":
+		var args struct {
+			VariablesReference int `json:"variablesReference"`
+		}
+		if err := arguments(&args); err != nil {
+			return false, respond(nil, err)
+		}
+		return false, respond(map[string]interface{}{"variables": variables(s.console, args.VariablesReference)}, nil)
+
+	case "evaluate":
+		var args struct {
+			Expression string `json:"expression"`
+		}
+		if err := arguments(&args); err != nil {
+			return false, respond(nil, err)
+		}
+		result, err := evaluate(s.console, strings.TrimSpace(args.Expression))
+		if err != nil {
+			return false, respond(nil, err)
+		}
+		return false, respond(map[string]interface{}{"result": result, "variablesReference": 0}, nil)
+
+	case "next", "stepIn":
+		var args struct {
+			Granularity string `json:"granularity"`
+		}
+		if err := arguments(&args); err != nil {
+			return false, respond(nil, err)
+		}
+		if err := respond(nil, nil); err != nil {
+			return false, err
+		}
+		over := req.Command == "next"
+		start(s, func () nes.Stop {
+			if args.Granularity == "instruction" {
+				return stepInstruction(s.console, over)
+			}
+			return stepLine(s.console, over)
+		})
+
+	case "stepOut":
+		if err := respond(nil, nil); err != nil {
+			return false, err
+		}
+		start(s, func () nes.Stop {
+			return nes.StepOut(s.console)
+		})
+
+	case "continue":
+		if err := respond(map[string]bool{"allThreadsContinued": true}, nil); err != nil {
+			return false, err
+		}
+		start(s, func () nes.Stop {
+			return nes.Continue(s.console, 0)
+		})
+
+	case "pause":
+		if err := respond(nil, nil); err != nil {
+			return false, err
+		}
+		if stop, ok := interrupt(s); ok {
+			return false, stopped(s, stop)
+		}
+
+	case "disconnect":
+		interrupt(s)
+		return true, respond(nil, nil)
+
+	default:
+		return false, respond(nil, fmt.Errorf("%s isn't supported", req.Command))
+	}
+	return false, nil
+}
+
+// start hands the console to a run on another goroutine
+func start(s *session, run func () nes.Stop) {
+	s.running = run
+	go func () {
+		s.stops <- run()
+	}()
+}
+
+// interrupt pauses the run in progress, if there is one, and waits for it
+// to stop. Steps made of several runs (see stepLine) reset the pause
+// between runs, so it keeps pausing until one sticks.
+func interrupt(s *session) (nes.Stop, bool) {
+	if s.running == nil {
+		return nes.Stop{}, false
+	}
+	for {
+		nes.Pause(s.console.Debugger)
+		select {
+		case stop := <-s.stops:
+			s.running = nil
+			return stop, true
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+// stopped tells the client that a run stopped, and why
+func stopped(s *session, stop nes.Stop) error {
+	body := map[string]interface{}{"threadId": threadID, "allThreadsStopped": true}
+	switch stop.Reason {
+	case nes.StopBreakpoint:
+		body["reason"] = "breakpoint"
+		body["hitBreakpointIds"] = []int{stop.Breakpoint.ID}
+	case nes.StopPaused:
+		body["reason"] = "pause"
+	default:
+		body["reason"] = "step"
+	}
+	return send(s, &event{Type: "event", Event: "stopped", Body: body})
+}
+
+// output shows a message in the client's debug console
+func output(s *session, format string, a ...interface{}) error {
+	return send(s, &event{Type: "event", Event: "output", Body: map[string]string{
+		"category": "console",
+		"output":   fmt.Sprintf(format, a...),
+	}})
+}
+
+// addLineBreakpoint adds a breakpoint on the first line of code at or
+// after line in a source file. A line in banked PRG ROM only hits while
+// its own bank is at the PC, not another bank switched in at the same
+// address.
+func addLineBreakpoint(s *session, file string, line int, condition string) (int, nes.SourceLine, error) {
+	if s.console.Symbols == nil {
+		return 0, nes.SourceLine{}, errors.New("no debug info loaded")
+	}
+	found, ok := nes.FindLine(s.console.Symbols, file, line)
+	if !ok {
+		return 0, nes.SourceLine{}, fmt.Errorf("no code at or after line %d", line)
+	}
+	if found.Offset >= 0 {
+		bank := fmt.Sprintf("prg == %d", found.Offset)
+		if condition != "" {
+			condition = "(" + condition + ") && " + bank
+		} else {
+			condition = bank
+		}
+	}
+	id, err := nes.AddBreakpoint(s.console.Debugger, nes.Breakpoint{Kind: nes.BreakExecute, Start: found.Address, Condition: condition})
+	return id, found, err
+}
+
+// stepInstruction runs one instruction. Stepping over an interrupt runs
+// the whole handler, as stepping over a JSR runs the subroutine.
+func stepInstruction(console *nes.Console, over bool) nes.Stop {
+	if !over {
+		return nes.StepInto(console)
+	}
+	if !cpu6502.InterruptPending(&console.CPU.CPU) {
+		return nes.StepOver(console)
+	}
+	if stop := nes.StepInto(console); stop.Reason != nes.StopDone {
+		return stop
+	}
+	return nes.StepOut(console)
+}
+
+// stepLine runs instructions until the CPU reaches another source line,
+// or the start of the same one again. Code without source runs on through.
+// Without a source line to start from it steps one instruction.
+func stepLine(console *nes.Console, over bool) nes.Stop {
+	from, ok := nes.SourceAt(console, console.CPU.PC)
+	if !ok {
+		return stepInstruction(console, over)
+	}
+	for {
+		stop := stepInstruction(console, over)
+		if stop.Reason != nes.StopDone {
+			return stop
+		}
+		pc := console.CPU.PC
+		line, ok := nes.SourceAt(console, pc)
+		if !ok {
+			continue
+		}
+		if line.File != from.File || line.Line != from.Line {
+			return stop
+		}
+		if address, ok := nes.CurrentAddress(console, line.Address, line.Offset); ok && address == pc {
+			return stop
+		}
+	}
+}
+
+// sourcePath finds a source file named in the debug info
+func sourcePath(s *session, name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+	for _, dir := range s.sourceDirs {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	if len(s.sourceDirs) > 0 {
+		return filepath.Join(s.sourceDirs[len(s.sourceDirs)-1], name)
+	}
+	return name
+}
+
+// variables lists the children of a scope or a page of RAM
+func variables(console *nes.Console, reference int) []variable {
+	hex := func (name string, digits int, value int) variable {
+		return variable{Name: name, Value: fmt.Sprintf("$%0*X", digits, value)}
+	}
+	var result []variable
+	switch {
+	case reference == registersReference:
+		cpu := console.CPU
+		p := cpu6502.Flags(&cpu.CPU)
+		flags := []byte("nv-bdizc")
+		for i := range flags {
+			if p&(0x80>>uint(i)) != 0 && flags[i] != '-' {
+				flags[i] -= 'a' - 'A'
+			}
+		}
+		result = []variable{
+			hex("A", 2, int(cpu.A)),
+			hex("X", 2, int(cpu.X)),
+			hex("Y", 2, int(cpu.Y)),
+			{Name: "P", Value: fmt.Sprintf("$%02X %s", p, flags)},
+			hex("SP", 2, int(cpu.SP)),
+			hex("PC", 4, int(cpu.PC)),
+			{Name: "cycles", Value: strconv.FormatUint(cpu.Cycles, 10)},
+		}
+	case reference == ppuReference:
+		ppu := nes.InspectPPU(console)
+		result = []variable{
+			{Name: "scanline", Value: strconv.Itoa(ppu.ScanLine)},
+			{Name: "dot", Value: strconv.Itoa(ppu.Cycle)},
+			{Name: "frame", Value: strconv.FormatUint(ppu.Frame, 10)},
+			hex("PPUCTRL", 2, int(ppu.Control)),
+			hex("PPUMASK", 2, int(ppu.Mask)),
+			hex("PPUSTATUS", 2, int(ppu.Status)),
+			hex("OAMADDR", 2, int(ppu.OAMAddress)),
+			hex("v", 4, int(ppu.V)),
+			hex("t", 4, int(ppu.T)),
+			{Name: "x", Value: strconv.Itoa(int(ppu.X))},
+			{Name: "w", Value: strconv.Itoa(int(ppu.W))},
+		}
+	case reference == ramReference:
+		for page := 0; page < 8; page++ {
+			result = append(result, variable{
+				Name:               fmt.Sprintf("$%04X", page<<8),
+				Value:              fmt.Sprintf("$%04X-$%04X", page<<8, page<<8|0xFF),
+				VariablesReference: pageReference + page,
+			})
+		}
+	case reference == labelsReference:
+		for _, l := range ramLabels(console) {
+			result = append(result, hex(l.Name, 2, int(nes.Peek(console, l.Address))))
+		}
+	case reference >= pageReference && reference < pageReference+8:
+		// a row of 16 bytes a variable
+		base := uint16(reference-pageReference) << 8
+		for row := uint16(0); row < 0x100; row += 16 {
+			bytes := make([]string, 16)
+			for i := range bytes {
+				bytes[i] = fmt.Sprintf("%02X", nes.Peek(console, base+row+uint16(i)))
+			}
+			result = append(result, variable{Name: fmt.Sprintf("$%04X", base+row), Value: strings.Join(bytes, " ")})
+		}
+	}
+	if result == nil {
+		result = []variable{}
+	}
+	return result
+}
+
+// ramLabels are the labels in RAM and cartridge RAM, by address
+func ramLabels(console *nes.Console) []nes.Label {
+	if console.Symbols == nil {
+		return nil
+	}
+	var labels []nes.Label
+	seen := map[string]bool{}
+	for _, l := range console.Symbols.Labels {
+		if l.Offset >= 0 || l.Address >= 0x8000 || l.Address >= 0x2000 && l.Address < 0x6000 || seen[l.Name] {
+			continue
+		}
+		seen[l.Name] = true
+		labels = append(labels, l)
+	}
+	sort.SliceStable(labels, func (i, j int) bool {
+		return labels[i].Address < labels[j].Address
+	})
+	return labels
+}
+
+// evaluate shows the byte at a label, or the value of an expression in the
+// syntax of breakpoint conditions (see nes.Evaluate)
+func evaluate(console *nes.Console, expression string) (string, error) {
+	if console.Symbols != nil {
+		if address, err := nes.SymbolAddress(console, expression); err == nil {
+			return fmt.Sprintf("$%02X (at $%04X)", nes.Peek(console, address), address), nil
+		}
+	}
+	value, err := nes.Evaluate(console, expression)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d ($%X)", value, value), nil
+}
+
+// send numbers a response or event and writes it to the client
+func send(s *session, message interface{}) error {
+	s.seq++
+	switch m := message.(type) {
+	case *response:
+		m.Seq = s.seq
+	case *event:
+		m.Seq = s.seq
+	}
+	return writeMessage(s.conn, message)
+}
+
+// readMessage reads a message framed, as in HTTP, by a Content-Length
+// header and a blank line
+func readMessage(r *bufio.Reader, v interface{}) error {
+	length := -1
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if i := strings.IndexByte(line, ':'); i >= 0 && strings.EqualFold(line[:i], "Content-Length") {
+			if length, err = strconv.Atoi(strings.TrimSpace(line[i+1:])); err != nil {
+				return fmt.Errorf("bad header: %s", line)
+			}
+		}
+	}
+	if length < 0 {
+		return errors.New("message without a Content-Length")
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return err
+	}
+	return json.Unmarshal(body, v)
+}
+
+func writeMessage(w io.Writer, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	return err
+}
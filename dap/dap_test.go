@@ -0,0 +1,304 @@
+package dap
+
+// An end to end test: a scripted client debugs a small ROM, with ld65 debug
+// info, over loopback.
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// the test program, assembled at $8000 in a 16K NROM:
+//
+//	5   reset:  lda #0        A9 00
+//	6           sta counter   8D 00 03
+//	8   loop:   inc counter   EE 00 03
+//	9           jsr sub       20 10 80
+//	10          jmp loop      4C 05 80
+//	13  sub:    ldx counter   AE 00 03
+//	14          rts           60
+var program = map[uint16][]byte{
+	0x8000: {0xA9, 0x00, 0x8D, 0x00, 0x03},
+	0x8005: {0xEE, 0x00, 0x03, 0x20, 0x10, 0x80, 0x4C, 0x05, 0x80},
+	0x8010: {0xAE, 0x00, 0x03, 0x60},
+	0xBFFA: {0x00, 0x80, 0x00, 0x80, 0x00, 0x80},
+}
+
+const debugInfo = `version	major=2,minor=0
+file	id=0,name="src/main.s",size=200,mtime=0x00000000,mod=0
+seg	id=0,name="CODE",start=0x008000,size=0x0014,addrsize=absolute,type=ro,oname="game.nes",ooffs=16
+span	id=0,seg=0,start=0,size=2
+span	id=1,seg=0,start=2,size=3
+span	id=2,seg=0,start=5,size=3
+span	id=3,seg=0,start=8,size=3
+span	id=4,seg=0,start=11,size=3
+span	id=5,seg=0,start=16,size=3
+span	id=6,seg=0,start=19,size=1
+line	id=0,file=0,line=5,span=0
+line	id=1,file=0,line=6,span=1
+line	id=2,file=0,line=8,span=2
+line	id=3,file=0,line=9,span=3
+line	id=4,file=0,line=10,span=4
+line	id=5,file=0,line=13,span=5
+line	id=6,file=0,line=14,span=6
+sym	id=0,name="reset",addrsize=absolute,scope=0,def=0,val=0x8000,seg=0,type=lab
+sym	id=1,name="loop",addrsize=absolute,scope=0,def=2,val=0x8005,seg=0,type=lab
+sym	id=2,name="sub",addrsize=absolute,scope=0,def=5,val=0x8010,seg=0,type=lab
+sym	id=3,name="counter",addrsize=absolute,scope=0,def=9,val=0x0300,type=lab
+`
+
+// a message as the client sees it
+type message struct {
+	Seq        int             `json:"seq"`
+	Type       string          `json:"type"`
+	Command    string          `json:"command"`
+	Event      string          `json:"event"`
+	RequestSeq int             `json:"request_seq"`
+	Success    bool            `json:"success"`
+	Message    string          `json:"message"`
+	Body       json.RawMessage `json:"body"`
+}
+
+type client struct {
+	t      *testing.T
+	conn   net.Conn
+	r      *bufio.Reader
+	seq    int
+	events []message // received but not yet waited for
+}
+
+// call sends a request and returns the body of its response, failing the
+// test if it fails
+func call(c *client, command string, arguments interface{}, body interface{}) {
+	c.t.Helper()
+	c.seq++
+	request := map[string]interface{}{"seq": c.seq, "type": "request", "command": command}
+	if arguments != nil {
+		request["arguments"] = arguments
+	}
+	if err := writeMessage(c.conn, request); err != nil {
+		c.t.Fatal(err)
+	}
+	for {
+		m := receive(c)
+		if m.Type == "event" {
+			c.events = append(c.events, m)
+			continue
+		}
+		if m.RequestSeq != c.seq || m.Command != command {
+			c.t.Fatalf("%s: got a response to %s (%d)", command, m.Command, m.RequestSeq)
+		}
+		if !m.Success {
+			c.t.Fatalf("%s failed: %s", command, m.Message)
+		}
+		if body != nil {
+			if err := json.Unmarshal(m.Body, body); err != nil {
+				c.t.Fatalf("%s: %v", command, err)
+			}
+		}
+		return
+	}
+}
+
+// wait returns the body of the next event, which should be name
+func wait(c *client, name string, body interface{}) {
+	c.t.Helper()
+	var m message
+	if len(c.events) > 0 {
+		m, c.events = c.events[0], c.events[1:]
+	} else {
+		m = receive(c)
+	}
+	if m.Type != "event" || m.Event != name {
+		c.t.Fatalf("got %s %s%s, want event %s", m.Type, m.Command, m.Event, name)
+	}
+	if body != nil {
+		if err := json.Unmarshal(m.Body, body); err != nil {
+			c.t.Fatalf("%s: %v", name, err)
+		}
+	}
+}
+
+func receive(c *client) message {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	var m message
+	if err := readMessage(c.r, &m); err != nil {
+		c.t.Fatal(err)
+	}
+	return m
+}
+
+type stoppedBody struct {
+	Reason           string `json:"reason"`
+	HitBreakpointIDs []int  `json:"hitBreakpointIds"`
+}
+
+type breakpointsBody struct {
+	Breakpoints []struct {
+		ID       int  `json:"id"`
+		Verified bool `json:"verified"`
+		Line     int  `json:"line"`
+	} `json:"breakpoints"`
+}
+
+func TestSession(t *testing.T) {
+	dir := t.TempDir()
+	rom := make([]byte, 16+0x4000)
+	copy(rom, "NES\x1a\x01\x00")
+	for address, code := range program {
+		copy(rom[16+int(address-0x8000):], code)
+	}
+	romPath := filepath.Join(dir, "game.nes")
+	if err := ioutil.WriteFile(romPath, rom, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "game.dbg"), []byte(debugInfo), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sourcePath := filepath.Join(dir, "src", "main.s")
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go Serve(l)
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	c := &client{t: t, conn: conn, r: bufio.NewReader(conn)}
+
+	// where checks the stack trace shows the PC on a line
+	where := func (name string, line int) {
+		t.Helper()
+		var trace struct {
+			StackFrames []struct {
+				Name   string `json:"name"`
+				Line   int    `json:"line"`
+				Source source `json:"source"`
+			} `json:"stackFrames"`
+		}
+		call(c, "stackTrace", map[string]int{"threadId": threadID}, &trace)
+		if len(trace.StackFrames) != 1 {
+			t.Fatalf("%d stack frames, want 1", len(trace.StackFrames))
+		}
+		frame := trace.StackFrames[0]
+		if frame.Name != name || frame.Line != line || frame.Source.Path != sourcePath {
+			t.Fatalf("stopped in %s at %s:%d, want %s at %s:%d", frame.Name, frame.Source.Path, frame.Line, name, sourcePath, line)
+		}
+	}
+	// stopped waits for the run to stop, for the given reason
+	stopped := func (reason string) stoppedBody {
+		t.Helper()
+		var stop stoppedBody
+		wait(c, "stopped", &stop)
+		if stop.Reason != reason {
+			t.Fatalf("stopped for %s, want %s", stop.Reason, reason)
+		}
+		return stop
+	}
+	// value finds a variable in a scope
+	value := func (reference int, name string) string {
+		t.Helper()
+		var variables struct {
+			Variables []variable `json:"variables"`
+		}
+		call(c, "variables", map[string]int{"variablesReference": reference}, &variables)
+		for _, v := range variables.Variables {
+			if v.Name == name {
+				return v.Value
+			}
+		}
+		t.Fatalf("no variable %s", name)
+		return ""
+	}
+	setBreakpoints := func (lines ...int) breakpointsBody {
+		t.Helper()
+		var breakpoints []map[string]int
+		for _, line := range lines {
+			breakpoints = append(breakpoints, map[string]int{"line": line})
+		}
+		var body breakpointsBody
+		call(c, "setBreakpoints", map[string]interface{}{
+			"source":      map[string]string{"path": sourcePath},
+			"breakpoints": breakpoints,
+		}, &body)
+		return body
+	}
+
+	call(c, "initialize", map[string]interface{}{"adapterID": "nes", "linesStartAt1": true}, nil)
+	wait(c, "initialized", nil)
+	call(c, "launch", map[string]string{"program": romPath}, nil)
+	wait(c, "output", nil)
+
+	// line 7 is blank, so its breakpoint moves to line 8
+	breakpoints := setBreakpoints(7, 13)
+	if len(breakpoints.Breakpoints) != 2 {
+		t.Fatalf("%d breakpoints, want 2", len(breakpoints.Breakpoints))
+	}
+	for i, want := range []int{8, 13} {
+		if b := breakpoints.Breakpoints[i]; !b.Verified || b.Line != want {
+			t.Fatalf("breakpoint %d verified %v at line %d, want line %d", i, b.Verified, b.Line, want)
+		}
+	}
+	call(c, "configurationDone", nil, nil)
+	stop := stopped("breakpoint")
+	if len(stop.HitBreakpointIDs) != 1 || stop.HitBreakpointIDs[0] != breakpoints.Breakpoints[0].ID {
+		t.Fatalf("hit breakpoints %v, want %d", stop.HitBreakpointIDs, breakpoints.Breakpoints[0].ID)
+	}
+	where("loop", 8)
+	if pc := value(registersReference, "PC"); pc != "$8005" {
+		t.Fatalf("PC is %s, want $8005", pc)
+	}
+	if counter := value(labelsReference, "counter"); counter != "$00" {
+		t.Fatalf("counter is %s, want $00", counter)
+	}
+	if row := value(pageReference+3, "$0300"); !strings.HasPrefix(row, "00 00") {
+		t.Fatalf("$0300 row is %s", row)
+	}
+	value(ppuReference, "PPUCTRL")
+
+	// next runs line 8; the next next hits the breakpoint in sub
+	call(c, "next", map[string]int{"threadId": threadID}, nil)
+	stopped("step")
+	where("$8008", 9)
+	call(c, "next", map[string]int{"threadId": threadID}, nil)
+	stopped("breakpoint")
+	where("sub", 13)
+	call(c, "stepOut", map[string]int{"threadId": threadID}, nil)
+	stopped("step")
+	where("$800B", 10)
+	var result struct {
+		Result string `json:"result"`
+	}
+	call(c, "evaluate", map[string]string{"expression": "counter"}, &result)
+	if result.Result != "$01 (at $0300)" {
+		t.Fatalf("counter evaluates to %q", result.Result)
+	}
+	call(c, "evaluate", map[string]string{"expression": "x + 1"}, &result)
+	if result.Result != "2 ($2)" {
+		t.Fatalf("x + 1 evaluates to %q", result.Result)
+	}
+
+	// breakpoints set while the program runs take effect
+	setBreakpoints()
+	call(c, "continue", map[string]int{"threadId": threadID}, nil)
+	setBreakpoints(14)
+	stopped("breakpoint")
+	where("$8013", 14)
+
+	setBreakpoints()
+	call(c, "continue", map[string]int{"threadId": threadID}, nil)
+	call(c, "pause", map[string]int{"threadId": threadID}, nil)
+	stopped("pause")
+	call(c, "disconnect", nil, nil)
+}
@@ -0,0 +1,336 @@
+// Package debugger is a terminal front end for the nes package's debugger.
+package debugger
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+
+	"github.com/BrianWill/nes/cpu6502"
+	"github.com/BrianWill/nes/nes"
+)
+
+const help = `commands (addresses are hex, with or without $; counts are decimal):
+  break ADDR [if COND]                  stop before the instruction at ADDR
+  watch [ppu] r|w|rw ADDR[-END] [if COND]
+                                        stop after an instruction reads or writes
+                                        CPU (or PPU) memory in the range
+  delete ID, enable ID, disable ID      manage breakpoints
+  list                                  list breakpoints
+  step [N] (s)                          run N instructions (default 1)
+  next (n)                              run one instruction, all of a JSR's subroutine
+  finish                                run until the subroutine or interrupt returns
+  continue (c)                          run until a breakpoint; Ctrl-C pauses
+  scanline N                            run until scanline N (0-261) starts
+  regs (r)                              show the registers
+  disasm [ADDR [END]] (d)               disassemble CPU memory (default: from the PC)
+  mem ADDR [LEN] (x)                    dump CPU memory
+  ppu ADDR [LEN]                        dump PPU memory
+  reset, power                          press reset, or power off and on
+  quit (q)
+with debug info next to the ROM (rom.dbg from ld65, or NESASM's rom.fns), an
+ADDR can also be a label or FILE:LINE
+conditions are C-like expressions over a x y sp pc p, the flags c z i d v n,
+scanline dot frame cycles, address and value (of the access), prg (the byte
+of PRG ROM address maps to, or -1) and [ADDR] for a byte of memory, e.g.
+"a == $10 && [$0300] != 0"`
+
+// Run reads debugger commands from in until it ends or says quit, writing
+// the results to out. Ctrl-C pauses a running console instead of
+// quitting.
+func Run(console *nes.Console, in io.Reader, out io.Writer) {
+	d := nes.EnableDebugger(console)
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+	go func () {
+		for range interrupts {
+			nes.Pause(d)
+		}
+	}()
+
+	// parseAddress parses a hex address or, with symbols loaded, a label
+	// or FILE:LINE
+	parseAddress := func (s string) (uint16, error) {
+		if i := strings.LastIndex(s, ":"); i > 0 && console.Symbols != nil {
+			line, err := strconv.Atoi(s[i+1:])
+			if err != nil {
+				return 0, fmt.Errorf("bad line: %s", s)
+			}
+			source, ok := nes.FindLine(console.Symbols, s[:i], line)
+			if !ok {
+				return 0, fmt.Errorf("no code at or after %s", s)
+			}
+			address, ok := nes.CurrentAddress(console, source.Address, source.Offset)
+			if !ok {
+				return 0, fmt.Errorf("%s:%d is in a bank that isn't switched in", source.File, source.Line)
+			}
+			return address, nil
+		}
+		hex := strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(s), "$"), "0x")
+		var symbolErr error
+		if hex == strings.ToLower(s) && console.Symbols != nil {
+			address, err := nes.SymbolAddress(console, s)
+			if err == nil {
+				return address, nil
+			}
+			symbolErr = err
+		}
+		n, err := strconv.ParseUint(hex, 16, 16)
+		if err != nil {
+			if symbolErr != nil {
+				return 0, symbolErr
+			}
+			return 0, fmt.Errorf("bad address: %s", s)
+		}
+		return uint16(n), nil
+	}
+	// parseRange parses ADDR or ADDR-END
+	parseRange := func (s string) (uint16, uint16, error) {
+		parts := strings.SplitN(s, "-", 2)
+		start, err := parseAddress(parts[0])
+		if err != nil {
+			return 0, 0, err
+		}
+		end := start
+		if len(parts) == 2 {
+			if end, err = parseAddress(parts[1]); err != nil {
+				return 0, 0, err
+			}
+		}
+		return start, end, nil
+	}
+	// condition splits "... if COND" into its words and the condition
+	condition := func (line string) ([]string, string) {
+		if i := strings.Index(line, " if "); i >= 0 {
+			return strings.Fields(line[:i]), strings.TrimSpace(line[i+4:])
+		}
+		return strings.Fields(line), ""
+	}
+
+	registers := func () {
+		cpu := console.CPU
+		ppu := console.PPU
+		fmt.Fprintf(out, "A:%02X X:%02X Y:%02X P:%02X SP:%02X CYC:%d SL:%d DOT:%d\n",
+			cpu.A, cpu.X, cpu.Y, cpu6502.Flags(&cpu.CPU), cpu.SP, cpu.Cycles, ppu.ScanLine, ppu.Cycle)
+		fmt.Fprintln(out, nes.Disassemble(console, cpu.PC, cpu.PC, nil)[0])
+	}
+	report := func (stop nes.Stop) {
+		switch stop.Reason {
+		case nes.StopBreakpoint:
+			kind := map[byte]string{nes.BreakExecute: "execute", nes.BreakRead: "read", nes.BreakWrite: "write"}[stop.Kind]
+			fmt.Fprintf(out, "breakpoint %d: %s $%04X = $%02X\n", stop.Breakpoint.ID, kind, stop.Address, stop.Value)
+		case nes.StopPaused:
+			fmt.Fprintln(out, "paused")
+		}
+		registers()
+	}
+	dump := func (peek func (*nes.Console, uint16) byte, args []string) error {
+		if len(args) == 0 {
+			return fmt.Errorf("missing address")
+		}
+		address, err := parseAddress(args[0])
+		if err != nil {
+			return err
+		}
+		length := 64
+		if len(args) > 1 {
+			if length, err = strconv.Atoi(args[1]); err != nil {
+				return fmt.Errorf("bad length: %s", args[1])
+			}
+		}
+		for i := 0; i < length; i += 16 {
+			fmt.Fprintf(out, "%04X:", address+uint16(i))
+			for j := i; j < i+16 && j < length; j++ {
+				fmt.Fprintf(out, " %02X", peek(console, address+uint16(j)))
+			}
+			fmt.Fprintln(out)
+		}
+		return nil
+	}
+	breakpointByID := func (args []string) (*nes.Breakpoint, error) {
+		if len(args) == 0 {
+			return nil, fmt.Errorf("missing breakpoint id")
+		}
+		id, err := strconv.Atoi(args[0])
+		if err != nil {
+			return nil, fmt.Errorf("bad breakpoint id: %s", args[0])
+		}
+		for _, bp := range d.Breakpoints {
+			if bp.ID == id {
+				return bp, nil
+			}
+		}
+		return nil, fmt.Errorf("no breakpoint %d", id)
+	}
+
+	command := func (line string) (bool, error) {
+		words, cond := condition(line)
+		if len(words) == 0 {
+			return false, nil
+		}
+		args := words[1:]
+		switch words[0] {
+		case "break", "b":
+			if len(args) != 1 {
+				return false, fmt.Errorf("usage: break ADDR [if COND]")
+			}
+			address, err := parseAddress(args[0])
+			if err != nil {
+				return false, err
+			}
+			id, err := nes.AddBreakpoint(d, nes.Breakpoint{Kind: nes.BreakExecute, Start: address, Condition: cond})
+			if err != nil {
+				return false, err
+			}
+			fmt.Fprintf(out, "breakpoint %d at $%04X\n", id, address)
+		case "watch", "w":
+			bp := nes.Breakpoint{Condition: cond}
+			if len(args) > 0 && args[0] == "ppu" {
+				bp.PPU = true
+				args = args[1:]
+			}
+			if len(args) != 2 {
+				return false, fmt.Errorf("usage: watch [ppu] r|w|rw ADDR[-END] [if COND]")
+			}
+			for _, c := range args[0] {
+				switch c {
+				case 'r':
+					bp.Kind |= nes.BreakRead
+				case 'w':
+					bp.Kind |= nes.BreakWrite
+				default:
+					return false, fmt.Errorf("watch r, w or rw, not %s", args[0])
+				}
+			}
+			var err error
+			if bp.Start, bp.End, err = parseRange(args[1]); err != nil {
+				return false, err
+			}
+			id, err := nes.AddBreakpoint(d, bp)
+			if err != nil {
+				return false, err
+			}
+			fmt.Fprintf(out, "watchpoint %d\n", id)
+		case "delete":
+			bp, err := breakpointByID(args)
+			if err != nil {
+				return false, err
+			}
+			nes.RemoveBreakpoint(d, bp.ID)
+		case "enable", "disable":
+			bp, err := breakpointByID(args)
+			if err != nil {
+				return false, err
+			}
+			bp.Enabled = words[0] == "enable"
+		case "list":
+			for _, bp := range d.Breakpoints {
+				var kind string
+				for _, k := range []byte{nes.BreakExecute, nes.BreakRead, nes.BreakWrite} {
+					if bp.Kind&k != 0 {
+						kind += map[byte]string{nes.BreakExecute: "x", nes.BreakRead: "r", nes.BreakWrite: "w"}[k]
+					}
+				}
+				space := "cpu"
+				if bp.PPU {
+					space = "ppu"
+				}
+				fmt.Fprintf(out, "%d: %s %s $%04X-$%04X", bp.ID, space, kind, bp.Start, bp.End)
+				if bp.Condition != "" {
+					fmt.Fprintf(out, " if %s", bp.Condition)
+				}
+				if !bp.Enabled {
+					fmt.Fprint(out, " (disabled)")
+				}
+				fmt.Fprintf(out, ", %d hits\n", bp.Hits)
+			}
+		case "step", "s":
+			n := 1
+			if len(args) > 0 {
+				var err error
+				if n, err = strconv.Atoi(args[0]); err != nil {
+					return false, fmt.Errorf("bad count: %s", args[0])
+				}
+			}
+			var stop nes.Stop
+			for i := 0; i < n && stop.Reason == nes.StopDone; i++ {
+				stop = nes.StepInto(console)
+			}
+			report(stop)
+		case "next", "n":
+			report(nes.StepOver(console))
+		case "finish":
+			report(nes.StepOut(console))
+		case "continue", "c":
+			report(nes.Continue(console, 0))
+		case "scanline":
+			if len(args) != 1 {
+				return false, fmt.Errorf("usage: scanline N")
+			}
+			line, err := strconv.Atoi(args[0])
+			if err != nil || line < 0 || line > 261 {
+				return false, fmt.Errorf("bad scanline: %s", args[0])
+			}
+			report(nes.RunToScanline(console, line))
+		case "regs", "r":
+			registers()
+		case "disasm", "d":
+			start := console.CPU.PC
+			if len(args) > 0 {
+				var err error
+				if start, err = parseAddress(args[0]); err != nil {
+					return false, err
+				}
+			}
+			end := start + 0x1F
+			if len(args) > 1 {
+				var err error
+				if end, err = parseAddress(args[1]); err != nil {
+					return false, err
+				}
+			}
+			for _, line := range nes.Disassemble(console, start, end, nil) {
+				fmt.Fprintln(out, line)
+			}
+		case "mem", "x":
+			return false, dump(nes.Peek, args)
+		case "ppu":
+			return false, dump(nes.PeekPPU, args)
+		case "reset":
+			nes.Reset(console)
+			registers()
+		case "power":
+			nes.PowerCycle(console)
+			registers()
+		case "help", "h", "?":
+			fmt.Fprintln(out, help)
+		case "quit", "q":
+			return true, nil
+		default:
+			return false, fmt.Errorf("unknown command %s; try help", words[0])
+		}
+		return false, nil
+	}
+
+	registers()
+	scanner := bufio.NewScanner(in)
+	for {
+		fmt.Fprint(out, "> ")
+		if !scanner.Scan() {
+			fmt.Fprintln(out)
+			return
+		}
+		quit, err := command(scanner.Text())
+		if err != nil {
+			fmt.Fprintln(out, err)
+		}
+		if quit {
+			return
+		}
+	}
+}
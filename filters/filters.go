@@ -0,0 +1,187 @@
+// Package filters scales and post-processes emulator frames in software:
+// pixel-art scalers (Scale2x, Scale3x, hq2x, 2xBR) and CRT-style overlays
+// (scanlines, aperture grille).
+package filters
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+)
+
+// A Filter turns one image into another, usually larger, one.
+type Filter func(src *image.RGBA) *image.RGBA
+
+// Names lists the filters Lookup knows.
+var Names = []string{"2x", "3x", "scale2x", "scale3x", "hq2x", "2xbr", "scanlines", "aperture"}
+
+// Lookup returns the filter with the given name. Filters can be chained
+// with "+", e.g. "scale2x+scanlines"; they run left to right.
+func Lookup(name string) (Filter, error) {
+	var chain []Filter
+	for _, name := range strings.Split(name, "+") {
+		var f Filter
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "2x":
+			f = func (src *image.RGBA) *image.RGBA { return Nearest(src, 2) }
+		case "3x":
+			f = func (src *image.RGBA) *image.RGBA { return Nearest(src, 3) }
+		case "scale2x":
+			f = Scale2x
+		case "scale3x":
+			f = Scale3x
+		case "hq2x":
+			f = HQ2x
+		case "2xbr":
+			f = XBR2x
+		case "scanlines":
+			f = Scanlines
+		case "aperture":
+			f = ApertureGrille
+		default:
+			return nil, fmt.Errorf("unknown filter: %s (known filters: %s)", name, strings.Join(Names, ", "))
+		}
+		chain = append(chain, f)
+	}
+	if len(chain) == 1 {
+		return chain[0], nil
+	}
+	return func (src *image.RGBA) *image.RGBA {
+		for _, f := range chain {
+			src = f(src)
+		}
+		return src
+	}, nil
+}
+
+// Indexed converts an indexed frame (see nes.IndexedBuffer) to RGBA so
+// it can be filtered.
+func Indexed(src []uint16, palette [512]color.RGBA) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, 256, 240))
+	for i, c := range src {
+		dst.SetRGBA(i%256, i/256, palette[c&0x1FF])
+	}
+	return dst
+}
+
+// Nearest scales by an integer factor without smoothing.
+func Nearest(src *image.RGBA, scale int) *image.RGBA {
+	w, h := size(src)
+	dst := image.NewRGBA(image.Rect(0, 0, w*scale, h*scale))
+	for y := 0; y < h*scale; y++ {
+		for x := 0; x < w*scale; x++ {
+			dst.SetRGBA(x, y, at(src, x/scale, y/scale))
+		}
+	}
+	return dst
+}
+
+// Scanlines darkens every other row, the way the gaps between a CRT's
+// scanlines look. Use it after a 2x or 3x scaler.
+func Scanlines(src *image.RGBA) *image.RGBA {
+	w, h := size(src)
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := at(src, x, y)
+			if y%2 == 1 {
+				c = scale(c, 0.6)
+			}
+			dst.SetRGBA(x, y, c)
+		}
+	}
+	return dst
+}
+
+// ApertureGrille dims the other two channels of each column in a repeating
+// red, green, blue pattern, like the phosphor stripes of a Trinitron. Use
+// it after a 3x scaler.
+func ApertureGrille(src *image.RGBA) *image.RGBA {
+	const dim = 0.7
+	w, h := size(src)
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := at(src, x, y)
+			switch x % 3 {
+			case 0:
+				c.G = byte(float64(c.G) * dim)
+				c.B = byte(float64(c.B) * dim)
+			case 1:
+				c.R = byte(float64(c.R) * dim)
+				c.B = byte(float64(c.B) * dim)
+			case 2:
+				c.R = byte(float64(c.R) * dim)
+				c.G = byte(float64(c.G) * dim)
+			}
+			dst.SetRGBA(x, y, c)
+		}
+	}
+	return dst
+}
+
+// helper functions
+
+func size(im *image.RGBA) (int, int) {
+	s := im.Rect.Size()
+	return s.X, s.Y
+}
+
+// at returns the pixel at x, y, clamping coordinates to the image
+func at(im *image.RGBA, x, y int) color.RGBA {
+	w, h := size(im)
+	if x < 0 {
+		x = 0
+	} else if x >= w {
+		x = w - 1
+	}
+	if y < 0 {
+		y = 0
+	} else if y >= h {
+		y = h - 1
+	}
+	i := im.PixOffset(im.Rect.Min.X+x, im.Rect.Min.Y+y)
+	return color.RGBA{im.Pix[i], im.Pix[i+1], im.Pix[i+2], im.Pix[i+3]}
+}
+
+func scale(c color.RGBA, f float64) color.RGBA {
+	return color.RGBA{byte(float64(c.R) * f), byte(float64(c.G) * f), byte(float64(c.B) * f), c.A}
+}
+
+// mix blends colors with integer weights
+func mix(colors []color.RGBA, weights []int) color.RGBA {
+	var r, g, b, a, total int
+	for i, c := range colors {
+		w := weights[i]
+		r += int(c.R) * w
+		g += int(c.G) * w
+		b += int(c.B) * w
+		a += int(c.A) * w
+		total += w
+	}
+	return color.RGBA{byte(r / total), byte(g / total), byte(b / total), byte(a / total)}
+}
+
+// yuv converts a color the way hqNx and xBR compare colors
+func yuv(c color.RGBA) (int, int, int) {
+	r, g, b := int(c.R), int(c.G), int(c.B)
+	y := (299*r + 587*g + 114*b) / 1000
+	u := (-169*r - 331*g + 500*b) / 1000
+	v := (500*r - 419*g - 81*b) / 1000
+	return y, u, v
+}
+
+// distance returns how different two colors look, weighting luma over
+// chroma as xBR does
+func distance(a, b color.RGBA) int {
+	ya, ua, va := yuv(a)
+	yb, ub, vb := yuv(b)
+	abs := func (x int) int {
+		if x < 0 {
+			return -x
+		}
+		return x
+	}
+	return 48*abs(ya-yb) + 7*abs(ua-ub) + 6*abs(va-vb)
+}
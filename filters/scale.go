@@ -0,0 +1,184 @@
+package filters
+
+import (
+	"image"
+	"image/color"
+)
+
+// Scale2x doubles the size of an image, rounding off diagonal edges
+// without introducing new colors (AdvMAME2x).
+// http://www.scale2x.it/algorithm.html
+func Scale2x(src *image.RGBA) *image.RGBA {
+	w, h := size(src)
+	dst := image.NewRGBA(image.Rect(0, 0, w*2, h*2))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			b := at(src, x, y-1)
+			d := at(src, x-1, y)
+			e := at(src, x, y)
+			f := at(src, x+1, y)
+			h := at(src, x, y+1)
+			e0, e1, e2, e3 := e, e, e, e
+			if b != h && d != f {
+				if d == b {
+					e0 = d
+				}
+				if b == f {
+					e1 = f
+				}
+				if d == h {
+					e2 = d
+				}
+				if h == f {
+					e3 = f
+				}
+			}
+			dst.SetRGBA(x*2, y*2, e0)
+			dst.SetRGBA(x*2+1, y*2, e1)
+			dst.SetRGBA(x*2, y*2+1, e2)
+			dst.SetRGBA(x*2+1, y*2+1, e3)
+		}
+	}
+	return dst
+}
+
+// Scale3x triples the size of an image the same way (AdvMAME3x).
+func Scale3x(src *image.RGBA) *image.RGBA {
+	w, h := size(src)
+	dst := image.NewRGBA(image.Rect(0, 0, w*3, h*3))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			a := at(src, x-1, y-1)
+			b := at(src, x, y-1)
+			c := at(src, x+1, y-1)
+			d := at(src, x-1, y)
+			e := at(src, x, y)
+			f := at(src, x+1, y)
+			g := at(src, x-1, y+1)
+			h := at(src, x, y+1)
+			i := at(src, x+1, y+1)
+			e0, e1, e2, e3, e4, e5, e6, e7, e8 := e, e, e, e, e, e, e, e, e
+			if b != h && d != f {
+				if d == b {
+					e0 = d
+				}
+				if (d == b && e != c) || (b == f && e != a) {
+					e1 = b
+				}
+				if b == f {
+					e2 = f
+				}
+				if (d == b && e != g) || (d == h && e != a) {
+					e3 = d
+				}
+				if (b == f && e != i) || (h == f && e != c) {
+					e5 = f
+				}
+				if d == h {
+					e6 = d
+				}
+				if (d == h && e != i) || (h == f && e != g) {
+					e7 = h
+				}
+				if h == f {
+					e8 = f
+				}
+			}
+			dst.SetRGBA(x*3, y*3, e0)
+			dst.SetRGBA(x*3+1, y*3, e1)
+			dst.SetRGBA(x*3+2, y*3, e2)
+			dst.SetRGBA(x*3, y*3+1, e3)
+			dst.SetRGBA(x*3+1, y*3+1, e4)
+			dst.SetRGBA(x*3+2, y*3+1, e5)
+			dst.SetRGBA(x*3, y*3+2, e6)
+			dst.SetRGBA(x*3+1, y*3+2, e7)
+			dst.SetRGBA(x*3+2, y*3+2, e8)
+		}
+	}
+	return dst
+}
+
+// HQ2x doubles the size of an image in the style of Maxim Stepin's hq2x:
+// pixels are compared in YUV space, and corners where an edge passes are
+// blended with the neighbors on the other side of it.
+func HQ2x(src *image.RGBA) *image.RGBA {
+	w, h := size(src)
+	dst := image.NewRGBA(image.Rect(0, 0, w*2, h*2))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			e := at(src, x, y)
+			for _, s := range corners {
+				a := at(src, x+s.X, y)     // horizontal neighbor
+				b := at(src, x, y+s.Y)     // vertical neighbor
+				c := at(src, x+s.X, y+s.Y) // diagonal neighbor
+				p := e
+				switch {
+				case similar(a, b) && !similar(e, a):
+					// an edge passes between e and the corner
+					if similar(a, c) {
+						p = mix([]color.RGBA{e, a, b}, []int{2, 1, 1})
+					} else {
+						p = mix([]color.RGBA{e, a, b}, []int{6, 1, 1})
+					}
+				case !similar(e, a) && !similar(e, b):
+					// e sticks out on its own
+					p = mix([]color.RGBA{e, a, b}, []int{6, 1, 1})
+				}
+				dst.SetRGBA(x*2+(s.X+1)/2, y*2+(s.Y+1)/2, p)
+			}
+		}
+	}
+	return dst
+}
+
+// XBR2x doubles the size of an image with Hyllian's 2xBR (level 1) rules,
+// which find the dominant edge direction at each corner from a 4x4 area.
+func XBR2x(src *image.RGBA) *image.RGBA {
+	w, h := size(src)
+	dst := image.NewRGBA(image.Rect(0, 0, w*2, h*2))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			e := at(src, x, y)
+			for _, s := range corners {
+				// neighbors named as if this is the bottom right corner
+				p := func (dx, dy int) color.RGBA {
+					return at(src, x+s.X*dx, y+s.Y*dy)
+				}
+				b, c, d := p(0, -1), p(1, -1), p(-1, 0)
+				f, g, hh, i := p(1, 0), p(-1, 1), p(0, 1), p(1, 1)
+				f4, i4, h5, i5 := p(2, 0), p(2, 1), p(0, 2), p(1, 2)
+
+				out := e
+				if e != f && e != hh {
+					across := distance(e, c) + distance(e, g) + distance(i, f4) + distance(i, h5) + 4*distance(hh, f)
+					along := distance(hh, d) + distance(hh, i5) + distance(f, i4) + distance(f, b) + 4*distance(e, i)
+					if across < along {
+						n := hh
+						if distance(e, f) <= distance(e, hh) {
+							n = f
+						}
+						out = mix([]color.RGBA{e, n}, []int{1, 1})
+					}
+				}
+				dst.SetRGBA(x*2+(s.X+1)/2, y*2+(s.Y+1)/2, out)
+			}
+		}
+	}
+	return dst
+}
+
+// directions of the four corners of a pixel
+var corners = []image.Point{{-1, -1}, {1, -1}, {-1, 1}, {1, 1}}
+
+// similar reports whether two colors are within hqNx's YUV thresholds
+func similar(a, b color.RGBA) bool {
+	ya, ua, va := yuv(a)
+	yb, ub, vb := yuv(b)
+	abs := func (x int) int {
+		if x < 0 {
+			return -x
+		}
+		return x
+	}
+	return abs(ya-yb) <= 48 && abs(ua-ub) <= 7 && abs(va-vb) <= 6
+}
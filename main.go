@@ -1,19 +1,80 @@
 package main
 
 import (
+	"bufio"
+	"flag"
+	"io"
 	"io/ioutil"
 	"log"
+	"net"
 	"os"
 	"path"
+	"strconv"
 	"strings"
 
+	"github.com/BrianWill/nes/dap"
+	"github.com/BrianWill/nes/debugger"
+	"github.com/BrianWill/nes/nes"
+	"github.com/BrianWill/nes/tracediff"
 	"github.com/BrianWill/nes/ui"
 )
 
 func main() {
+	log.SetFlags(0)
+
+	// nes debug rom.nes: debug in the terminal, without a window
+	if len(os.Args) > 1 && os.Args[1] == "debug" {
+		if len(os.Args) != 3 {
+			log.Fatalln("usage: nes debug rom.nes")
+		}
+		console, err := nes.NewConsole(os.Args[2])
+		if err != nil {
+			log.Fatalln(err)
+		}
+		loadSymbols(console, os.Args[2])
+		debugger.Run(console, os.Stdin, os.Stdout)
+		return
+	}
+
+	// nes disasm rom.nes: disassemble the PRG ROM to standard output
+	if len(os.Args) > 1 && os.Args[1] == "disasm" {
+		if len(os.Args) != 3 {
+			log.Fatalln("usage: nes disasm rom.nes")
+		}
+		console, err := nes.NewConsole(os.Args[2])
+		if err != nil {
+			log.Fatalln(err)
+		}
+		loadSymbols(console, os.Args[2])
+		out := bufio.NewWriter(os.Stdout)
+		nes.DisassembleROM(console, out, nil)
+		out.Flush()
+		return
+	}
+
+	// nes trace [flags] rom.nes: run without a window, logging each instruction
+	if len(os.Args) > 1 && os.Args[1] == "trace" {
+		trace(os.Args[2:])
+		return
+	}
+
+	// nes tracediff [flags] rom.nes reference.log: compare a trace with
+	// another emulator's
+	if len(os.Args) > 1 && os.Args[1] == "tracediff" {
+		traceDiff(os.Args[2:])
+		return
+	}
+
+	// nes dap [flags]: serve the debugger to editors over the Debug Adapter
+	// Protocol
+	if len(os.Args) > 1 && os.Args[1] == "dap" {
+		serveDAP(os.Args[2:])
+		return
+	}
+
 	getPaths := func () []string {
 		var arg string
-		args := os.Args[1:]
+		args := flag.Args()
 		if len(args) == 1 {
 			arg = args[0]
 		} else {
@@ -42,11 +103,209 @@ func main() {
 		}
 	}
 
-	log.SetFlags(0)
+	config, err := ui.LoadConfig()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	flag.StringVar(&config.Palette, "palette", config.Palette, `"ntsc" to generate a palette, or a .pal file (default: built-in palette)`)
+	flag.Float64Var(&config.PaletteParams.Hue, "hue", config.PaletteParams.Hue, "ntsc palette hue, in degrees")
+	flag.Float64Var(&config.PaletteParams.Saturation, "saturation", config.PaletteParams.Saturation, "ntsc palette saturation")
+	flag.Float64Var(&config.PaletteParams.Contrast, "contrast", config.PaletteParams.Contrast, "ntsc palette contrast")
+	flag.Float64Var(&config.PaletteParams.Brightness, "brightness", config.PaletteParams.Brightness, "ntsc palette brightness")
+	flag.Float64Var(&config.PaletteParams.Gamma, "gamma", config.PaletteParams.Gamma, "ntsc palette display gamma")
+	flag.StringVar(&config.NTSC, "ntsc", config.NTSC, `NTSC video filter: "composite", "svideo" or "rgb" (default: off)`)
+	flag.StringVar(&config.Filter, "filter", config.Filter, `video filter, e.g. "scale2x", "hq2x", "2xbr" or "scale2x+scanlines" (default: none)`)
+	flag.BoolVar(&config.FilterCaptures, "filter-captures", config.FilterCaptures, "apply -filter to screenshots and recordings")
+	flag.StringVar(&config.PixelAspect, "aspect", config.PixelAspect, `pixel aspect ratio, e.g. "8:7" (default: square pixels)`)
+	flag.BoolVar(&config.IntegerScale, "integer-scale", config.IntegerScale, "only scale the picture by whole multiples")
+	overscanV := flag.Int("overscan-v", config.Overscan.Top, "lines to crop from the top and bottom, e.g. 8")
+	overscanH := flag.Int("overscan-h", config.Overscan.Left, "columns to crop from the left and right, e.g. 8")
+	flag.BoolVar(&config.UnlimitedSprites, "unlimited-sprites", config.UnlimitedSprites, "draw every sprite on a scanline instead of the first 8")
+	flag.BoolVar(&config.FrameBlend, "blend", config.FrameBlend, "blend each frame with the previous one to smooth flicker")
+	flag.StringVar(&config.InitialRAM, "ram", config.InitialRAM, `RAM contents at power on: "zero", "ff", "pattern" or "random" (default: zero)`)
+	flag.Parse()
+	flag.Visit(func (f *flag.Flag) {
+		switch f.Name {
+		case "overscan-v":
+			config.Overscan.Top = *overscanV
+			config.Overscan.Bottom = *overscanV
+		case "overscan-h":
+			config.Overscan.Left = *overscanH
+			config.Overscan.Right = *overscanH
+		}
+	})
+
 	paths := getPaths()
 	if len(paths) == 0 {
 		log.Fatalln("no rom files specified or found")
 	}
-	ui.Run(paths)
+	ui.Run(paths, config)
+}
+
+
+// trace runs a rom for a range of frames without a window or sound, logging
+// instructions in nestest.log's format
+func trace(args []string) {
+	flags := flag.NewFlagSet("trace", flag.ExitOnError)
+	flags.Usage = func () {
+		log.Println("usage: nes trace [flags] rom.nes")
+		flags.PrintDefaults()
+	}
+	output := flags.String("o", "trace.log", "file to write the log to")
+	start := flags.String("start", "", "start logging when the PC reaches this address (hex)")
+	stop := flags.String("stop", "", "stop after logging the instruction at this address (hex)")
+	frames := flags.String("frames", "0-59", "log during these frames, then stop")
+	pcs := flags.String("pc", "0000-ffff", "while logging, leave out instructions outside this range of addresses (hex)")
+	entry := flags.String("entry", "", `start at this address (hex) instead of the reset vector, e.g. "c000" for nestest`)
+	symbols := flags.Bool("symbols", false, "show labels from debug info next to the rom (the log is then no longer in nestest.log's format)")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	// parseRange parses FIRST-LAST in the given base
+	parseRange := func (s string, base, bits int) (uint64, uint64) {
+		parts := strings.SplitN(s, "-", 2)
+		first, err1 := strconv.ParseUint(parts[0], base, bits)
+		last, err2 := first, error(nil)
+		if len(parts) == 2 {
+			last, err2 = strconv.ParseUint(parts[1], base, bits)
+		}
+		if err1 != nil || err2 != nil || last < first {
+			log.Fatalf("bad range: %s", s)
+		}
+		return first, last
+	}
+	pcStart, pcEnd := parseRange(*pcs, 16, 16)
+	frameStart, frameEnd := parseRange(*frames, 10, 64)
+
+	console, err := nes.NewConsole(flags.Arg(0))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if *symbols {
+		loadSymbols(console, flags.Arg(0))
+	}
+	setEntry(console, *entry)
+	tracer, err := nes.StartTrace(console, *output)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	tracer.PCStart, tracer.PCEnd = uint16(pcStart), uint16(pcEnd)
+	tracer.FrameStart, tracer.FrameEnd = frameStart, frameEnd
+	tracer.StartPC, tracer.StopPC = parsePC(*start), parsePC(*stop)
+	tracer.Symbols = *symbols
+	for console.PPU.Frame <= frameEnd && !nes.TraceDone(tracer) {
+		nes.StepSeconds(console, 1.0/60)
+	}
+	if err := nes.StopTrace(console); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// traceDiff runs a rom without a window or sound, comparing its trace with
+// a reference trace from another emulator until they diverge
+func traceDiff(args []string) {
+	flags := flag.NewFlagSet("tracediff", flag.ExitOnError)
+	flags.Usage = func () {
+		log.Println("usage: nes tracediff [flags] rom.nes reference.log")
+		flags.PrintDefaults()
+	}
+	entry := flags.String("entry", "", `start at this address (hex) instead of the reset vector, e.g. "c000" for nestest`)
+	frames := flags.Uint64("frames", 3600, "stop after this many frames")
+	context := flags.Int("context", 10, "lines of the reference to show before and after a divergence")
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	reference, err := os.Open(flags.Arg(1))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer reference.Close()
+	console, err := nes.NewConsole(flags.Arg(0))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	setEntry(console, *entry)
+
+	// the console runs alongside the comparison, blocked on the pipe
+	// whenever it gets ahead
+	r, w := io.Pipe()
+	nes.TraceTo(console, w)
+	go func () {
+		for console.PPU.Frame < *frames {
+			nes.StepSeconds(console, 1.0/60)
+		}
+		w.CloseWithError(nes.StopTrace(console))
+	}()
+	divergence, err := tracediff.Diff(r, reference, *context)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if divergence == nil {
+		log.Println("the traces agree")
+		return
+	}
+	tracediff.Print(os.Stdout, divergence)
+	os.Exit(1)
 }
 
+// serveDAP listens for debug adapter clients on a local port; each
+// launches its own rom
+func serveDAP(args []string) {
+	flags := flag.NewFlagSet("dap", flag.ExitOnError)
+	flags.Usage = func () {
+		log.Println("usage: nes dap [flags]")
+		flags.PrintDefaults()
+	}
+	port := flags.Int("port", 4711, "TCP port to listen on, on 127.0.0.1")
+	flags.Parse(args)
+	if flags.NArg() != 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:"+strconv.Itoa(*port))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	log.Printf("listening for debug adapter clients on %s", l.Addr())
+	log.Fatalln(dap.Serve(l))
+}
+
+// loadSymbols loads the debug info next to a rom, if there is any
+func loadSymbols(console *nes.Console, romPath string) {
+	path := nes.FindSymbols(romPath)
+	if path == "" {
+		return
+	}
+	if err := nes.LoadSymbols(console, path); err != nil {
+		log.Fatalln(err)
+	}
+	log.Printf("loaded symbols from %s", path)
+}
+
+// parsePC parses a hex address, or "" for none (-1)
+func parsePC(s string) int {
+	if s == "" {
+		return -1
+	}
+	pc, err := strconv.ParseUint(s, 16, 16)
+	if err != nil {
+		log.Fatalf("bad address: %s", s)
+	}
+	return int(pc)
+}
+
+// setEntry points the CPU at entry, a hex address, unless it's ""
+func setEntry(console *nes.Console, entry string) {
+	if entry == "" {
+		return
+	}
+	pc, err := strconv.ParseUint(entry, 16, 16)
+	if err != nil {
+		log.Fatalf("bad address: %s", entry)
+	}
+	console.CPU.PC = uint16(pc)
+}
@@ -0,0 +1,144 @@
+package nes
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/BrianWill/nes/cpu6502"
+)
+
+// StartCDL begins logging how the program uses each byte of ROM: what
+// runs as code, what's read as data, what the DMC plays and what the PPU
+// draws (see CDLCode and CDLRendered). Accesses are traced back to ROM
+// through the mapper's banks as they are at the time, so every bank is
+// covered as it gets switched in.
+func StartCDL(console *Console) *CDLogger {
+	l := newCDLogger(console.Cartridge)
+	console.CDL = l
+	return l
+}
+
+// LoadCDL begins logging from the flags in the .cdl file at path, so that
+// what earlier sessions found is kept
+func LoadCDL(console *Console, path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	l := newCDLogger(console.Cartridge)
+	if len(data) != len(l.PRG)+len(l.CHR) {
+		return fmt.Errorf("%s is %d bytes; this ROM's .cdl is %d", path, len(data), len(l.PRG)+len(l.CHR))
+	}
+	copy(l.PRG, data)
+	copy(l.CHR, data[len(l.PRG):])
+	console.CDL = l
+	return nil
+}
+
+// SaveCDL writes the log to path as an FCEUX .cdl file: the PRG ROM flags
+// followed by the CHR ROM flags, a byte each. Logging carries on.
+func SaveCDL(console *Console, path string) error {
+	l := console.CDL
+	if l == nil {
+		return nil
+	}
+	return ioutil.WriteFile(path, append(append([]byte{}, l.PRG...), l.CHR...), 0644)
+}
+
+func newCDLogger(cartridge *Cartridge) *CDLogger {
+	l := &CDLogger{PRG: make([]byte, len(cartridge.PRG))}
+	if !cartridge.CHRRAM {
+		l.CHR = make([]byte, len(cartridge.CHR))
+	}
+	return l
+}
+
+// StopCDL ends logging
+func StopCDL(console *Console) {
+	console.CDL = nil
+}
+
+// logInstructionCDL marks the instruction at the PC as code, and the
+// operand it reads as data, before it runs; or the vector a pending
+// interrupt jumps through. Called from stepCPU.
+func logInstructionCDL(console *Console) {
+	cpu := console.CPU
+	if cpu6502.InterruptPending(&cpu.CPU) {
+		vector := cpu6502.InterruptVector(&cpu.CPU)
+		logPRG(console, vector, CDLData)
+		logPRG(console, vector + 1, CDLData)
+		return
+	}
+	read := func (address uint16) byte {
+		return Peek(console, address)
+	}
+	// read16 reads a pointer without carrying into the high byte's page,
+	// as the CPU does for zero page and JMP ($xxFF)
+	read16 := func (address uint16) uint16 {
+		high := address&0xFF00 | uint16(byte(address)+1)
+		return uint16(read(address)) | uint16(read(high))<<8
+	}
+	instruction := cpu6502.Instructions[read(cpu.PC)]
+	for i := uint16(0); i < uint16(instruction.Size); i++ {
+		logPRG(console, cpu.PC + i, CDLCode)
+	}
+	operand := uint16(read(cpu.PC + 1))
+	if instruction.Size == 3 {
+		operand |= uint16(read(cpu.PC + 2)) << 8
+	}
+
+	switch instruction.Name {
+	case "JMP":
+		if instruction.Mode == cpu6502.ModeIndirect {
+			logPRG(console, operand, CDLData)
+			logPRG(console, operand&0xFF00 | uint16(byte(operand)+1), CDLData)
+			logPRG(console, read16(operand), CDLIndirectCode)
+		}
+		return
+	case "BRK":
+		logPRG(console, 0xFFFE, CDLData)
+		logPRG(console, 0xFFFF, CDLData)
+		return
+	case "JSR", "STA", "STX", "STY", "SAX", "AHX", "SHX", "SHY", "TAS":
+		// they don't read the memory they address
+		return
+	}
+	switch instruction.Mode {
+	case cpu6502.ModeAbsolute:
+		logPRG(console, operand, CDLData)
+	case cpu6502.ModeAbsoluteX:
+		logPRG(console, operand + uint16(cpu.X), CDLData)
+	case cpu6502.ModeAbsoluteY:
+		logPRG(console, operand + uint16(cpu.Y), CDLData)
+	case cpu6502.ModeIndexedIndirect:
+		pointer := byte(operand) + cpu.X
+		logPRG(console, read16(uint16(pointer)), CDLData|CDLIndirectData)
+	case cpu6502.ModeIndirectIndexed:
+		logPRG(console, read16(operand) + uint16(cpu.Y), CDLData|CDLIndirectData)
+	}
+}
+
+// logPRG sets flags for the byte of PRG ROM at a CPU address, if there is
+// one, recording which 8K of the address space it was accessed at
+func logPRG(console *Console, address uint16, flags byte) {
+	l := console.CDL
+	if address < 0x8000 {
+		return
+	}
+	if i := mapperOffset(console.Mapper, address); i >= 0 && i < len(l.PRG) {
+		l.PRG[i] = l.PRG[i]&^CDLBank | byte(address>>13&3)<<2 | flags
+	}
+}
+
+// logCHR sets flags for the byte of CHR ROM at a PPU address, if there is
+// one
+func logCHR(console *Console, address uint16, flags byte) {
+	l := console.CDL
+	address %= 0x4000
+	if address >= 0x2000 {
+		return
+	}
+	if i := mapperOffset(console.Mapper, address); i >= 0 && i < len(l.CHR) {
+		l.CHR[i] |= flags
+	}
+}
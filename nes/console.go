@@ -3,10 +3,13 @@ package nes
 import (
 	"image"
 	"fmt"
+	"math/rand"
 	"encoding/binary"
 	"errors"
 	"io"
 	"os"
+
+	"github.com/BrianWill/nes/cpu6502"
 )
 
 func NewConsole(path string) (*Console, error) {
@@ -71,720 +74,907 @@ func NewConsole(path string) (*Console, error) {
 		}
 
 		// success
-		return &Cartridge{prg, chr, make([]byte, 0x2000), mapper, mirror, battery}, nil
+		return &Cartridge{prg, chr, make([]byte, 0x2000), mapper, mirror, battery, header.NumCHR == 0}, nil
 	})()
 	if err != nil {
 		return nil, err
 	}
 
+	mapper, err := newMapper(cartridge)
+	if err != nil {
+		return nil, err
+	}
 	ram := make([]byte, 2048)
 	controller1 := &Controller{}
 	controller2 := &Controller{}
-	console := Console{nil, nil, nil, cartridge, controller1, controller2, nil, ram}
+	console := Console{nil, nil, nil, cartridge, controller1, controller2, mapper, ram, nil, nil, nil, nil, nil, RAMZero}
+	console.CPU = &CPU{}
+	console.APU = &APU{}
+	console.PPU = &PPU{
+		front: image.NewRGBA(image.Rect(0, 0, 256, 240)), 
+		back: image.NewRGBA(image.Rect(0, 0, 256, 240)),
+		palette: emphasisPalette,
+		frontIndex: make([]uint16, 256*240),
+		backIndex: make([]uint16, 256*240),
+	}
+	PowerCycle(&console)
 
+	return &console, nil
+}
+
+func newMapper(cartridge *Cartridge) (Mapper, error) {
 	// btw: why does the console need a cartridge if the mapper also has the same cartridge?
 	switch cartridge.Mapper {
 	case 0:
 		prgBanks := len(cartridge.PRG) / 0x4000
-		console.Mapper = &Mapper2{prgBanks, 0, prgBanks - 1}
+		return &Mapper2{prgBanks, 0, prgBanks - 1}, nil
 	case 1:
 		m := Mapper1{shiftRegister: 0x10}
 		m.prgOffsets[1] = prgBankOffset1(cartridge, -1)
-		console.Mapper = &m
+		return &m, nil
 	case 2:
 		prgBanks := len(cartridge.PRG) / 0x4000
-		console.Mapper = &Mapper2{prgBanks, 0, prgBanks - 1}
+		return &Mapper2{prgBanks, 0, prgBanks - 1}, nil
 	case 3:
 		prgBanks := len(cartridge.PRG) / 0x4000
-		console.Mapper = &Mapper3{0, 0, prgBanks - 1}
+		return &Mapper3{0, 0, prgBanks - 1}, nil
 	case 4:
 		m := Mapper4{}
 		m.prgOffsets[0] = prgBankOffset4(cartridge, 0)
 		m.prgOffsets[1] = prgBankOffset4(cartridge, 1)
 		m.prgOffsets[2] = prgBankOffset4(cartridge, -2)
 		m.prgOffsets[3] = prgBankOffset4(cartridge, -1)
-		console.Mapper = &m
+		return &m, nil
 	case 7:
-		console.Mapper = &Mapper7{0}
+		return &Mapper7{0}, nil
 	default:
 		return nil, fmt.Errorf("unsupported mapper: %d", cartridge.Mapper)
 	}
+}
 
-	cpu := CPU{}
-	console.CPU = &cpu
-	Reset(&console)
-	
-	apu := APU{}
+// PowerCycle turns the console off and on again. Everything starts over
+// except the cartridge's memory, which keeps its battery-backed RAM (and,
+// as on hardware, its CHR RAM). Display and audio settings are kept.
+func PowerCycle(console *Console) {
+	console.Mapper, _ = newMapper(console.Cartridge)
+	fillRAM(console.RAM, console.initialRAM)
+	*console.Controller1 = Controller{buttons: console.Controller1.buttons}
+	*console.Controller2 = Controller{buttons: console.Controller2.buttons}
+
+	// the cycle and frame counters keep counting, as VGM logging and
+	// the open bus decay measure time with them
+	cpu := console.CPU
+	*cpu = CPU{CPU: cpu6502.CPU{Bus: console, Cycles: cpu.Cycles}}
+	cpu6502.SetFlags(&cpu.CPU, 0x24)
+
+	apu := console.APU
+	*apu = APU{channel: apu.channel, cycle: apu.cycle}
 	apu.noise.shiftRegister = 1
 	apu.pulse1.channel = 1
 	apu.pulse2.channel = 2
-	console.APU = &apu
 
-	ppu := PPU{
-		front: image.NewRGBA(image.Rect(0, 0, 256, 240)), 
-		back: image.NewRGBA(image.Rect(0, 0, 256, 240)),
-		Cycle: 340,
-		ScanLine: 250,
-		Frame: 0,
+	ppu := console.PPU
+	*ppu = PPU{
+		front: ppu.front,
+		back: ppu.back,
+		palette: ppu.palette,
+		indexed: ppu.indexed,
+		frontIndex: ppu.frontIndex,
+		backIndex: ppu.backIndex,
+		frontStale: ppu.frontStale,
+		unlimitedSprites: ppu.unlimitedSprites,
+		Frame: ppu.Frame,
 	}
-	writeControlPPU(&ppu, 0)
-	writeMaskPPU(&ppu, 0)
-	ppu.oamAddress = 0
-	console.PPU = &ppu
+	// the PPU powers up at dot 0 of scanline 0, where Nintendulator starts
+	// it too, so that after the 7 cycles of the reset sequence a trace
+	// begins at "PPU:  0, 21 CYC:7" like nestest.log
 
-	return &console, nil
+	Reset(console)
 }
 
+// Reset presses the reset button. The CPU runs its reset sequence (the
+// registers keep their values, but the stack pointer drops by 3), the APU
+// falls silent and the PPU clears its registers and ignores writes to
+// most of them until the next frame. Memory is left alone.
+func Reset(console *Console) {
+	apu := console.APU
+	apu.pulse1.enabled, apu.pulse1.lengthValue = false, 0
+	apu.pulse2.enabled, apu.pulse2.lengthValue = false, 0
+	apu.triangle.enabled, apu.triangle.lengthValue = false, 0
+	apu.noise.enabled, apu.noise.lengthValue = false, 0
+	apu.dmc.enabled, apu.dmc.currentLength = false, 0
+	apu.dmc.value &= 1
+	apu.registers[0x15] = 0
+	apu.frameValue = 0
 
-func StepSeconds(console *Console, seconds float64) {
-	// causes an IRQ interrupt to occur on the next cycle
-	triggerIRQ := func (cpu *CPU) {
-		if cpu.I == 0 {
-			cpu.interrupt = interruptIRQ
+	ppu := console.PPU
+	writeControlPPU(ppu, 0)
+	writeMaskPPU(ppu, 0)
+	ppu.t = 0
+	ppu.x = 0
+	ppu.w = 0
+	ppu.f = 0
+	ppu.bufferedData = 0
+	ppu.warmingUp = true
+
+	// the cartridge doesn't see the reset button, so mappers keep their
+	// banks; MMC1 drops a partial write, as it sees the CPU stop
+	// reading from it
+	if m, ok := console.Mapper.(*Mapper1); ok {
+		m.shiftRegister = 0x10
+	}
+
+	console.CPU.oamDMA = false
+	console.CPU.dmcDMA = false
+	cpu6502.Reset(&console.CPU.CPU)
+}
+
+// fillRAM sets RAM to its power on contents
+func fillRAM(ram []byte, initial int) {
+	for i := range ram {
+		switch initial {
+		case RAMZero:
+			ram[i] = 0
+		case RAMFill:
+			ram[i] = 0xFF
+		case RAMPattern:
+			if i&4 == 0 {
+				ram[i] = 0
+			} else {
+				ram[i] = 0xFF
+			}
+		case RAMRandom:
+			ram[i] = byte(rand.Intn(256))
 		}
 	}
+}
 
-	// executes a single PPU cycle	
-	stepPPU := func (ppu *PPU) {
-		// update Cycle, ScanLine and Frame counters
-		if ppu.nmiDelay > 0 {
-			ppu.nmiDelay--
-			if ppu.nmiDelay == 0 && ppu.nmiOutput && ppu.nmiOccurred {
-				console.CPU.interrupt = interruptNMI  // non-maskable interrupt on next cycle
+
+func StepSeconds(console *Console, seconds float64) {
+	cycles := int(CPUFrequency * seconds)
+	for cycles > 0 {
+		cycles -= stepCPU(console)
+	}
+}
+
+// stepCPU runs the CPU for one instruction (or interrupt) and returns the
+// number of cycles it took, including any DMA that halted it. The rest of
+// the console runs along with it, a cycle at a time (see Console.Read).
+func stepCPU(console *Console) int {
+	cpu := &console.CPU.CPU
+	if console.Tracer != nil && !cpu6502.InterruptPending(cpu) {
+		traceInstruction(console)
+	}
+	if console.CDL != nil {
+		logInstructionCDL(console)
+	}
+	return cpu6502.Step(cpu)
+}
+
+// tick runs everything but the CPU for one CPU cycle. Console.Read and
+// Console.Write call it after each bus access, so the PPU and APU are
+// always caught up to the access in progress and register writes take
+// effect on the right dot.
+func tick(console *Console) {
+	for i := 0; i < 3; i++ {
+		stepPPU(console)
+
+		switch m := console.Mapper.(type) {
+		case *Mapper1, *Mapper2, *Mapper3, *Mapper7:
+			// do nothing
+		case *Mapper4:
+			ppu := console.PPU
+			if ppu.Cycle == 280 &&
+					(ppu.ScanLine <= 239 || ppu.ScanLine >= 261) && 
+					(ppu.flagShowBackground != 0 || ppu.flagShowSprites != 0) {
+				if m.counter == 0 {
+					m.counter = m.reload
+				} else {
+					m.counter--
+					if m.counter == 0 && m.irqEnable {
+						m.irqPending = true
+					}
+				}
 			}
 		}
-		if (ppu.flagShowBackground != 0 || ppu.flagShowSprites != 0) &&
-				ppu.f == 1 && ppu.ScanLine == 261 && ppu.Cycle == 339 {
+	}
+	stepAPU(console)
+}
+
+// stepPPU executes a single PPU cycle
+func stepPPU(console *Console) {
+	ppu := console.PPU
+	// update Cycle, ScanLine and Frame counters
+	if ppu.nmiEdge {
+		// the CPU sees the NMI line go low right away, and polls it
+		// before the last cycle of each instruction
+		ppu.nmiEdge = false
+		ppu.nmiLine = true
+	}
+	if (ppu.flagShowBackground != 0 || ppu.flagShowSprites != 0) &&
+			ppu.f == 1 && ppu.ScanLine == 261 && ppu.Cycle == 339 {
+		// odd frames skip the last dot of the pre-render line
+		ppu.Cycle = 0
+		ppu.ScanLine = 0
+		ppu.Frame++
+		ppu.f ^= 1
+	} else {
+		ppu.Cycle++
+		if ppu.Cycle > 340 {
 			ppu.Cycle = 0
-			ppu.ScanLine = 0
-			ppu.Frame++
-			ppu.f ^= 1
-		} else {
-			ppu.Cycle++
-			if ppu.Cycle > 340 {
-				ppu.Cycle = 0
-				ppu.ScanLine++
-				if ppu.ScanLine > 261 {
-					ppu.ScanLine = 0
-					ppu.Frame++
-					ppu.f ^= 1
-				}
+			ppu.ScanLine++
+			if ppu.ScanLine > 261 {
+				ppu.ScanLine = 0
+				ppu.Frame++
+				ppu.f ^= 1
 			}
 		}
+	}
 
-		renderingEnabled := ppu.flagShowBackground != 0 || ppu.flagShowSprites != 0
-		preLine := ppu.ScanLine == 261
-		visibleLine := ppu.ScanLine < 240
-		renderLine := preLine || visibleLine
-		preFetchCycle := ppu.Cycle >= 321 && ppu.Cycle <= 336
-		visibleCycle := ppu.Cycle >= 1 && ppu.Cycle <= 256
-		fetchCycle := preFetchCycle || visibleCycle
-
-		// background logic
-		if renderingEnabled {
-			if visibleLine && visibleCycle {
-				// render pixel
-				x := ppu.Cycle - 1
-				y := ppu.ScanLine
-
-				// background pixel
-				background := byte(0)
-				if ppu.flagShowBackground != 0 {
-					data := uint32(ppu.tileData >> 32) >> ((7 - ppu.x) * 4)
-					background = byte(data & 0x0F)	
-				}
+	renderingEnabled := ppu.flagShowBackground != 0 || ppu.flagShowSprites != 0
+	preLine := ppu.ScanLine == 261
+	visibleLine := ppu.ScanLine < 240
+	renderLine := preLine || visibleLine
+	preFetchCycle := ppu.Cycle >= 321 && ppu.Cycle <= 336
+	visibleCycle := ppu.Cycle >= 1 && ppu.Cycle <= 256
+	fetchCycle := preFetchCycle || visibleCycle
+
+	// background logic
+	if renderingEnabled {
+		if visibleLine && visibleCycle {
+			// render pixel
+			x := ppu.Cycle - 1
+			y := ppu.ScanLine
 
-				spritePixel := func () (byte, byte) {
-					if ppu.flagShowSprites == 0 {
-						return 0, 0
+			// background pixel
+			background := byte(0)
+			if ppu.flagShowBackground != 0 {
+				data := uint32(ppu.tileData >> 32) >> ((7 - ppu.x) * 4)
+				background = byte(data & 0x0F)	
+			}
+
+			spritePixel := func () (byte, byte) {
+				if ppu.flagShowSprites == 0 {
+					return 0, 0
+				}
+				for i := 0; i < ppu.spriteCount; i++ {
+					offset := (ppu.Cycle - 1) - int(ppu.spritePositions[i])
+					if offset < 0 || offset > 7 {
+						continue
 					}
-					for i := 0; i < ppu.spriteCount; i++ {
-						offset := (ppu.Cycle - 1) - int(ppu.spritePositions[i])
-						if offset < 0 || offset > 7 {
-							continue
-						}
-						offset = 7 - offset
-						color := byte((ppu.spritePatterns[i] >> byte(offset*4)) & 0x0F)
-						if color%4 == 0 {
-							continue
-						}
-						return byte(i), color
+					offset = 7 - offset
+					color := byte((ppu.spritePatterns[i] >> byte(offset*4)) & 0x0F)
+					if color%4 == 0 {
+						continue
 					}
-					return 0, 0
+					return byte(i), color
 				}
-				i, sprite := spritePixel()
+				return 0, 0
+			}
+			i, sprite := spritePixel()
 
-				if x < 8 && ppu.flagShowLeftBackground == 0 {
-					background = 0
-				}
-				if x < 8 && ppu.flagShowLeftSprites == 0 {
-					sprite = 0
+			if x < 8 && ppu.flagShowLeftBackground == 0 {
+				background = 0
+			}
+			if x < 8 && ppu.flagShowLeftSprites == 0 {
+				sprite = 0
+			}
+			b := background%4 != 0
+			s := sprite%4 != 0
+			var color byte
+			if !b && !s {
+				color = 0
+			} else if !b && s {
+				color = sprite | 0x10
+			} else if b && !s {
+				color = background
+			} else {
+				if i == 0 && ppu.spriteZero && x < 255 {
+					ppu.flagSpriteZeroHit = 1
 				}
-				b := background%4 != 0
-				s := sprite%4 != 0
-				var color byte
-				if !b && !s {
-					color = 0
-				} else if !b && s {
+				if ppu.spritePriorities[i] == 0 {
 					color = sprite | 0x10
-				} else if b && !s {
-					color = background
 				} else {
-					if ppu.spriteIndexes[i] == 0 && x < 255 {
-						ppu.flagSpriteZeroHit = 1
-					}
-					if ppu.spritePriorities[i] == 0 {
-						color = sprite | 0x10
-					} else {
-						color = background
-					}
+					color = background
 				}
-				c := Palette[readPalette(ppu, uint16(color))%64]
-				ppu.back.SetRGBA(x, y, c)
 			}
-			if renderLine && fetchCycle {
-				ppu.tileData <<= 4
-				switch ppu.Cycle % 8 {
-				case 1:
-					// fetch name table byte
-					v := ppu.v
-					address := 0x2000 | (v & 0x0FFF)
-					ppu.nameTableByte = readPPU(console, address)
-				case 3:
-					// fetch attribute table byte
-					v := ppu.v
-					address := 0x23C0 | (v & 0x0C00) | ((v >> 4) & 0x38) | ((v >> 2) & 0x07)
-					shift := ((v >> 4) & 4) | (v & 2)
-					ppu.attributeTableByte = ((readPPU(console, address) >> shift) & 3) << 2
-				case 5:
-					// fetch low tile byte
-					fineY := (ppu.v >> 12) & 7
-					table := ppu.flagBackgroundTable
-					tile := ppu.nameTableByte
-					address := 0x1000*uint16(table) + uint16(tile)*16 + fineY
-					ppu.lowTileByte = readPPU(console, address)
-				case 7:
-					// fetch high tile byte
-					fineY := (ppu.v >> 12) & 7
-					table := ppu.flagBackgroundTable
-					tile := ppu.nameTableByte
-					address := 0x1000*uint16(table) + uint16(tile)*16 + fineY
-					ppu.highTileByte = readPPU(console, address + 8)
-				case 0:
-					// store tile data
-					var data uint32
-					for i := 0; i < 8; i++ {
-						a := ppu.attributeTableByte
-						p1 := (ppu.lowTileByte & 0x80) >> 7
-						p2 := (ppu.highTileByte & 0x80) >> 6
-						ppu.lowTileByte <<= 1
-						ppu.highTileByte <<= 1
-						data <<= 4
-						data |= uint32(a | p1 | p2)
-					}
-					ppu.tileData |= uint64(data)
+			index := uint16(readPalette(ppu, uint16(color)) % 64)
+			if ppu.flagGrayscale != 0 {
+				index &= 0x30
+			}
+			emphasis := uint16(ppu.flagRedTint | ppu.flagGreenTint<<1 | ppu.flagBlueTint<<2)
+			if ppu.indexed {
+				ppu.backIndex[y*256+x] = emphasis<<6 | index
+			} else {
+				ppu.back.SetRGBA(x, y, ppu.palette[emphasis<<6|index])
+			}
+		}
+		if renderLine && fetchCycle {
+			ppu.tileData <<= 4
+			switch ppu.Cycle % 8 {
+			case 1:
+				// fetch name table byte
+				v := ppu.v
+				address := 0x2000 | (v & 0x0FFF)
+				ppu.nameTableByte = readPPU(console, address)
+			case 3:
+				// fetch attribute table byte
+				v := ppu.v
+				address := 0x23C0 | (v & 0x0C00) | ((v >> 4) & 0x38) | ((v >> 2) & 0x07)
+				shift := ((v >> 4) & 4) | (v & 2)
+				ppu.attributeTableByte = ((readPPU(console, address) >> shift) & 3) << 2
+			case 5:
+				// fetch low tile byte
+				fineY := (ppu.v >> 12) & 7
+				table := ppu.flagBackgroundTable
+				tile := ppu.nameTableByte
+				address := 0x1000*uint16(table) + uint16(tile)*16 + fineY
+				ppu.lowTileByte = fetchPattern(console, address)
+			case 7:
+				// fetch high tile byte
+				fineY := (ppu.v >> 12) & 7
+				table := ppu.flagBackgroundTable
+				tile := ppu.nameTableByte
+				address := 0x1000*uint16(table) + uint16(tile)*16 + fineY
+				ppu.highTileByte = fetchPattern(console, address + 8)
+			case 0:
+				// store tile data
+				var data uint32
+				for i := 0; i < 8; i++ {
+					a := ppu.attributeTableByte
+					p1 := (ppu.lowTileByte & 0x80) >> 7
+					p2 := (ppu.highTileByte & 0x80) >> 6
+					ppu.lowTileByte <<= 1
+					ppu.highTileByte <<= 1
+					data <<= 4
+					data |= uint32(a | p1 | p2)
 				}
+				ppu.tileData |= uint64(data)
 			}
-			if preLine && ppu.Cycle >= 280 && ppu.Cycle <= 304 {
-				// copy Y
-				// vert(v) = vert(t)
-				// v: .IHGF.ED CBA..... = t: .IHGF.ED CBA.....
-				ppu.v = (ppu.v & 0x841F) | (ppu.t & 0x7BE0)
+		}
+		if preLine && ppu.Cycle >= 280 && ppu.Cycle <= 304 {
+			// copy Y
+			// vert(v) = vert(t)
+			// v: .IHGF.ED CBA..... = t: .IHGF.ED CBA.....
+			ppu.v = (ppu.v & 0x841F) | (ppu.t & 0x7BE0)
+		}
+		if renderLine {
+			if fetchCycle && ppu.Cycle%8 == 0 {
+				// increment X
+				// increment hori(v)
+				// if coarse X == 31
+				if ppu.v&0x001F == 31 {
+					// coarse X = 0
+					ppu.v &= 0xFFE0
+					// switch horizontal nametable
+					ppu.v ^= 0x0400
+				} else {
+					// increment coarse X
+					ppu.v++
+				}
 			}
-			if renderLine {
-				if fetchCycle && ppu.Cycle%8 == 0 {
-					// increment X
-					// increment hori(v)
-					// if coarse X == 31
-					if ppu.v&0x001F == 31 {
-						// coarse X = 0
-						ppu.v &= 0xFFE0
-						// switch horizontal nametable
-						ppu.v ^= 0x0400
+			if ppu.Cycle == 256 {
+				// increment Y
+				// increment vert(v)
+				// if fine Y < 7
+				if ppu.v&0x7000 != 0x7000 {
+					// increment fine Y
+					ppu.v += 0x1000
+				} else {
+					// fine Y = 0
+					ppu.v &= 0x8FFF
+					// let y = coarse Y
+					y := (ppu.v & 0x03E0) >> 5
+					if y == 29 {
+						// coarse Y = 0
+						y = 0
+						// switch vertical nametable
+						ppu.v ^= 0x0800
+					} else if y == 31 {
+						// coarse Y = 0, nametable not switched
+						y = 0
 					} else {
-						// increment coarse X
-						ppu.v++
+						// increment coarse Y
+						y++
 					}
+					// put coarse Y back into v
+					ppu.v = (ppu.v & 0xFC1F) | (y << 5)
+				}
+			}
+			if ppu.Cycle == 257 {
+				// copy X
+				// hori(v) = hori(t)
+				// v: .....F.. ...EDCBA = t: .....F.. ...EDCBA
+				ppu.v = (ppu.v & 0xFBE0) | (ppu.t & 0x041F)
+			}
+		}
+	}
+
+	// sprite logic
+	if renderingEnabled && visibleLine {
+		// secondary OAM gets the (up to 8) sprites on the next line
+		spriteHeight := 8
+		if ppu.flagSpriteSize != 0 {
+			spriteHeight = 16
+		}
+		inRange := func (y byte) bool {
+			row := ppu.ScanLine - int(y)
+			return row >= 0 && row < spriteHeight
+		}
+		if ppu.Cycle >= 1 && ppu.Cycle <= 64 {
+			// clear secondary OAM; reads of $2004 see $FF meanwhile
+			ppu.oamLatch = 0xFF
+			ppu.secondaryOAM[(ppu.Cycle-1)/2] = 0xFF
+		} else if ppu.Cycle >= 65 && ppu.Cycle <= 256 {
+			// evaluate sprites: read from OAM on odd cycles, write to
+			// secondary OAM on even ones. OAMADDR is the read pointer,
+			// which is why writing it mid-frame garbles the sprites.
+			if ppu.Cycle == 65 {
+				ppu.evalCount = 0
+				ppu.evalCopy = 0
+				ppu.evalDone = false
+				ppu.evalSpriteZero = false
+				ppu.evalNext = 64
+			}
+			if ppu.Cycle%2 == 1 {
+				ppu.oamLatch = ppu.oamData[ppu.oamAddress]
+				if ppu.oamAddress&3 == 2 {
+					// bits 2-4 of sprite attributes don't exist
+					ppu.oamLatch &= 0xE3
 				}
-				if ppu.Cycle == 256 {
-					// increment Y
-					// increment vert(v)
-					// if fine Y < 7
-					if ppu.v&0x7000 != 0x7000 {
-						// increment fine Y
-						ppu.v += 0x1000
+			} else {
+				previous := ppu.oamAddress
+				switch {
+				case ppu.evalDone:
+					// keeps reading the Y of each sprite and fails to copy it
+					ppu.oamAddress += 4
+				case ppu.evalCount < 8:
+					ppu.secondaryOAM[ppu.evalCount*4+ppu.evalCopy] = ppu.oamLatch
+					if ppu.evalCopy == 0 && !inRange(ppu.oamLatch) {
+						ppu.oamAddress += 4
 					} else {
-						// fine Y = 0
-						ppu.v &= 0x8FFF
-						// let y = coarse Y
-						y := (ppu.v & 0x03E0) >> 5
-						if y == 29 {
-							// coarse Y = 0
-							y = 0
-							// switch vertical nametable
-							ppu.v ^= 0x0800
-						} else if y == 31 {
-							// coarse Y = 0, nametable not switched
-							y = 0
+						if ppu.evalCopy == 0 && ppu.Cycle == 66 {
+							ppu.evalSpriteZero = true
+						}
+						ppu.oamAddress++
+						ppu.evalCopy++
+						if ppu.evalCopy == 4 {
+							ppu.evalCopy = 0
+							ppu.evalCount++
+							if ppu.evalCount == 8 {
+								ppu.evalNext = int(previous>>2) + 1
+							}
+						}
+					}
+				default:
+					// 8 sprites found: the hardware goes on to look for a
+					// ninth, but it advances to the next sprite by
+					// incrementing both the sprite and the byte within
+					// it, so it checks tile numbers, attributes and X
+					// coordinates as if they were Y coordinates
+					if ppu.evalCopy == 0 {
+						if inRange(ppu.oamLatch) {
+							ppu.flagSpriteOverflow = 1
+							ppu.oamAddress++
+							ppu.evalCopy++
 						} else {
-							// increment coarse Y
-							y++
+							ppu.oamAddress = (ppu.oamAddress+4)&0xFC | (ppu.oamAddress+1)&3
+						}
+					} else {
+						ppu.oamAddress++
+						ppu.evalCopy++
+						if ppu.evalCopy == 4 {
+							ppu.evalCopy = 0
+							ppu.evalDone = true
 						}
-						// put coarse Y back into v
-						ppu.v = (ppu.v & 0xFC1F) | (y << 5)
 					}
 				}
-				if ppu.Cycle == 257 {
-					// copy X
-					// hori(v) = hori(t)
-					// v: .....F.. ...EDCBA = t: .....F.. ...EDCBA
-					ppu.v = (ppu.v & 0xFBE0) | (ppu.t & 0x041F)
+				if ppu.oamAddress < previous {
+					// wrapped around: all 64 sprites have been seen
+					ppu.evalDone = true
 				}
 			}
 		}
-
-		// sprite logic
-		if renderingEnabled && ppu.Cycle == 257 {
-			if visibleLine {
-				// evaluate sprites
-				var h int
+	}
+	if renderingEnabled && renderLine && ppu.Cycle >= 257 && ppu.Cycle <= 320 {
+		// sprite fetches read back secondary OAM, 8 cycles per sprite
+		ppu.oamAddress = 0
+		n := (ppu.Cycle - 257) / 8
+		m := (ppu.Cycle - 257) % 8
+		if m > 3 {
+			m = 3
+		}
+		ppu.oamLatch = ppu.secondaryOAM[n*4+m]
+	}
+	if renderingEnabled && renderLine && (ppu.Cycle >= 321 || ppu.Cycle == 0) {
+		ppu.oamLatch = ppu.secondaryOAM[0]
+	}
+	if renderingEnabled && ppu.Cycle == 257 {
+		if visibleLine {
+			// fetch the patterns of the sprites found
+			fetch := func (slot int, sprite []byte) {
+				y := sprite[0]
+				tile := sprite[1]
+				attributes := sprite[2]
+				x := sprite[3]
+				row := ppu.ScanLine - int(y)
+				var spritePattern uint32
+				var address uint16
 				if ppu.flagSpriteSize == 0 {
-					h = 8
+					if attributes&0x80 == 0x80 {
+						row = 7 - row
+					}
+					table := ppu.flagSpriteTable
+					address = 0x1000*uint16(table) + uint16(tile)*16 + uint16(row)
 				} else {
-					h = 16
-				}
-				count := 0
-				for i := 0; i < 64; i++ {
-					y := ppu.oamData[i*4+0]
-					a := ppu.oamData[i*4+2]
-					x := ppu.oamData[i*4+3]
-					row := ppu.ScanLine - int(y)
-					if row < 0 || row >= h {
-						continue
+					if attributes&0x80 == 0x80 {
+						row = 15 - row
 					}
-					if count < 8 {
-						// fetch sprite pattern
-						var spritePattern uint32
-						{
-							tile := ppu.oamData[i*4+1]
-							attributes := ppu.oamData[i*4+2]
-							var address uint16
-							if ppu.flagSpriteSize == 0 {
-								if attributes&0x80 == 0x80 {
-									row = 7 - row
-								}
-								table := ppu.flagSpriteTable
-								address = 0x1000*uint16(table) + uint16(tile)*16 + uint16(row)
-							} else {
-								if attributes&0x80 == 0x80 {
-									row = 15 - row
-								}
-								table := tile & 1
-								tile &= 0xFE
-								if row > 7 {
-									tile++
-									row -= 8
-								}
-								address = 0x1000*uint16(table) + uint16(tile)*16 + uint16(row)
-							}
-							atts := (attributes & 3) << 2
-							lowTileByte := readPPU(console, address)
-							highTileByte := readPPU(console, address + 8)
-							
-							for i := 0; i < 8; i++ {
-								var p1, p2 byte
-								if attributes&0x40 == 0x40 {
-									p1 = (lowTileByte & 1) << 0
-									p2 = (highTileByte & 1) << 1
-									lowTileByte >>= 1
-									highTileByte >>= 1
-								} else {
-									p1 = (lowTileByte & 0x80) >> 7
-									p2 = (highTileByte & 0x80) >> 6
-									lowTileByte <<= 1
-									highTileByte <<= 1
-								}
-								spritePattern <<= 4
-								spritePattern |= uint32(atts | p1 | p2)
-							}
-						}
-						ppu.spritePatterns[count] = spritePattern
-						ppu.spritePositions[count] = x
-						ppu.spritePriorities[count] = (a >> 5) & 1
-						ppu.spriteIndexes[count] = byte(i)
+					table := tile & 1
+					tile &= 0xFE
+					if row > 7 {
+						tile++
+						row -= 8
 					}
-					count++
+					address = 0x1000*uint16(table) + uint16(tile)*16 + uint16(row)
 				}
-				if count > 8 {
-					count = 8
-					ppu.flagSpriteOverflow = 1
+				atts := (attributes & 3) << 2
+				lowTileByte := fetchPattern(console, address)
+				highTileByte := fetchPattern(console, address + 8)
+
+				for i := 0; i < 8; i++ {
+					var p1, p2 byte
+					if attributes&0x40 == 0x40 {
+						p1 = (lowTileByte & 1) << 0
+						p2 = (highTileByte & 1) << 1
+						lowTileByte >>= 1
+						highTileByte >>= 1
+					} else {
+						p1 = (lowTileByte & 0x80) >> 7
+						p2 = (highTileByte & 0x80) >> 6
+						lowTileByte <<= 1
+						highTileByte <<= 1
+					}
+					spritePattern <<= 4
+					spritePattern |= uint32(atts | p1 | p2)
 				}
-				ppu.spriteCount = count
-			} else {
-				ppu.spriteCount = 0
+				ppu.spritePatterns[slot] = spritePattern
+				ppu.spritePositions[slot] = x
+				ppu.spritePriorities[slot] = (attributes >> 5) & 1
+			}
+			count := ppu.evalCount
+			for i := 0; i < count; i++ {
+				fetch(i, ppu.secondaryOAM[i*4:i*4+4])
 			}
+			if ppu.unlimitedSprites {
+				// the sprites the hardware would have dropped
+				h := 8
+				if ppu.flagSpriteSize != 0 {
+					h = 16
+				}
+				for i := ppu.evalNext; i < 64; i++ {
+					row := ppu.ScanLine - int(ppu.oamData[i*4])
+					if row >= 0 && row < h {
+						fetch(count, ppu.oamData[i*4:i*4+4])
+						count++
+					}
+				}
+			}
+			ppu.spriteCount = count
+			ppu.spriteZero = ppu.evalSpriteZero
+		} else {
+			ppu.spriteCount = 0
 		}
+	}
 
-		// vblank logic
-		if ppu.ScanLine == 241 && ppu.Cycle == 1 {
-			// set vertical blank
-			ppu.front, ppu.back = ppu.back, ppu.front
+	// vblank logic
+	if ppu.ScanLine == 241 && ppu.Cycle == 1 {
+		// set vertical blank
+		ppu.front, ppu.back = ppu.back, ppu.front
+		ppu.frontIndex, ppu.backIndex = ppu.backIndex, ppu.frontIndex
+		ppu.frontStale = ppu.indexed
+		if !ppu.suppressVBlank {
 			ppu.nmiOccurred = true
 			nmiChangePPU(ppu)
 		}
-		if preLine && ppu.Cycle == 1 {
-			// clear vertical blank
-			ppu.nmiOccurred = false
-			nmiChangePPU(ppu)
-		
-			ppu.flagSpriteZeroHit = 0
-			ppu.flagSpriteOverflow = 0
-		}
+		ppu.suppressVBlank = false
 	}
+	if preLine && ppu.Cycle == 1 && renderingEnabled && ppu.oamAddress >= 8 {
+		// OAM corruption: rendering starting with OAMADDR past the
+		// first two sprites copies that row of OAM over them
+		copy(ppu.oamData[:8], ppu.oamData[ppu.oamAddress&0xF8:])
+	}
+	if preLine && ppu.Cycle == 1 {
+		// clear vertical blank
+		ppu.nmiOccurred = false
+		nmiChangePPU(ppu)
+	
+		ppu.flagSpriteZeroHit = 0
+		ppu.flagSpriteOverflow = 0
+		ppu.warmingUp = false
+	}
+}
 
-
-	stepAPU := func (apu *APU) {
-		stepEnvelope := func (apu *APU) {
-			pulseStepEnvelope := func (p *Pulse) {
-				if p.envelopeStart {
+// stepAPU executes a single APU cycle
+func stepAPU(console *Console) {
+	apu := console.APU
+	stepEnvelope := func (apu *APU) {
+		pulseStepEnvelope := func (p *Pulse) {
+			if p.envelopeStart {
+				p.envelopeVolume = 15
+				p.envelopeValue = p.envelopePeriod
+				p.envelopeStart = false
+			} else if p.envelopeValue > 0 {
+				p.envelopeValue--
+			} else {
+				if p.envelopeVolume > 0 {
+					p.envelopeVolume--
+				} else if p.envelopeLoop {
 					p.envelopeVolume = 15
-					p.envelopeValue = p.envelopePeriod
-					p.envelopeStart = false
-				} else if p.envelopeValue > 0 {
-					p.envelopeValue--
-				} else {
-					if p.envelopeVolume > 0 {
-						p.envelopeVolume--
-					} else if p.envelopeLoop {
-						p.envelopeVolume = 15
-					}
-					p.envelopeValue = p.envelopePeriod
 				}
+				p.envelopeValue = p.envelopePeriod
 			}
-			pulseStepEnvelope(&apu.pulse1)
-			pulseStepEnvelope(&apu.pulse2)
-
-			t := &apu.triangle
-			if t.counterReload {
-				t.counterValue = t.counterPeriod
-			} else if t.counterValue > 0 {
-				t.counterValue--
-			}
-			if t.lengthEnabled {
-				t.counterReload = false
-			}
+		}
+		pulseStepEnvelope(&apu.pulse1)
+		pulseStepEnvelope(&apu.pulse2)
 
-			n := &apu.noise
-			if n.envelopeStart {
+		t := &apu.triangle
+		if t.counterReload {
+			t.counterValue = t.counterPeriod
+		} else if t.counterValue > 0 {
+			t.counterValue--
+		}
+		if t.lengthEnabled {
+			t.counterReload = false
+		}
+
+		n := &apu.noise
+		if n.envelopeStart {
+			n.envelopeVolume = 15
+			n.envelopeValue = n.envelopePeriod
+			n.envelopeStart = false
+		} else if n.envelopeValue > 0 {
+			n.envelopeValue--
+		} else {
+			if n.envelopeVolume > 0 {
+				n.envelopeVolume--
+			} else if n.envelopeLoop {
 				n.envelopeVolume = 15
-				n.envelopeValue = n.envelopePeriod
-				n.envelopeStart = false
-			} else if n.envelopeValue > 0 {
-				n.envelopeValue--
-			} else {
-				if n.envelopeVolume > 0 {
-					n.envelopeVolume--
-				} else if n.envelopeLoop {
-					n.envelopeVolume = 15
-				}
-				n.envelopeValue = n.envelopePeriod
 			}
+			n.envelopeValue = n.envelopePeriod
 		}
+	}
 
-		stepLength := func (apu *APU)  {
-			if apu.pulse1.lengthEnabled && apu.pulse1.lengthValue > 0 {
-				apu.pulse1.lengthValue--
-			}
-			if apu.pulse2.lengthEnabled && apu.pulse2.lengthValue > 0 {
-				apu.pulse2.lengthValue--
-			}
-			if apu.triangle.lengthEnabled && apu.triangle.lengthValue > 0 {
-				apu.triangle.lengthValue--
-			}
-			if apu.noise.lengthEnabled && apu.noise.lengthValue > 0 {
-				apu.noise.lengthValue--
-			}
+	stepLength := func (apu *APU)  {
+		if apu.pulse1.lengthEnabled && apu.pulse1.lengthValue > 0 {
+			apu.pulse1.lengthValue--
+		}
+		if apu.pulse2.lengthEnabled && apu.pulse2.lengthValue > 0 {
+			apu.pulse2.lengthValue--
+		}
+		if apu.triangle.lengthEnabled && apu.triangle.lengthValue > 0 {
+			apu.triangle.lengthValue--
+		}
+		if apu.noise.lengthEnabled && apu.noise.lengthValue > 0 {
+			apu.noise.lengthValue--
 		}
+	}
 
-		cycle1 := apu.cycle
-		apu.cycle++
-		cycle2 := apu.cycle
+	cycle1 := apu.cycle
+	apu.cycle++
+	cycle2 := apu.cycle
 
-		// step timers
-		{
-			if apu.cycle % 2 == 0 {
-				stepPulseTimer := func (p *Pulse) {
-					if p.timerValue == 0 {
-						p.timerValue = p.timerPeriod
-						p.dutyValue = (p.dutyValue + 1) % 8
-					} else {
-						p.timerValue--
-					}
+	// step timers
+	{
+		if apu.cycle % 2 == 0 {
+			stepPulseTimer := func (p *Pulse) {
+				if p.timerValue == 0 {
+					p.timerValue = p.timerPeriod
+					p.dutyValue = (p.dutyValue + 1) % 8
+				} else {
+					p.timerValue--
 				}
-				stepPulseTimer(&apu.pulse1)
-				stepPulseTimer(&apu.pulse2)
-
-				n := &apu.noise
-				if n.timerValue == 0 {
-					n.timerValue = n.timerPeriod
-					var shift byte
-					if n.mode {
-						shift = 6
-					} else {
-						shift = 1
-					}
-					b1 := n.shiftRegister & 1
-					b2 := (n.shiftRegister >> shift) & 1
-					n.shiftRegister >>= 1
-					n.shiftRegister |= (b1 ^ b2) << 14
+			}
+			stepPulseTimer(&apu.pulse1)
+			stepPulseTimer(&apu.pulse2)
+
+			n := &apu.noise
+			if n.timerValue == 0 {
+				n.timerValue = n.timerPeriod
+				var shift byte
+				if n.mode {
+					shift = 6
 				} else {
-					n.timerValue--
+					shift = 1
 				}
+				b1 := n.shiftRegister & 1
+				b2 := (n.shiftRegister >> shift) & 1
+				n.shiftRegister >>= 1
+				n.shiftRegister |= (b1 ^ b2) << 14
+			} else {
+				n.timerValue--
+			}
 
-				d := &apu.dmc
-				if d.enabled {
-					// step reader
-					if d.currentLength > 0 && d.bitCount == 0 {
-						console.CPU.stall += 4
-						d.shiftRegister = readByte(console, d.currentAddress)
-						d.bitCount = 8
-						d.currentAddress++
-						if d.currentAddress == 0 {
-							d.currentAddress = 0x8000
-						}
-						d.currentLength--
-						if d.currentLength == 0 && d.loop {
-							dmcRestart(d)
-						}
-					}
+			d := &apu.dmc
+			if d.enabled {
+				// step reader
+				if d.currentLength > 0 && d.bitCount == 0 {
+					// the DMA unit halts the CPU to fetch the byte,
+					// see runDMA and dmcFill
+					console.CPU.dmcDMA = true
+				}
 
-					if d.tickValue == 0 {
-						d.tickValue = d.tickPeriod
-						
-						// step shifter
-						if d.bitCount != 0 {
-							if d.shiftRegister&1 == 1 {
-								if d.value <= 125 {
-									d.value += 2
-								}
-							} else {
-								if d.value >= 2 {
-									d.value -= 2
-								}
+				if d.tickValue == 0 {
+					d.tickValue = d.tickPeriod
+					
+					// step shifter
+					if d.bitCount != 0 {
+						if d.shiftRegister&1 == 1 {
+							if d.value <= 125 {
+								d.value += 2
+							}
+						} else {
+							if d.value >= 2 {
+								d.value -= 2
 							}
-							d.shiftRegister >>= 1
-							d.bitCount--
 						}
-					} else {
-						d.tickValue--
+						d.shiftRegister >>= 1
+						d.bitCount--
 					}
+				} else {
+					d.tickValue--
 				}
 			}
+		}
 
-			t := &apu.triangle
-			if t.timerValue == 0 {
-				t.timerValue = t.timerPeriod
-				if t.lengthValue > 0 && t.counterValue > 0 {
-					t.dutyValue = (t.dutyValue + 1) % 32
-				}
-			} else {
-				t.timerValue--
+		t := &apu.triangle
+		if t.timerValue == 0 {
+			t.timerValue = t.timerPeriod
+			if t.lengthValue > 0 && t.counterValue > 0 {
+				t.dutyValue = (t.dutyValue + 1) % 32
 			}
+		} else {
+			t.timerValue--
 		}
-		
-		f1 := int(float64(cycle1) / frameCounterRate)
-		f2 := int(float64(cycle2) / frameCounterRate)
-		if f1 != f2 {
-			// step frame counters:
-
-			stepSweep := func (apu *APU) {
-				pulseStepSweep := func (p *Pulse)  {
-					sweep := func (p *Pulse) {
-						delta := p.timerPeriod >> p.sweepShift
-						if p.sweepNegate {
-							p.timerPeriod -= delta
-							if p.channel == 1 {
-								p.timerPeriod--
-							}
-						} else {
-							p.timerPeriod += delta
-						}
-					}
+	}
+	
+	f1 := int(float64(cycle1) / frameCounterRate)
+	f2 := int(float64(cycle2) / frameCounterRate)
+	if f1 != f2 {
+		// step frame counters:
 
-					if p.sweepReload {
-						if p.sweepEnabled && p.sweepValue == 0 {
-							sweep(p)
+		stepSweep := func (apu *APU) {
+			pulseStepSweep := func (p *Pulse)  {
+				sweep := func (p *Pulse) {
+					delta := p.timerPeriod >> p.sweepShift
+					if p.sweepNegate {
+						p.timerPeriod -= delta
+						if p.channel == 1 {
+							p.timerPeriod--
 						}
-						p.sweepValue = p.sweepPeriod
-						p.sweepReload = false
-					} else if p.sweepValue > 0 {
-						p.sweepValue--
 					} else {
-						if p.sweepEnabled {
-							sweep(p)
-						}
-						p.sweepValue = p.sweepPeriod
+						p.timerPeriod += delta
 					}
 				}
-				pulseStepSweep(&apu.pulse1)
-				pulseStepSweep(&apu.pulse2)
-			}
 
-			// mode 0:    mode 1:       function
-			// ---------  -----------  -----------------------------
-			//  - - - f    - - - - -    IRQ (if bit 6 is clear)
-			//  - l - l    l - l - -    Length counter and sweep
-			//  e e e e    e e e e -    Envelope and linear counter
-			switch apu.framePeriod {
-			case 4:
-				apu.frameValue = (apu.frameValue + 1) % 4
-				switch apu.frameValue {
-				case 0, 2:
-					stepEnvelope(apu)
-				case 1:
-					stepEnvelope(apu)
-					stepSweep(apu)
-					stepLength(apu)
-				case 3:
-					stepEnvelope(apu)
-					stepSweep(apu)
-					stepLength(apu)
-					// fire IRQ
-					if apu.frameIRQ {
-						triggerIRQ(console.CPU)
+				if p.sweepReload {
+					if p.sweepEnabled && p.sweepValue == 0 {
+						sweep(p)
 					}
-				}
-			case 5:
-				apu.frameValue = (apu.frameValue + 1) % 5
-				switch apu.frameValue {
-				case 1, 3:
-					stepEnvelope(apu)
-				case 0, 2:
-					stepEnvelope(apu)
-					stepSweep(apu)
-					stepLength(apu)
-				}
-			}
-		}
-		s1 := int(float64(cycle1) / sampleRate)
-		s2 := int(float64(cycle2) / sampleRate)
-		if s1 != s2 {
-			// pulse output
-			pulseOutput := func (p *Pulse) byte {
-				if !p.enabled || p.lengthValue == 0 || dutyTable[p.dutyMode][p.dutyValue] == 0 || p.timerPeriod < 8 || p.timerPeriod > 0x7FF {
-					return 0
-				} else if p.envelopeEnabled {
-					return p.envelopeVolume
+					p.sweepValue = p.sweepPeriod
+					p.sweepReload = false
+				} else if p.sweepValue > 0 {
+					p.sweepValue--
 				} else {
-					return p.constantVolume
+					if p.sweepEnabled {
+						sweep(p)
+					}
+					p.sweepValue = p.sweepPeriod
 				}
 			}
-			p1Out := pulseOutput(&apu.pulse1)
-			p2Out := pulseOutput(&apu.pulse2)
-
-			// triangle output
-			t := &apu.triangle
-			var tOut byte
-			if !t.enabled || t.lengthValue == 0 || t.counterValue == 0 {
-				tOut = 0
-			} else {
-				tOut = triangleTable[t.dutyValue]
-			}
+			pulseStepSweep(&apu.pulse1)
+			pulseStepSweep(&apu.pulse2)
+		}
 
-			// noise output
-			n := &apu.noise
-			var nOut byte
-			if !n.enabled || n.lengthValue == 0 || (n.shiftRegister & 1) == 1 {
-				nOut = 0
-			} else if n.envelopeEnabled {
-				nOut = n.envelopeVolume
-			} else {
-				nOut = n.constantVolume
+		// mode 0:    mode 1:       function
+		// ---------  -----------  -----------------------------
+		//  - - - f    - - - - -    IRQ (if bit 6 is clear)
+		//  - l - l    l - l - -    Length counter and sweep
+		//  e e e e    e e e e -    Envelope and linear counter
+		switch apu.framePeriod {
+		case 4:
+			apu.frameValue = (apu.frameValue + 1) % 4
+			switch apu.frameValue {
+			case 0, 2:
+				stepEnvelope(apu)
+			case 1:
+				stepEnvelope(apu)
+				stepSweep(apu)
+				stepLength(apu)
+			case 3:
+				stepEnvelope(apu)
+				stepSweep(apu)
+				stepLength(apu)
+				// fire IRQ
+				if apu.frameIRQ {
+					apu.frameInterrupt = true
+				}
 			}
-
-			// dmc output
-			dOut := apu.dmc.value
-
-			output := tndTable[(3 * tOut) + (2 * nOut) + dOut] + pulseTable[p1Out + p2Out]
-			select {
-			case apu.channel <- output:
-			default:
+		case 5:
+			apu.frameValue = (apu.frameValue + 1) % 5
+			switch apu.frameValue {
+			case 1, 3:
+				stepEnvelope(apu)
+			case 0, 2:
+				stepEnvelope(apu)
+				stepSweep(apu)
+				stepLength(apu)
 			}
 		}
 	}
-
-	cycles := int(CPUFrequency * seconds)
-	for cycles > 0 {
-		// step cpu
-		var cpuCycles int
-		{
-			cpu := console.CPU
-			if cpu.stall > 0 {
-				cpu.stall--
-				cpuCycles = 1
+	s1 := int(float64(cycle1) / sampleRate)
+	s2 := int(float64(cycle2) / sampleRate)
+	if s1 != s2 {
+		// pulse output
+		pulseOutput := func (p *Pulse) byte {
+			if !p.enabled || p.lengthValue == 0 || dutyTable[p.dutyMode][p.dutyValue] == 0 || p.timerPeriod < 8 || p.timerPeriod > 0x7FF {
+				return 0
+			} else if p.envelopeEnabled {
+				return p.envelopeVolume
 			} else {
-				startCycles := cpu.Cycles
-
-				switch cpu.interrupt {
-				case interruptNMI:
-					// non-maskable interrupt
-					cpu := console.CPU
-					push16(console, cpu.PC)
-					php(console)
-					cpu.PC = read16(console, 0xFFFA)
-					cpu.I = 1
-					cpu.Cycles += 7
-				case interruptIRQ:
-					cpu := console.CPU
-					push16(console, cpu.PC)
-					php(console)
-					cpu.PC = read16(console, 0xFFFE)
-					cpu.I = 1
-					cpu.Cycles += 7
-				}
-				cpu.interrupt = interruptNone
-				opcode := readByte(console, cpu.PC)
-				executeInstruction(console, opcode)
-				cpuCycles = int(cpu.Cycles - startCycles)
+				return p.constantVolume
 			}
 		}
-		
-		ppuCycles := cpuCycles * 3
-		for i := 0; i < ppuCycles; i++ {
-			stepPPU(console.PPU)
-
-			switch m := console.Mapper.(type) {
-			case *Mapper1, *Mapper2, *Mapper3, *Mapper7:
-				// do nothing
-			case *Mapper4:
-				ppu := console.PPU
-				if ppu.Cycle == 280 &&
-						(ppu.ScanLine <= 239 || ppu.ScanLine >= 261) && 
-						(ppu.flagShowBackground != 0 || ppu.flagShowSprites != 0) {
-					if m.counter == 0 {
-						m.counter = m.reload
-					} else {
-						m.counter--
-						if m.counter == 0 && m.irqEnable {
-							triggerIRQ(console.CPU)
-						}
-					}
-				}
-			}
+		p1Out := pulseOutput(&apu.pulse1)
+		p2Out := pulseOutput(&apu.pulse2)
+
+		// triangle output
+		t := &apu.triangle
+		var tOut byte
+		if !t.enabled || t.lengthValue == 0 || t.counterValue == 0 {
+			tOut = 0
+		} else {
+			tOut = triangleTable[t.dutyValue]
 		}
-		for i := 0; i < cpuCycles; i++ {
-			stepAPU(console.APU)
+
+		// noise output
+		n := &apu.noise
+		var nOut byte
+		if !n.enabled || n.lengthValue == 0 || (n.shiftRegister & 1) == 1 {
+			nOut = 0
+		} else if n.envelopeEnabled {
+			nOut = n.envelopeVolume
+		} else {
+			nOut = n.constantVolume
+		}
+
+		// dmc output
+		dOut := apu.dmc.value
+
+		// record channel outputs for visualization
+		i := apu.scopeIndex
+		apu.scope[0][i] = p1Out
+		apu.scope[1][i] = p2Out
+		apu.scope[2][i] = tOut
+		apu.scope[3][i] = nOut
+		apu.scope[4][i] = dOut
+		apu.scopeIndex = (i + 1) % scopeLength
+
+		output := tndTable[(3 * tOut) + (2 * nOut) + dOut] + pulseTable[p1Out + p2Out]
+		select {
+		case apu.channel <- output:
+		default:
 		}
-		cycles -= cpuCycles
 	}
 }
 
 func nmiChangePPU(ppu *PPU) {
 	nmi := ppu.nmiOutput && ppu.nmiOccurred
 	if nmi && !ppu.nmiPrevious {
-		// TODO: this fixes some games but the delay shouldn't have to be so
-		// long, so the timings are off somewhere
-		ppu.nmiDelay = 15
+		ppu.nmiEdge = true
+	} else if !nmi && !ppu.nmiEdge {
+		// an edge the CPU hasn't seen yet still reaches it
+		ppu.nmiLine = false
 	}
 	ppu.nmiPrevious = nmi
 }
@@ -794,8 +984,62 @@ func dmcRestart(d *DMC) {
 	d.currentLength = d.sampleLength
 }
 
+// dmcFill hands the DMC the sample byte its DMA fetched
+func dmcFill(d *DMC, value byte) {
+	if d.currentLength == 0 {
+		// stopped by a write to $4015 while the DMA was pending
+		return
+	}
+	d.shiftRegister = value
+	d.bitCount = 8
+	d.currentAddress++
+	if d.currentAddress == 0 {
+		d.currentAddress = 0x8000
+	}
+	d.currentLength--
+	if d.currentLength == 0 && d.loop {
+		dmcRestart(d)
+	}
+}
+
+// Buffer returns the last complete frame. In indexed output mode it is
+// converted from IndexedBuffer with the active palette.
 func Buffer(console *Console) *image.RGBA {
-	return console.PPU.front
+	ppu := console.PPU
+	if ppu.frontStale {
+		for i, c := range ppu.frontIndex {
+			ppu.front.SetRGBA(i%256, i/256, ppu.palette[c])
+		}
+		ppu.frontStale = false
+	}
+	return ppu.front
+}
+
+// IndexedBuffer returns the last complete frame as 256x240 values of
+// emphasis<<6 | palette index, row by row. Only filled in indexed output mode.
+func IndexedBuffer(console *Console) []uint16 {
+	return console.PPU.frontIndex
+}
+
+// SetIndexedOutput switches the PPU between rendering RGBA pixels and
+// rendering palette indexes (see IndexedBuffer).
+func SetIndexedOutput(console *Console, indexed bool) {
+	console.PPU.indexed = indexed
+}
+
+// SetInitialRAM sets what RAM holds at power on (RAMZero, RAMFill,
+// RAMPattern or RAMRandom) and refills it, so it belongs right after
+// NewConsole. Games shouldn't depend on it, but some do.
+func SetInitialRAM(console *Console, initial int) {
+	console.initialRAM = initial
+	fillRAM(console.RAM, initial)
+}
+
+// SetUnlimitedSprites lifts the hardware's limit of 8 sprites per scanline,
+// which games rely on less than they suffer from (flicker, disappearing
+// sprites). The sprite overflow flag is still set as on hardware.
+func SetUnlimitedSprites(console *Console, unlimited bool) {
+	console.PPU.unlimitedSprites = unlimited
 }
 
 func SetButtons1(console *Console, buttons [8]bool) {
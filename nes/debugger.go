@@ -0,0 +1,441 @@
+package nes
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/BrianWill/nes/cpu6502"
+)
+
+// EnableDebugger attaches a debugger to the console (or returns the one
+// already attached). From then on every memory access is checked against
+// its watchpoints, which costs a little speed.
+func EnableDebugger(console *Console) *Debugger {
+	if console.Debugger == nil {
+		console.Debugger = &Debugger{executed: -1}
+	}
+	return console.Debugger
+}
+
+func DisableDebugger(console *Console) {
+	console.Debugger = nil
+}
+
+// AddBreakpoint adds an enabled copy of bp and returns its ID. An End
+// before Start watches just Start. Reads include the CPU's opcode and
+// operand fetches, its dummy reads and DMA; on the PPU side they include
+// rendering.
+func AddBreakpoint(d *Debugger, bp Breakpoint) (int, error) {
+	if bp.Kind&(BreakExecute|BreakRead|BreakWrite) == 0 {
+		return 0, errors.New("breakpoint doesn't break on anything")
+	}
+	if bp.PPU && bp.Kind&BreakExecute != 0 {
+		return 0, errors.New("the PPU doesn't execute code")
+	}
+	if bp.End < bp.Start {
+		bp.End = bp.Start
+	}
+	condition, err := compileCondition(bp.Condition)
+	if err != nil {
+		return 0, err
+	}
+	d.nextID++
+	bp.ID = d.nextID
+	bp.Enabled = true
+	bp.Hits = 0
+	bp.condition = condition
+	d.Breakpoints = append(d.Breakpoints, &bp)
+	return bp.ID, nil
+}
+
+// RemoveBreakpoint removes the breakpoint with the given ID, reporting
+// whether there was one
+func RemoveBreakpoint(d *Debugger, id int) bool {
+	for i, bp := range d.Breakpoints {
+		if bp.ID == id {
+			d.Breakpoints = append(d.Breakpoints[:i], d.Breakpoints[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Pause stops a run in progress after the current instruction. It's safe
+// to call from another goroutine, e.g. on Ctrl-C.
+func Pause(d *Debugger) {
+	atomic.StoreInt32(&d.paused, 1)
+}
+
+// StepInto runs one instruction, or the interrupt sequence if one is due
+func StepInto(console *Console) Stop {
+	return debugRun(console, 0, func () bool {
+		return true
+	})
+}
+
+// StepOver runs one instruction, but runs a JSR's whole subroutine
+func StepOver(console *Console) Stop {
+	cpu := console.CPU
+	if Peek(console, cpu.PC) != 0x20 || cpu6502.InterruptPending(&cpu.CPU) {
+		return StepInto(console)
+	}
+	pc := cpu.PC + 3
+	sp := cpu.SP
+	return debugRun(console, 0, func () bool {
+		return cpu.PC == pc && cpu.SP == sp
+	})
+}
+
+// StepOut runs until the current subroutine or interrupt handler returns
+func StepOut(console *Console) Stop {
+	cpu := console.CPU
+	d := console.Debugger
+	sp := cpu.SP
+	return debugRun(console, 0, func () bool {
+		return (d.executed == 0x60 || d.executed == 0x40) && cpu.SP > sp
+	})
+}
+
+// RunToScanline runs until the PPU starts the given scanline (0-261)
+func RunToScanline(console *Console, line int) Stop {
+	ppu := console.PPU
+	left := ppu.ScanLine != line
+	return debugRun(console, 0, func () bool {
+		if ppu.ScanLine != line {
+			left = true
+		}
+		return left && ppu.ScanLine == line
+	})
+}
+
+// Continue runs until a breakpoint hits or Pause is called, or for at most
+// limit CPU cycles if limit > 0
+func Continue(console *Console, limit int) Stop {
+	return debugRun(console, limit, nil)
+}
+
+// debugRun runs whole instructions until done says to stop (done may be
+// nil), a breakpoint hits, Pause is called or limit cycles have passed.
+// An execute breakpoint on the instruction it starts at doesn't hit, so
+// that a run can continue from one.
+func debugRun(console *Console, limit int, done func () bool) Stop {
+	cpu := console.CPU
+	d := console.Debugger
+	atomic.StoreInt32(&d.paused, 0)
+	d.stop = nil
+	for cycles := 0; ; {
+		if cycles > 0 {
+			if stop := checkExecute(console); stop != nil {
+				return *stop
+			}
+		}
+		if cpu6502.InterruptPending(&cpu.CPU) {
+			d.executed = -1
+		} else {
+			d.executed = int(Peek(console, cpu.PC))
+		}
+		cycles += stepCPU(console)
+		if d.stop != nil {
+			stop := *d.stop
+			d.stop = nil
+			return stop
+		}
+		if done != nil && done() {
+			return Stop{Reason: StopDone}
+		}
+		if atomic.LoadInt32(&d.paused) != 0 {
+			return Stop{Reason: StopPaused}
+		}
+		if limit > 0 && cycles >= limit {
+			return Stop{Reason: StopLimit}
+		}
+	}
+}
+
+// checkExecute checks the instruction about to run against the
+// breakpoints. An interrupt about to be taken doesn't count.
+func checkExecute(console *Console) *Stop {
+	cpu := console.CPU
+	if cpu6502.InterruptPending(&cpu.CPU) {
+		return nil
+	}
+	opcode := Peek(console, cpu.PC)
+	for _, bp := range console.Debugger.Breakpoints {
+		if breakpointHits(console, bp, false, BreakExecute, cpu.PC, opcode) {
+			return &Stop{StopBreakpoint, bp, BreakExecute, cpu.PC, opcode}
+		}
+	}
+	return nil
+}
+
+// debugAccess checks a memory access against the watchpoints. The first
+// hit stops the run once the instruction making it is done.
+func debugAccess(console *Console, ppu bool, kind byte, address uint16, value byte) {
+	d := console.Debugger
+	if d.stop != nil {
+		return
+	}
+	for _, bp := range d.Breakpoints {
+		if breakpointHits(console, bp, ppu, kind, address, value) {
+			d.stop = &Stop{StopBreakpoint, bp, kind, address, value}
+			return
+		}
+	}
+}
+
+func breakpointHits(console *Console, bp *Breakpoint, ppu bool, kind byte, address uint16, value byte) bool {
+	if !bp.Enabled || bp.PPU != ppu || bp.Kind&kind == 0 || address < bp.Start || address > bp.End {
+		return false
+	}
+	if bp.condition != nil && bp.condition(console, address, value) == 0 {
+		return false
+	}
+	bp.Hits++
+	return true
+}
+
+// compileCondition compiles a breakpoint condition, which is an expression
+// in C-like syntax (|| && | ^ & == != < <= > >= + - and unary ! - ~, with
+// comparisons giving 1 or 0) over:
+//
+//	a x y sp pc p        registers
+//	c z i d v n          flags
+//	scanline dot frame   the PPU's position
+//	cycles               CPU cycles since power on
+//	address value        the access that hit (the PC and opcode for execute)
+//	prg                  the byte of PRG ROM that address maps to now, or -1
+//	[expr]               the byte at a CPU address (see Peek)
+//
+// Numbers are decimal, or hex with a $ or 0x prefix. The condition holds
+// when the result isn't 0. An empty condition compiles to nil.
+func compileCondition(source string) (func (console *Console, address uint16, value byte) int, error) {
+	type term func (console *Console, address uint16, value byte) int
+	if strings.TrimSpace(source) == "" {
+		return nil, nil
+	}
+
+	// tokens are numbers, names, and operators of up to 2 characters
+	var tokens []string
+	isWord := func (ch byte) bool {
+		return ch == '$' || ch == '_' || ch >= '0' && ch <= '9' ||
+			ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z'
+	}
+	twoChars := map[string]bool{"||": true, "&&": true, "==": true, "!=": true, "<=": true, ">=": true}
+	for i := 0; i < len(source); {
+		ch := source[i]
+		switch {
+		case ch == ' ' || ch == '\t':
+			i++
+		case isWord(ch):
+			j := i + 1
+			for j < len(source) && isWord(source[j]) {
+				j++
+			}
+			tokens = append(tokens, strings.ToLower(source[i:j]))
+			i = j
+		case i+1 < len(source) && twoChars[source[i:i+2]]:
+			tokens = append(tokens, source[i:i+2])
+			i += 2
+		case strings.IndexByte("|^&<>+-!~()[]", ch) >= 0:
+			tokens = append(tokens, source[i:i+1])
+			i++
+		default:
+			return nil, fmt.Errorf("unexpected %q in condition", ch)
+		}
+	}
+
+	binary := map[string]func (a, b int) int{
+		"||": func (a, b int) int { return truth(a != 0 || b != 0) },
+		"&&": func (a, b int) int { return truth(a != 0 && b != 0) },
+		"|": func (a, b int) int { return a | b },
+		"^": func (a, b int) int { return a ^ b },
+		"&": func (a, b int) int { return a & b },
+		"==": func (a, b int) int { return truth(a == b) },
+		"!=": func (a, b int) int { return truth(a != b) },
+		"<": func (a, b int) int { return truth(a < b) },
+		"<=": func (a, b int) int { return truth(a <= b) },
+		">": func (a, b int) int { return truth(a > b) },
+		">=": func (a, b int) int { return truth(a >= b) },
+		"+": func (a, b int) int { return a + b },
+		"-": func (a, b int) int { return a - b },
+	}
+	// binary operators from loosest to tightest binding
+	levels := [][]string{{"||"}, {"&&"}, {"|"}, {"^"}, {"&"}, {"==", "!="}, {"<", "<=", ">", ">="}, {"+", "-"}}
+
+	variables := map[string]term{
+		"a": func (console *Console, address uint16, value byte) int { return int(console.CPU.A) },
+		"x": func (console *Console, address uint16, value byte) int { return int(console.CPU.X) },
+		"y": func (console *Console, address uint16, value byte) int { return int(console.CPU.Y) },
+		"sp": func (console *Console, address uint16, value byte) int { return int(console.CPU.SP) },
+		"pc": func (console *Console, address uint16, value byte) int { return int(console.CPU.PC) },
+		"p": func (console *Console, address uint16, value byte) int { return int(cpu6502.Flags(&console.CPU.CPU)) },
+		"c": func (console *Console, address uint16, value byte) int { return int(console.CPU.C) },
+		"z": func (console *Console, address uint16, value byte) int { return int(console.CPU.Z) },
+		"i": func (console *Console, address uint16, value byte) int { return int(console.CPU.I) },
+		"d": func (console *Console, address uint16, value byte) int { return int(console.CPU.D) },
+		"v": func (console *Console, address uint16, value byte) int { return int(console.CPU.V) },
+		"n": func (console *Console, address uint16, value byte) int { return int(console.CPU.N) },
+		"scanline": func (console *Console, address uint16, value byte) int { return console.PPU.ScanLine },
+		"dot": func (console *Console, address uint16, value byte) int { return console.PPU.Cycle },
+		"frame": func (console *Console, address uint16, value byte) int { return int(console.PPU.Frame) },
+		"cycles": func (console *Console, address uint16, value byte) int { return int(console.CPU.Cycles) },
+		"address": func (console *Console, address uint16, value byte) int { return int(address) },
+		"value": func (console *Console, address uint16, value byte) int { return int(value) },
+		"prg": func (console *Console, address uint16, value byte) int { return prgOffset(console, address) },
+	}
+
+	// recursive descent, one level of levels per call
+	pos := 0
+	next := func () string {
+		if pos < len(tokens) {
+			return tokens[pos]
+		}
+		return ""
+	}
+	var parse func (level int) (term, error)
+	parse = func (level int) (term, error) {
+		if level == len(levels) {
+			// unary operators and operands
+			token := next()
+			pos++
+			switch {
+			case token == "":
+				return nil, errors.New("condition ends too soon")
+			case token == "!" || token == "-" || token == "~":
+				operand, err := parse(level)
+				if err != nil {
+					return nil, err
+				}
+				op := map[string]func (int) int{
+					"!": func (a int) int { return truth(a == 0) },
+					"-": func (a int) int { return -a },
+					"~": func (a int) int { return ^a },
+				}[token]
+				return func (console *Console, address uint16, value byte) int {
+					return op(operand(console, address, value))
+				}, nil
+			case token == "(" || token == "[":
+				inner, err := parse(0)
+				if err != nil {
+					return nil, err
+				}
+				closing := map[string]string{"(": ")", "[": "]"}[token]
+				if next() != closing {
+					return nil, fmt.Errorf("missing %s in condition", closing)
+				}
+				pos++
+				if token == "(" {
+					return inner, nil
+				}
+				return func (console *Console, address uint16, value byte) int {
+					return int(Peek(console, uint16(inner(console, address, value))))
+				}, nil
+			case variables[token] != nil:
+				return variables[token], nil
+			default:
+				var n uint64
+				var err error
+				// not base 0, which would read 010 as octal and take 0b and _
+				switch {
+				case strings.HasPrefix(token, "$"):
+					n, err = strconv.ParseUint(token[1:], 16, 32)
+				case strings.HasPrefix(token, "0x"), strings.HasPrefix(token, "0X"):
+					n, err = strconv.ParseUint(token[2:], 16, 32)
+				default:
+					n, err = strconv.ParseUint(token, 10, 32)
+				}
+				if err != nil {
+					return nil, fmt.Errorf("unknown %q in condition", token)
+				}
+				return func (console *Console, address uint16, value byte) int {
+					return int(n)
+				}, nil
+			}
+		}
+		left, err := parse(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		for {
+			token := next()
+			found := false
+			for _, op := range levels[level] {
+				found = found || op == token
+			}
+			if !found {
+				return left, nil
+			}
+			pos++
+			right, err := parse(level + 1)
+			if err != nil {
+				return nil, err
+			}
+			op, a := binary[token], left
+			left = func (console *Console, address uint16, value byte) int {
+				return op(a(console, address, value), right(console, address, value))
+			}
+		}
+	}
+	condition, err := parse(0)
+	if err != nil {
+		return nil, err
+	}
+	if pos != len(tokens) {
+		return nil, fmt.Errorf("unexpected %q in condition", tokens[pos])
+	}
+	return condition, nil
+}
+
+// Evaluate computes an expression in the syntax of breakpoint conditions
+// (see AddBreakpoint), taking address and value to be the PC and the
+// opcode there
+func Evaluate(console *Console, expression string) (int, error) {
+	term, err := compileCondition(expression)
+	if err != nil {
+		return 0, err
+	}
+	if term == nil {
+		return 0, errors.New("empty expression")
+	}
+	pc := console.CPU.PC
+	return term(console, pc, Peek(console, pc)), nil
+}
+
+// InspectPPU returns the PPU's registers, without the side effects of
+// reading them
+func InspectPPU(console *Console) PPUState {
+	ppu := console.PPU
+	state := PPUState{
+		ScanLine:   ppu.ScanLine,
+		Cycle:      ppu.Cycle,
+		Frame:      ppu.Frame,
+		OAMAddress: ppu.oamAddress,
+		V:          ppu.v,
+		T:          ppu.t,
+		X:          ppu.x,
+		W:          ppu.w,
+	}
+	state.Control = ppu.flagNameTable | ppu.flagIncrement<<2 | ppu.flagSpriteTable<<3 |
+		ppu.flagBackgroundTable<<4 | ppu.flagSpriteSize<<5 | ppu.flagMasterSlave<<6
+	if ppu.nmiOutput {
+		state.Control |= 0x80
+	}
+	state.Mask = ppu.flagGrayscale | ppu.flagShowLeftBackground<<1 | ppu.flagShowLeftSprites<<2 |
+		ppu.flagShowBackground<<3 | ppu.flagShowSprites<<4 |
+		ppu.flagRedTint<<5 | ppu.flagGreenTint<<6 | ppu.flagBlueTint<<7
+	state.Status = ppu.flagSpriteOverflow<<5 | ppu.flagSpriteZeroHit<<6
+	if ppu.nmiOccurred {
+		state.Status |= 0x80
+	}
+	return state
+}
+
+func truth(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
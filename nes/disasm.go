@@ -0,0 +1,369 @@
+package nes
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/BrianWill/nes/cpu6502"
+)
+
+// Disassemble decodes the instructions from start up to end as the CPU
+// would see them now, through the mapper's current banks. Each line holds
+// an address, the instruction's bytes and the instruction, with operands
+// shown by name where labels, the console's symbols (see LoadSymbols) or
+// RegisterNames has one; labels may be nil. With symbols, an instruction
+// that starts a source line or has a label gets a comment saying so.
+func Disassemble(console *Console, start, end uint16, labels map[uint16]string) []string {
+	read := func (address uint16) byte {
+		return Peek(console, address)
+	}
+	label := func (address uint16) (string, bool) {
+		if name, ok := labels[address]; ok {
+			return name, true
+		}
+		return SymbolAt(console, address)
+	}
+	last := int(end)
+	if end < start {
+		last = 0xFFFF
+	}
+	var lines []string
+	for address := int(start); address <= last; {
+		text, size := disassemble(read, uint16(address), label)
+		line := formatInstruction(read, uint16(address), size, text)
+		var notes []string
+		if name, ok := SymbolAt(console, uint16(address)); ok {
+			notes = append(notes, name)
+		}
+		if console.Symbols != nil {
+			if source, ok := sourceStart(console.Symbols, prgOffset(console, uint16(address)), uint16(address)); ok {
+				notes = append(notes, fmt.Sprintf("%s:%d", source.File, source.Line))
+			}
+		}
+		lines = append(lines, comment(line, notes))
+		address += size
+	}
+	return lines
+}
+
+// disassemble decodes the instruction at address, reading memory with
+// read, and returns its text and size. label names addresses.
+func disassemble(read func (uint16) byte, address uint16, label func (uint16) (string, bool)) (string, int) {
+	opcode := read(address)
+	instruction := cpu6502.Instructions[opcode]
+	size := int(instruction.Size)
+	var operand uint16
+	if size == 2 {
+		operand = uint16(read(address + 1))
+	} else if size == 3 {
+		operand = uint16(read(address + 1)) | uint16(read(address + 2))<<8
+	}
+	name := func (address uint16, digits int) string {
+		if label, ok := label(address); ok {
+			return label
+		}
+		if label, ok := RegisterNames[address]; ok {
+			return label
+		}
+		return fmt.Sprintf("$%0*X", digits, address)
+	}
+
+	var text string
+	switch instruction.Mode {
+	case cpu6502.ModeAbsolute:
+		text = name(operand, 4)
+	case cpu6502.ModeAbsoluteX:
+		text = name(operand, 4) + ",X"
+	case cpu6502.ModeAbsoluteY:
+		text = name(operand, 4) + ",Y"
+	case cpu6502.ModeAccumulator:
+		text = "A"
+	case cpu6502.ModeImmediate:
+		text = fmt.Sprintf("#$%02X", operand)
+	case cpu6502.ModeImplied:
+	case cpu6502.ModeIndexedIndirect:
+		text = "(" + name(operand, 2) + ",X)"
+	case cpu6502.ModeIndirect:
+		text = "(" + name(operand, 4) + ")"
+	case cpu6502.ModeIndirectIndexed:
+		text = "(" + name(operand, 2) + "),Y"
+	case cpu6502.ModeRelative:
+		text = name(address + 2 + uint16(int8(operand)), 4)
+	case cpu6502.ModeZeroPage:
+		text = name(operand, 2)
+	case cpu6502.ModeZeroPageX:
+		text = name(operand, 2) + ",X"
+	case cpu6502.ModeZeroPageY:
+		text = name(operand, 2) + ",Y"
+	}
+	if text == "" {
+		return instruction.Name, size
+	}
+	return instruction.Name + " " + text, size
+}
+
+// comment adds notes to a line of disassembly
+func comment(line string, notes []string) string {
+	if len(notes) == 0 {
+		return line
+	}
+	return fmt.Sprintf("%-32s ; %s", line, strings.Join(notes, ", "))
+}
+
+func formatInstruction(read func (uint16) byte, address uint16, size int, text string) string {
+	var bytes []string
+	for i := 0; i < size; i++ {
+		bytes = append(bytes, fmt.Sprintf("%02X", read(address + uint16(i))))
+	}
+	return fmt.Sprintf("%04X  %-8s  %s", address, strings.Join(bytes, " "), text)
+}
+
+// DisassembleROM writes a static disassembly of every PRG ROM bank in the
+// cartridge. Code is found by following the flow of control from the
+// reset, NMI and IRQ vectors, as the banks are arranged at power on (a
+// switched-out bank is shown where it would be switched in); everything
+// not reached that way is written out as data. Labels, which may be nil,
+// name addresses in addition to the generated ones.
+func DisassembleROM(console *Console, w io.Writer, labels map[uint16]string) {
+	prg := console.Cartridge.PRG
+
+	// how the mapper arranges PRG ROM: its bank size, where each bank sits
+	// at power on, and where the others get switched in
+	bankSize := 0x4000
+	switchable := uint16(0x8000)
+	mapped := map[int]uint16{}
+	switch m := console.Mapper.(type) {
+	case *Mapper1:
+		mapped[m.prgOffsets[0]/0x4000] = 0x8000
+		mapped[m.prgOffsets[1]/0x4000] = 0xC000
+	case *Mapper2:
+		mapped[m.prgBank1] = 0x8000
+		mapped[m.prgBank2] = 0xC000
+	case *Mapper3:
+		mapped[m.prgBank1] = 0x8000
+		mapped[m.prgBank2] = 0xC000
+	case *Mapper4:
+		bankSize = 0x2000
+		for i, offset := range m.prgOffsets {
+			mapped[offset/0x2000] = 0x8000 + uint16(i)*0x2000
+		}
+	case *Mapper7:
+		bankSize = 0x8000
+		mapped[m.prgBank] = 0x8000
+	}
+	if len(prg) < bankSize {
+		bankSize = len(prg)
+	}
+	banks := len(prg) / bankSize
+	base := make([]uint16, banks)
+	for i := range base {
+		if address, ok := mapped[i]; ok {
+			base[i] = address
+		} else {
+			base[i] = switchable
+		}
+	}
+	// the bank the CPU sees at address, from within bank
+	resolve := func (bank int, address uint16) (int, bool) {
+		if address >= base[bank] && int(address) < int(base[bank])+bankSize {
+			return bank, true
+		}
+		for i, b := range base {
+			if _, ok := mapped[i]; ok && address >= b && int(address) < int(b)+bankSize {
+				return i, true
+			}
+		}
+		return 0, false
+	}
+	readIn := func (bank int) func (uint16) byte {
+		return func (address uint16) byte {
+			if i, ok := resolve(bank, address); ok {
+				return prg[i*bankSize+int(address-base[i])]
+			}
+			return 0
+		}
+	}
+
+	// follow the code, marking its bytes and naming the places it goes
+	type location struct {
+		bank    int
+		address uint16
+	}
+	starts := make([]bool, len(prg))
+	names := map[location]string{}
+	var queue []location
+	visit := func (from int, address uint16, name string) {
+		bank, ok := resolve(from, address)
+		if !ok {
+			return
+		}
+		l := location{bank, address}
+		if _, ok := names[l]; !ok || name != "" && strings.HasPrefix(names[l], "L_") {
+			if name == "" {
+				name = fmt.Sprintf("L_%04X", address)
+			}
+			names[l] = name
+		}
+		queue = append(queue, l)
+	}
+	for bank, address := range base {
+		if _, ok := mapped[bank]; !ok || int(address)+bankSize != 0x10000 {
+			continue
+		}
+		read := readIn(bank)
+		vector := func (address uint16) uint16 {
+			return uint16(read(address)) | uint16(read(address + 1))<<8
+		}
+		visit(bank, vector(0xFFFC), "reset")
+		visit(bank, vector(0xFFFA), "nmi")
+		visit(bank, vector(0xFFFE), "irq")
+	}
+	for len(queue) > 0 {
+		l := queue[0]
+		queue = queue[1:]
+		read := readIn(l.bank)
+		for address := l.address; ; {
+			bank, ok := resolve(l.bank, address)
+			if !ok {
+				break
+			}
+			offset := bank*bankSize + int(address-base[bank])
+			opcode := prg[offset]
+			instruction := cpu6502.Instructions[opcode]
+			size := int(instruction.Size)
+			if starts[offset] || !official[opcode] || offset+size > (bank+1)*bankSize {
+				break
+			}
+			starts[offset] = true
+			operand := uint16(read(address + 1)) | uint16(read(address + 2))<<8
+			switch {
+			case instruction.Mode == cpu6502.ModeRelative:
+				visit(bank, address + 2 + uint16(int8(operand)), "")
+			case instruction.Name == "JSR":
+				visit(bank, operand, fmt.Sprintf("sub_%04X", operand))
+			case instruction.Name == "JMP" && instruction.Mode == cpu6502.ModeAbsolute:
+				visit(bank, operand, "")
+			}
+			switch instruction.Name {
+			case "JMP", "RTS", "RTI", "BRK":
+				size = 0
+			}
+			if size == 0 {
+				break
+			}
+			address += uint16(size)
+		}
+	}
+
+	for bank := 0; bank < banks; bank++ {
+		// names in this bank and the banks that stay put: generated ones,
+		// then symbols and labels, which take precedence
+		visible := func (other int) bool {
+			_, ok := mapped[other]
+			return other == bank || ok &&
+				(int(base[other]) >= int(base[bank])+bankSize || int(base[other])+bankSize <= int(base[bank]))
+		}
+		bankLabels := map[uint16]string{}
+		for l, name := range names {
+			if visible(l.bank) {
+				bankLabels[l.address] = name
+			}
+		}
+		if symbols := console.Symbols; symbols != nil {
+			for _, l := range symbols.Labels {
+				if l.Offset < 0 {
+					bankLabels[l.Address] = l.Name
+				} else if other := l.Offset / bankSize; other < banks && visible(other) {
+					bankLabels[base[other] + uint16(l.Offset - other*bankSize)] = l.Name
+				}
+			}
+		}
+		for address, name := range labels {
+			bankLabels[address] = name
+		}
+		label := func (address uint16) (string, bool) {
+			name, ok := bankLabels[address]
+			return name, ok
+		}
+		read := readIn(bank)
+
+		if bank > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "; PRG bank %d of %d ($%04X bytes at $%04X)\n", bank, banks, bankSize, base[bank])
+		start := bank * bankSize
+		var data []string
+		flush := func (address uint16) {
+			if len(data) > 0 {
+				fmt.Fprintf(w, "%04X  .byte %s\n", address - uint16(len(data)), strings.Join(data, ","))
+				data = nil
+			}
+		}
+		for offset := start; offset < start+bankSize; {
+			address := base[bank] + uint16(offset-start)
+			name, ok := names[location{bank, address}]
+			ok = ok && starts[offset]
+			var source *SourceLine
+			if symbols := console.Symbols; symbols != nil {
+				if l, found := labelAt(symbols, offset, address); found {
+					name, ok = l.Name, true
+				}
+				source, _ = sourceStart(symbols, offset, address)
+			}
+			if ok {
+				flush(address)
+				fmt.Fprintf(w, "%s:\n", name)
+			}
+			switch {
+			case starts[offset]:
+				flush(address)
+				text, size := disassemble(read, address, label)
+				line := formatInstruction(read, address, size, text)
+				if source != nil {
+					line = comment(line, []string{fmt.Sprintf("%s:%d", source.File, source.Line)})
+				}
+				fmt.Fprintln(w, line)
+				offset += size
+			case address == 0xFFFA && offset+6 <= start+bankSize:
+				flush(address)
+				var vectors []string
+				for i := uint16(0); i < 6; i += 2 {
+					vector := uint16(read(address + i)) | uint16(read(address + i + 1))<<8
+					if name, ok := bankLabels[vector]; ok {
+						vectors = append(vectors, name)
+					} else {
+						vectors = append(vectors, fmt.Sprintf("$%04X", vector))
+					}
+				}
+				fmt.Fprintf(w, "%04X  .word %s\n", address, strings.Join(vectors, ","))
+				offset += 6
+			default:
+				data = append(data, fmt.Sprintf("$%02X", prg[offset]))
+				offset++
+				if len(data) == 8 {
+					flush(address + 1)
+				}
+			}
+		}
+		flush(base[bank] + uint16(bankSize))
+	}
+}
+
+// official says which opcodes are documented instructions; the rest (and
+// the undocumented duplicates of NOP and SBC) are taken for data when
+// following code
+var official [256]bool
+
+func init() {
+	names := map[string]bool{}
+	for _, name := range strings.Fields(
+			"ADC AND ASL BCC BCS BEQ BIT BMI BNE BPL BRK BVC BVS CLC CLD CLI CLV CMP CPX CPY " +
+			"DEC DEX DEY EOR INC INX INY JMP JSR LDA LDX LDY LSR NOP ORA PHA PHP PLA PLP " +
+			"ROL ROR RTI RTS SBC SEC SED SEI STA STX STY TAX TAY TSX TXA TXS TYA") {
+		names[name] = true
+	}
+	for i, instruction := range cpu6502.Instructions {
+		official[i] = names[instruction.Name] && !(instruction.Name == "NOP" && i != 0xEA) && i != 0xEB
+	}
+}
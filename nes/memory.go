@@ -14,52 +14,179 @@ func readByte(console *Console, address uint16) byte {
 		}
 		return value
 	}
+	cpu := console.CPU
+	var value byte
 	switch {
 	case address < 0x2000:
-		return console.RAM[address%0x0800]
+		value = console.RAM[address%0x0800]
 	case address < 0x4000:
-		return readPPURegister(console, 0x2000 + address%8)
-	case address == 0x4014:
-		return readPPURegister(console, address)
+		value = readPPURegister(console, 0x2000 + address%8)
 	case address == 0x4015:
 		// apu read register
 		apu := console.APU
-		switch address {
-		case 0x4015:
-			var readStatus byte
-			if apu.pulse1.lengthValue > 0 {
-				readStatus |= 1
-			}
-			if apu.pulse2.lengthValue > 0 {
-				readStatus |= 2
-			}
-			if apu.triangle.lengthValue > 0 {
-				readStatus |= 4
-			}
-			if apu.noise.lengthValue > 0 {
-				readStatus |= 8
-			}
-			if apu.dmc.currentLength > 0 {
-				readStatus |= 16
-			}
-			return readStatus
+		var readStatus byte
+		if apu.pulse1.lengthValue > 0 {
+			readStatus |= 1
+		}
+		if apu.pulse2.lengthValue > 0 {
+			readStatus |= 2
+		}
+		if apu.triangle.lengthValue > 0 {
+			readStatus |= 4
+		}
+		if apu.noise.lengthValue > 0 {
+			readStatus |= 8
 		}
-		return 0
+		if apu.dmc.currentLength > 0 {
+			readStatus |= 16
+		}
+		if apu.frameInterrupt {
+			readStatus |= 64
+		}
+		apu.frameInterrupt = false
+		// bit 5 isn't driven
+		value = readStatus | cpu.bus&0x20
 	case address == 0x4016:
-		return readController(console.Controller1)
+		// only the low bits are driven by the controller port
+		value = readController(console.Controller1) | cpu.bus&0xE0
 	case address == 0x4017:
-		return readController(console.Controller2)
+		value = readController(console.Controller2) | cpu.bus&0xE0
 	case address < 0x6000:
+		// open bus: nothing drives the data bus, so it still holds the
+		// last value read or written, usually the high byte of the
+		// instruction's operand. This includes the write-only APU
+		// registers and $4014.
 		// TODO: I/O registers
+		value = cpu.bus
 	case address >= 0x6000:
-		return readMapper(console.Mapper, console.Cartridge, address)
+		value = readMapper(console.Mapper, console.Cartridge, address)
 	default:
 		log.Fatalf("unhandled cpu memory read at address: 0x%04X", address)
 	}
-	return 0
+	if address != 0x4015 {
+		// $4015 is read inside the CPU, so the data bus keeps its value
+		cpu.bus = value
+	}
+	if console.Debugger != nil {
+		debugAccess(console, false, BreakRead, address, value)
+	}
+	return value
+}
+
+// runDMA runs the DMA unit, which halts the CPU at the start of a read
+// cycle (it can't on a write) to fetch a DMC sample byte or copy a page to
+// OAM. It alternates get (read) and put (write) cycles in step with the
+// APU, so a transfer waits a cycle when it starts out of step. While the
+// CPU is halted it keeps repeating the read it was about to make, and
+// those extra reads have side effects: an extra $2007 increment, or a
+// controller bit lost to an extra shift.
+func runDMA(console *Console, address uint16) {
+	cpu := console.CPU
+	// the CPU only counts the cycles it runs, so count the ones it's
+	// halted for here
+	halted := func () {
+		tick(console)
+		cpu.Cycles++
+	}
+	dummyRead := func () {
+		// consecutive reads of the controller ports only clock the
+		// controller once, as /OE stays asserted between them
+		if address != 0x4016 && address != 0x4017 {
+			readByte(console, address)
+		}
+		halted()
+	}
+
+	// halt cycle
+	readByte(console, address)
+	halted()
+
+	// the DMC needs a dummy cycle after the halt before its get cycle;
+	// joining an OAM DMA in progress, it takes one of the OAM DMA's cycles
+	dmcReady := false
+	var oamCount int
+	var oamValue byte
+	for cpu.dmcDMA || cpu.oamDMA {
+		get := cpu.Cycles%2 == 0
+		switch {
+		case get && cpu.dmcDMA && dmcReady:
+			d := &console.APU.dmc
+			if console.CDL != nil {
+				logPRG(console, d.currentAddress, CDLPCM)
+			}
+			dmcFill(d, readByte(console, d.currentAddress))
+			halted()
+			cpu.dmcDMA = false
+			dmcReady = false
+		case get && cpu.oamDMA && oamCount%2 == 0:
+			oamValue = readByte(console, uint16(cpu.oamPage)<<8 | uint16(oamCount/2))
+			halted()
+			oamCount++
+		case !get && cpu.oamDMA && oamCount%2 == 1:
+			writeByte(console, 0x2004, oamValue)
+			halted()
+			oamCount++
+			if oamCount == 512 {
+				cpu.oamDMA = false
+			}
+		default:
+			// waiting for the DMC's dummy cycle or a cycle to align on
+			dummyRead()
+			if cpu.dmcDMA {
+				dmcReady = true
+			}
+		}
+		if cpu.dmcDMA && !dmcReady && oamCount > 0 {
+			dmcReady = true
+		}
+	}
+}
+
+// Read is a CPU read cycle on the NES's bus (see cpu6502.Bus). The DMA
+// unit can halt the CPU before it; then the interrupt lines are sampled
+// and the rest of the console runs for the cycle.
+func (console *Console) Read(address uint16) byte {
+	cpu := console.CPU
+	if cpu.dmcDMA || cpu.oamDMA {
+		runDMA(console, address)
+	}
+	sampleInterrupts(console)
+	value := readByte(console, address)
+	tick(console)
+	return value
+}
+
+// Write is a CPU write cycle, see Read
+func (console *Console) Write(address uint16, value byte) {
+	sampleInterrupts(console)
+	writeByte(console, address, value)
+	tick(console)
+}
+
+// NMI and IRQ report the interrupt lines as they were at the start of
+// the cycle, before any register access in it could change them
+func (console *Console) NMI() bool {
+	return console.CPU.nmi
+}
+
+func (console *Console) IRQ() bool {
+	return console.CPU.irq
+}
+
+func sampleInterrupts(console *Console) {
+	cpu := console.CPU
+	cpu.nmi = console.PPU.nmiLine
+	cpu.irq = console.APU.frameInterrupt
+	if m, ok := console.Mapper.(*Mapper4); ok && m.irqPending {
+		cpu.irq = true
+	}
 }
 
 func writeByte(console *Console, address uint16, value byte) {
+	if console.Debugger != nil {
+		debugAccess(console, false, BreakWrite, address, value)
+	}
+	console.CPU.bus = value
 	writeController := func (c *Controller, value byte) {
 		c.strobe = value
 		if c.strobe&1 == 1 {
@@ -68,6 +195,12 @@ func writeByte(console *Console, address uint16, value byte) {
 	}
 
 	writeRegisterAPU :=  func (apu *APU, address uint16, value byte) {
+		if console.VGM != nil {
+			logVGMWrite(console, address, value)
+		}
+		apu.registers[address-0x4000] = value
+		apu.written |= 1 << (address - 0x4000)
+
 		pulseWriteControl := func (p *Pulse, value byte) {
 			p.dutyMode = (value >> 6) & 3
 			p.lengthEnabled = (value>>5)&1 == 0
@@ -185,12 +318,23 @@ func writeByte(console *Console, address uint16, value byte) {
 			// apu write frame counter
 			apu.framePeriod = 4 + (value>>7)&1
 			apu.frameIRQ = (value>>6)&1 == 0
+			if !apu.frameIRQ {
+				apu.frameInterrupt = false
+			}
 		}
 	}
 
 	writeRegisterPPU := func (console *Console, address uint16, value byte) {
 		ppu := console.PPU
-		ppu.register = value
+		if address < 0x4000 {
+			setPPUBus(ppu, value, 0xFF)
+		}
+		if ppu.warmingUp {
+			switch address {
+			case 0x2000, 0x2001, 0x2005, 0x2006:
+				return
+			}
+		}
 		switch address {
 		case 0x2000:
 			writeControlPPU(ppu, value)
@@ -200,8 +344,13 @@ func writeByte(console *Console, address uint16, value byte) {
 			ppu.oamAddress = value
 		case 0x2004:
 			// write OAM data
-			ppu.oamData[ppu.oamAddress] = value
-			ppu.oamAddress++
+			if renderingOAM(ppu) {
+				// the write is lost but bumps the sprite part of OAMADDR
+				ppu.oamAddress += 4
+			} else {
+				ppu.oamData[ppu.oamAddress] = value
+				ppu.oamAddress++
+			}
 		case 0x2005:
 			// write scroll
 			if ppu.w == 0 {
@@ -243,18 +392,10 @@ func writeByte(console *Console, address uint16, value byte) {
 				ppu.v += 32
 			}
 		case 0x4014:
-			// write DMA
+			// write DMA; the copy starts on the CPU's next read, see runDMA
 			cpu := console.CPU
-			address := uint16(value) << 8
-			for i := 0; i < 256; i++ {
-				ppu.oamData[ppu.oamAddress] = readByte(console, address)
-				ppu.oamAddress++
-				address++
-			}
-			cpu.stall += 513
-			if cpu.Cycles%2 == 1 {
-				cpu.stall++
-			}
+			cpu.oamPage = value
+			cpu.oamDMA = true
 		}
 	}
 
@@ -284,6 +425,25 @@ func writeByte(console *Console, address uint16, value byte) {
 }
 
 func readPPU(console *Console, address uint16) byte {
+	address = address % 0x4000
+	value := PeekPPU(console, address)
+	if console.Debugger != nil {
+		debugAccess(console, true, BreakRead, address, value)
+	}
+	return value
+}
+
+// fetchPattern reads pattern table data for rendering
+func fetchPattern(console *Console, address uint16) byte {
+	if console.CDL != nil {
+		logCHR(console, address, CDLRendered)
+	}
+	return readPPU(console, address)
+}
+
+// PeekPPU reads PPU memory (pattern tables, name tables and palette) for
+// a debugger, without triggering its watchpoints
+func PeekPPU(console *Console, address uint16) byte {
 	address = address % 0x4000
 	switch {
 	case address < 0x2000:
@@ -299,8 +459,27 @@ func readPPU(console *Console, address uint16) byte {
 	return 0
 }
 
+// Peek reads CPU memory for a debugger, without the side effects of a
+// read by the CPU (acknowledging vblank, clocking the controllers...) and
+// without triggering watchpoints. I/O registers show the open bus value.
+func Peek(console *Console, address uint16) byte {
+	switch {
+	case address < 0x2000:
+		return console.RAM[address%0x0800]
+	case address < 0x4000:
+		return console.PPU.register
+	case address < 0x6000:
+		return console.CPU.bus
+	default:
+		return readMapper(console.Mapper, console.Cartridge, address)
+	}
+}
+
 func writePPU(console *Console, address uint16, value byte) {
 	address = address % 0x4000
+	if console.Debugger != nil {
+		debugAccess(console, true, BreakWrite, address, value)
+	}
 	switch {
 	case address < 0x2000:
 		writeMapper(console.Mapper, console.Cartridge, address, value)
@@ -404,6 +583,53 @@ func readMapper(mapper Mapper, cartridge *Cartridge, address uint16) byte {
 	return 0  // unreachable
 }
 
+// mapperOffset finds where the mapper's banks currently put an address:
+// for CPU addresses from $8000 up, the index into PRG ROM, and for PPU
+// addresses below $2000, the index into CHR. Anything else gets -1.
+func mapperOffset(mapper Mapper, address uint16) int {
+	if address >= 0x2000 && address < 0x8000 {
+		return -1
+	}
+	switch m := mapper.(type) {
+	case *Mapper1:
+		if address < 0x2000 {
+			return m.chrOffsets[address/0x1000] + int(address%0x1000)
+		}
+		address -= 0x8000
+		return m.prgOffsets[address/0x4000] + int(address%0x4000)
+	case *Mapper2:
+		switch {
+		case address < 0x2000:
+			return int(address)
+		case address >= 0xC000:
+			return m.prgBank2*0x4000 + int(address-0xC000)
+		default:
+			return m.prgBank1*0x4000 + int(address-0x8000)
+		}
+	case *Mapper3:
+		switch {
+		case address < 0x2000:
+			return m.chrBank*0x2000 + int(address)
+		case address >= 0xC000:
+			return m.prgBank2*0x4000 + int(address-0xC000)
+		default:
+			return m.prgBank1*0x4000 + int(address-0x8000)
+		}
+	case *Mapper4:
+		if address < 0x2000 {
+			return m.chrOffsets[address/0x0400] + int(address%0x0400)
+		}
+		address -= 0x8000
+		return m.prgOffsets[address/0x2000] + int(address%0x2000)
+	case *Mapper7:
+		if address < 0x2000 {
+			return int(address)
+		}
+		return m.prgBank*0x8000 + int(address-0x8000)
+	}
+	return -1
+}
+
 
 
 func writeMapper(mapper Mapper, cartridge *Cartridge, address uint16, value byte) {
@@ -608,8 +834,9 @@ func writeMapper(mapper Mapper, cartridge *Cartridge, address uint16, value byte
 				// write IRQ reload
 				m.counter = 0
 			case address <= 0xFFFF && address%2 == 0:
-				// write IRQ disable
+				// write IRQ disable, which also acknowledges the IRQ
 				m.irqEnable = false
+				m.irqPending = false
 			case address <= 0xFFFF && address%2 == 1:
 				// write IRQ enable
 				m.irqEnable = true
@@ -688,29 +915,58 @@ func readPPURegister(console *Console, address uint16) byte {
 	switch address {
 	case 0x2002: // PPUSTATUS
 		// read status
-		status := ppu.register & 0x1F
-		status |= ppu.flagSpriteOverflow << 5
+		status := ppu.flagSpriteOverflow << 5
 		status |= ppu.flagSpriteZeroHit << 6
 		if ppu.nmiOccurred {
 			status |= 1 << 7
 		}
+		// the read happens during the dot after the one the PPU has reached
+		if ppu.ScanLine == 240 && ppu.Cycle == 340 {
+			// a read just before vblank starts sees it clear, and keeps it
+			// from being set this frame
+			ppu.suppressVBlank = true
+		} else if ppu.ScanLine == 241 && ppu.Cycle <= 1 {
+			// a read as it starts sees it set, but the NMI is lost
+			status |= 1 << 7
+			if ppu.Cycle == 0 {
+				ppu.suppressVBlank = true
+			}
+			ppu.nmiEdge = false
+		}
 		ppu.nmiOccurred = false
 		nmiChangePPU(ppu)
 		ppu.w = 0
-		return status
+		// the low 5 bits are open bus
+		return setPPUBus(ppu, status, 0xE0)
 	case 0x2004:
 		// OAM = Object Attribute Memory
-		return ppu.oamData[ppu.oamAddress]
+		value := ppu.oamData[ppu.oamAddress]
+		if renderingOAM(ppu) {
+			value = ppu.oamLatch
+		} else if ppu.oamAddress&3 == 2 {
+			// bits 2-4 of sprite attributes don't exist
+			value &= 0xE3
+		}
+		return setPPUBus(ppu, value, 0xFF)
 	case 0x2007:
 		// read data
+		if console.CDL != nil {
+			logCHR(console, ppu.v, CDLRead)
+		}
 		value := readPPU(console, ppu.v)
 		// emulate buffered reads
+		mask := byte(0xFF)
 		if ppu.v%0x4000 < 0x3F00 {
 			buffered := ppu.bufferedData
 			ppu.bufferedData = value
 			value = buffered
 		} else {
 			ppu.bufferedData = readPPU(console, ppu.v - 0x1000)
+			if ppu.flagGrayscale != 0 {
+				value &= 0x30
+			}
+			// palette entries are 6 bits; the top 2 are open bus
+			mask = 0x3F
 		}
 		// increment address
 		if ppu.flagIncrement == 0 {
@@ -718,9 +974,41 @@ func readPPURegister(console *Console, address uint16) byte {
 		} else {
 			ppu.v += 32
 		}
-		return value
+		return setPPUBus(ppu, value, mask)
 	}
-	return 0
+	// write-only registers read back the I/O latch
+	return ppuOpenBus(ppu)
+}
+
+// ppuOpenBus returns the PPU's I/O latch, which holds the last value
+// written to or read from a register. Like any floating bus it leaks: a bit
+// that isn't driven for about 600ms fades to 0.
+func ppuOpenBus(ppu *PPU) byte {
+	for bit := uint(0); bit < 8; bit++ {
+		if ppu.Frame-ppu.registerRefreshed[bit] > ppuBusDecayFrames {
+			ppu.register &^= 1 << bit
+		}
+	}
+	return ppu.register
+}
+
+// setPPUBus drives the bits of value selected by mask onto the I/O latch,
+// leaving the others to decay, and returns the latch
+func setPPUBus(ppu *PPU, value, mask byte) byte {
+	ppu.register = ppuOpenBus(ppu)&^mask | value&mask
+	for bit := uint(0); bit < 8; bit++ {
+		if mask&(1<<bit) != 0 {
+			ppu.registerRefreshed[bit] = ppu.Frame
+		}
+	}
+	return ppu.register
+}
+
+// renderingOAM reports whether sprite evaluation and fetching own OAM, in
+// which case $2004 sees the OAM bus rather than OAM[OAMADDR]
+func renderingOAM(ppu *PPU) bool {
+	renderingEnabled := ppu.flagShowBackground != 0 || ppu.flagShowSprites != 0
+	return renderingEnabled && (ppu.ScanLine < 240 || ppu.ScanLine == 261)
 }
 
 // $2000: PPUCTRL
@@ -1,8 +1,12 @@
 package nes
 
 import (
+    "bufio"
     "image/color"
     "image"
+    "os"
+
+    "github.com/BrianWill/nes/cpu6502"
 )
 
 type APU struct {
@@ -16,6 +20,23 @@ type APU struct {
     framePeriod byte
     frameValue  byte
     frameIRQ    bool
+    frameInterrupt bool // the frame counter's IRQ flag, held until $4015 is read
+    registers   [0x18]byte // last value written to each of $4000-$4017
+    written     uint32     // bit n is set once $4000+n has been written
+    scope       [5][scopeLength]byte // recent output of each channel (ring buffers)
+    scopeIndex  int                  // next write position in scope
+}
+
+// snapshot of one APU channel for visualization
+type ChannelState struct {
+    Name      string
+    Samples   []byte  // recent output, oldest first (0-15; DMC is 0-127)
+    Max       byte    // largest possible sample value
+    Enabled   bool
+    Period    uint16  // timer period
+    Frequency float64 // in Hz; 0 if the channel has no pitch
+    Volume    byte
+    Duty      byte    // duty mode (pulse only)
 }
 
 // Delta Modulation Channel
@@ -94,6 +115,7 @@ type Cartridge struct {
     Mapper byte   // mapper type
     Mirror byte   // mirroring mode
     Battery byte   // battery present
+    CHRRAM bool    // CHR is RAM, the file having no CHR-ROM
 }
 
 type Console struct {
@@ -105,6 +127,117 @@ type Console struct {
     Controller2 *Controller
     Mapper Mapper
     RAM []byte
+    VGM *VGMLogger // nil when not logging
+    Debugger *Debugger // nil when not debugging
+    Tracer *Tracer // nil when not tracing
+    CDL *CDLogger // nil when not logging code and data
+    Symbols *Symbols // nil when no debug info is loaded
+    initialRAM int // RAM contents at power on, see SetInitialRAM
+}
+
+// records APU register writes for export as a .vgm file
+type VGMLogger struct {
+    data       []byte            // command stream (everything after the header)
+    startCycle uint64            // cpu cycle at which logging started
+    samples    uint64            // 44.1kHz samples accounted for by waits so far
+    dmcSamples map[uint16][]byte // DMC sample data already sent, keyed by address
+}
+
+// breakpoints and watchpoints, and the state of a debugging run; see
+// debugger.go
+type Debugger struct {
+    Breakpoints []*Breakpoint
+    nextID      int
+    stop        *Stop // set by a watchpoint hit during the current instruction
+    paused      int32 // set by Pause, from any goroutine
+    executed    int   // opcode of the last instruction run, or -1 for an interrupt
+}
+
+// logs instructions as they execute; see trace.go
+type Tracer struct {
+    // logging starts when the PC reaches StartPC (-1 for right away) in
+    // frame FrameStart or later, and stops for good after the PC reaches
+    // StopPC (-1 for never) or frame FrameEnd ends
+    StartPC, StopPC      int
+    FrameStart, FrameEnd uint64
+    PCStart, PCEnd       uint16 // in between, log only instructions at these addresses (inclusive)
+    Symbols              bool   // show labels from the console's debug info, departing from nestest.log's format
+    started, done bool
+    file *os.File // the file StartTrace opened, or nil
+    w    *bufio.Writer
+    err  error // the first error writing the log
+}
+
+// records how each byte of ROM is used, for export as an FCEUX .cdl file;
+// see cdl.go
+type CDLogger struct {
+    PRG []byte // CDLCode, CDLData... for each byte of PRG ROM
+    CHR []byte // CDLRendered and CDLRead for each byte of CHR ROM; empty for CHR RAM
+}
+
+// names and source lines for addresses, from an assembler's debug info;
+// see symbols.go
+type Symbols struct {
+    Labels []Label
+    Lines  []SourceLine
+    labels map[symbolKey]*Label
+    lines  map[symbolKey]*SourceLine // every byte each line assembled to
+}
+
+// where a symbol is: a byte of PRG ROM, whatever bank it's in, or (for
+// RAM and for files that don't give banks) a CPU address
+type symbolKey struct {
+    offset  int // index into PRG ROM, or -1
+    address uint16
+}
+
+type Label struct {
+    Name    string
+    Address uint16 // where the assembler put it
+    Offset  int    // its index into PRG ROM, or -1 if it's not in ROM or the bank isn't known
+}
+
+type SourceLine struct {
+    File    string
+    Line    int // from 1
+    Address uint16
+    Offset  int // as in Label
+    Size    int // bytes of code or data it assembled to
+}
+
+// a breakpoint or watchpoint on a range of CPU or PPU addresses
+type Breakpoint struct {
+    ID        int
+    Kind      byte   // any of BreakExecute, BreakRead and BreakWrite
+    PPU       bool   // watch the PPU's address space rather than the CPU's
+    Start     uint16
+    End       uint16 // inclusive
+    Condition string // e.g. "a == $10 && [$0300] > 3"; "" to always break
+    Enabled   bool
+    Hits      int
+    condition func (console *Console, address uint16, value byte) int
+}
+
+// the PPU's registers as a debugger shows them; see InspectPPU
+type PPUState struct {
+    ScanLine, Cycle int
+    Frame           uint64
+    Control         byte // PPUCTRL
+    Mask            byte // PPUMASK
+    Status          byte // PPUSTATUS's flags (its low bits are open bus)
+    OAMAddress      byte
+    V, T            uint16 // the current and temporary VRAM addresses
+    X               byte   // fine X scroll
+    W               byte   // the write toggle shared by PPUSCROLL and PPUADDR
+}
+
+// why a debugging run stopped
+type Stop struct {
+    Reason     int         // StopDone, StopBreakpoint, StopPaused or StopLimit
+    Breakpoint *Breakpoint // the breakpoint hit, for StopBreakpoint
+    Kind       byte        // the access that hit it
+    Address    uint16
+    Value      byte
 }
 
 type Controller struct {
@@ -113,23 +246,15 @@ type Controller struct {
     strobe byte
 }
 
+// the 6502 core plus the NES's DMA unit and data bus
 type CPU struct {
-    Cycles uint64 // number of cycles
-    PC uint16 // program counter
-    SP byte   // stack pointer
-    A byte   // accumulator
-    X byte   // x register
-    Y byte   // y register
-    C byte   // carry flag
-    Z byte   // zero flag
-    I byte   // interrupt disable flag
-    D byte   // decimal mode flag
-    B byte   // break command flag
-    U byte   // unused flag
-    V byte   // overflow flag
-    N byte   // negative flag
-    interrupt byte   // interrupt type to perform
-    stall int    // number of cycles to stall
+    cpu6502.CPU
+    oamDMA bool  // an OAM DMA is waiting to start or in progress
+    oamPage byte // page the OAM DMA copies from
+    dmcDMA bool  // the DMC is waiting for a sample byte
+    bus byte     // last value on the data bus, which open bus reads return
+    nmi bool     // the interrupt lines as the current cycle started
+    irq bool
 }
 
 type PPU struct {
@@ -143,6 +268,14 @@ type PPU struct {
     oamData       [256]byte   // Object Attribute Memory
     front         *image.RGBA
     back          *image.RGBA
+    palette       [512]color.RGBA // indexed by emphasis<<6 | color
+
+    // indexed output: pixels are emphasis<<6 | color rather than RGBA,
+    // and front is converted from frontIndex only when it's asked for
+    indexed    bool
+    frontIndex []uint16
+    backIndex  []uint16
+    frontStale bool // front hasn't been converted from frontIndex yet
 
     // PPU registers
     v uint16 // current vram address (15 bit)
@@ -151,13 +284,17 @@ type PPU struct {
     w byte   // write toggle (1 bit)
     f byte   // even/odd frame flag (1 bit)
 
-    register byte
+    register          byte      // I/O latch (open bus)
+    registerRefreshed [8]uint64 // frame each bit of register was last driven
 
     // NMI flags
     nmiOccurred bool
     nmiOutput   bool
     nmiPrevious bool
-    nmiDelay    byte
+    nmiEdge     bool // NMI went active; passed on to the CPU on the next cycle
+    nmiLine     bool // the NMI line as the CPU sees it
+    suppressVBlank bool // $2002 was read just as vblank started
+    warmingUp      bool // after power on or reset, writes to $2000, $2001, $2005 and $2006 are ignored until the pre-render line
 
     // background temporary variables
     nameTableByte      byte
@@ -166,12 +303,23 @@ type PPU struct {
     highTileByte       byte
     tileData           uint64
 
-    // sprite temporary variables
+    // sprite temporary variables; only the first 8 are used unless
+    // unlimitedSprites is set
     spriteCount      int
-    spritePatterns   [8]uint32
-    spritePositions  [8]byte
-    spritePriorities [8]byte
-    spriteIndexes    [8]byte
+    spritePatterns   [64]uint32
+    spritePositions  [64]byte
+    spritePriorities [64]byte
+    spriteZero       bool // the first sprite is the one OAM evaluation started with
+    unlimitedSprites bool // draw every sprite on a line, not just the first 8
+
+    // sprite evaluation (cycles 1-256 of visible lines)
+    secondaryOAM   [32]byte
+    oamLatch       byte // value on the OAM bus, which $2004 reads during rendering
+    evalCount      int  // sprites copied to secondary OAM
+    evalCopy       int  // byte of the current sprite being copied
+    evalDone       bool
+    evalSpriteZero bool
+    evalNext       int  // first sprite not checked before secondary OAM filled
 
     // $2000 PPUCTRL
     flagNameTable       byte // 0: $2000; 1: $2400; 2: $2800; 3: $2C00
@@ -246,6 +394,7 @@ type Mapper4 struct {
     reload     byte
     counter    byte
     irqEnable  bool
+    irqPending bool // asserting IRQ until it's disabled
 }
 
 type Mapper7 struct {
@@ -262,23 +411,42 @@ type iNESFileHeader struct {
     _ [7]byte     // unused padding (necessary for properly reading ROM file)
 }
 
-type Instruction struct {
-    Opcode byte
-    Name string
-    Mode byte        // the addressing mode
-    Size byte        // the size in bytes
-    Cycles byte      // the number of cycles used (not including conditional cycles)
-    PageCycles byte  // the number of cycles used when a page is crossed
-}
-
 const iNESFileMagic = 0x1a53454e
 
+// names of the PPU, APU and I/O registers, which the disassembler uses
+// for operands
+var RegisterNames = map[uint16]string{
+    0x2000: "PPUCTRL", 0x2001: "PPUMASK", 0x2002: "PPUSTATUS", 0x2003: "OAMADDR",
+    0x2004: "OAMDATA", 0x2005: "PPUSCROLL", 0x2006: "PPUADDR", 0x2007: "PPUDATA",
+    0x4000: "SQ1_VOL", 0x4001: "SQ1_SWEEP", 0x4002: "SQ1_LO", 0x4003: "SQ1_HI",
+    0x4004: "SQ2_VOL", 0x4005: "SQ2_SWEEP", 0x4006: "SQ2_LO", 0x4007: "SQ2_HI",
+    0x4008: "TRI_LINEAR", 0x400A: "TRI_LO", 0x400B: "TRI_HI",
+    0x400C: "NOISE_VOL", 0x400E: "NOISE_LO", 0x400F: "NOISE_HI",
+    0x4010: "DMC_FREQ", 0x4011: "DMC_RAW", 0x4012: "DMC_START", 0x4013: "DMC_LEN",
+    0x4014: "OAMDMA", 0x4015: "SND_CHN", 0x4016: "JOY1", 0x4017: "JOY2",
+}
+
 var pulseTable [31]float32
 var tndTable [203]float32
 
 var Palette [64]color.RGBA
+var emphasisPalette [512]color.RGBA // Palette under each combination of emphasis bits
+
+// NTSC signal parameters for GeneratePalette
+type PaletteParams struct {
+    Hue        float64 // in degrees
+    Saturation float64
+    Contrast   float64
+    Brightness float64
+    Gamma      float64 // of the display being emulated; 2.2 leaves levels unchanged
+}
+
+// close to the built-in Palette
+var DefaultPaletteParams = PaletteParams{Hue: 0, Saturation: 1.4, Contrast: 1, Brightness: 0, Gamma: 2.2}
 
 const frameCounterRate = CPUFrequency / 240.0
+const scopeLength = 1024
+const ppuBusDecayFrames = 36 // about 600ms
 const sampleRate = CPUFrequency / 44100.0 / 2
 
 const (
@@ -319,291 +487,45 @@ var dmcTable = []byte{
 
 const CPUFrequency = 1789773
 
-// interrupt types
+// RAM contents at power on, which vary from console to console
 const (
-    _ = iota
-    interruptNone
-    interruptNMI
-    interruptIRQ
+    RAMZero = iota
+    RAMFill           // every byte $FF
+    RAMPattern        // 4 bytes of $00, then 4 of $FF, repeated
+    RAMRandom
 )
 
-// addressing modes
+// breakpoint kinds, which combine
 const (
-    _ = iota
-    modeAbsolute
-    modeAbsoluteX
-    modeAbsoluteY
-    modeAccumulator
-    modeImmediate
-    modeImplied
-    modeIndexedIndirect
-    modeIndirect
-    modeIndirectIndexed
-    modeRelative
-    modeZeroPage
-    modeZeroPageX
-    modeZeroPageY
+    BreakExecute = 1 << iota
+    BreakRead
+    BreakWrite
+)
+
+// reasons a debugging run stopped
+const (
+    StopDone = iota // the step or run-to finished
+    StopBreakpoint
+    StopPaused
+    StopLimit       // ran out of cycles
+)
+
+// Code/Data Logger flags, as FCEUX's .cdl files have them. For PRG ROM:
+const (
+    CDLCode         = 0x01 // executed
+    CDLData         = 0x02 // read as data
+    CDLBank         = 0x0C // which 8K of $8000-$FFFF it was last accessed at
+    CDLIndirectCode = 0x10 // jumped to through JMP ($xxxx)
+    CDLIndirectData = 0x20 // read through a pointer, e.g. LDA ($xx),Y
+    CDLPCM          = 0x40 // played as a DMC sample
+)
+
+// and for CHR ROM:
+const (
+    CDLRendered = 0x01 // fetched by the PPU to draw
+    CDLRead     = 0x02 // read by the CPU through $2007
 )
 
-var instructions = [256]Instruction{
-    // don't really need .Opcode but makes the list more readable
-    Instruction{Opcode: 0, Name: "BRK", Mode: 6, Size: 1, Cycles: 7, PageCycles: 0},
-    Instruction{Opcode: 1, Name: "ORA", Mode: 7, Size: 2, Cycles: 6, PageCycles: 0},
-    Instruction{Opcode: 2, Name: "KIL", Mode: 6, Size: 0, Cycles: 2, PageCycles: 0},
-    Instruction{Opcode: 3, Name: "SLO", Mode: 7, Size: 0, Cycles: 8, PageCycles: 0},
-    Instruction{Opcode: 4, Name: "NOP", Mode: 11, Size: 2, Cycles: 3, PageCycles: 0},
-    Instruction{Opcode: 5, Name: "ORA", Mode: 11, Size: 2, Cycles: 3, PageCycles: 0},
-    Instruction{Opcode: 6, Name: "ASL", Mode: 11, Size: 2, Cycles: 5, PageCycles: 0},
-    Instruction{Opcode: 7, Name: "SLO", Mode: 11, Size: 0, Cycles: 5, PageCycles: 0},
-    Instruction{Opcode: 8, Name: "PHP", Mode: 6, Size: 1, Cycles: 3, PageCycles: 0},
-    Instruction{Opcode: 9, Name: "ORA", Mode: 5, Size: 2, Cycles: 2, PageCycles: 0},
-    Instruction{Opcode: 10, Name: "ASL", Mode: 4, Size: 1, Cycles: 2, PageCycles: 0},
-    Instruction{Opcode: 11, Name: "ANC", Mode: 5, Size: 0, Cycles: 2, PageCycles: 0},
-    Instruction{Opcode: 12, Name: "NOP", Mode: 1, Size: 3, Cycles: 4, PageCycles: 0},
-    Instruction{Opcode: 13, Name: "ORA", Mode: 1, Size: 3, Cycles: 4, PageCycles: 0},
-    Instruction{Opcode: 14, Name: "ASL", Mode: 1, Size: 3, Cycles: 6, PageCycles: 0},
-    Instruction{Opcode: 15, Name: "SLO", Mode: 1, Size: 0, Cycles: 6, PageCycles: 0},
-    Instruction{Opcode: 16, Name: "BPL", Mode: 10, Size: 2, Cycles: 2, PageCycles: 1},
-    Instruction{Opcode: 17, Name: "ORA", Mode: 9, Size: 2, Cycles: 5, PageCycles: 1},
-    Instruction{Opcode: 18, Name: "KIL", Mode: 6, Size: 0, Cycles: 2, PageCycles: 0},
-    Instruction{Opcode: 19, Name: "SLO", Mode: 9, Size: 0, Cycles: 8, PageCycles: 0},
-    Instruction{Opcode: 20, Name: "NOP", Mode: 12, Size: 2, Cycles: 4, PageCycles: 0},
-    Instruction{Opcode: 21, Name: "ORA", Mode: 12, Size: 2, Cycles: 4, PageCycles: 0},
-    Instruction{Opcode: 22, Name: "ASL", Mode: 12, Size: 2, Cycles: 6, PageCycles: 0},
-    Instruction{Opcode: 23, Name: "SLO", Mode: 12, Size: 0, Cycles: 6, PageCycles: 0},
-    Instruction{Opcode: 24, Name: "CLC", Mode: 6, Size: 1, Cycles: 2, PageCycles: 0},
-    Instruction{Opcode: 25, Name: "ORA", Mode: 3, Size: 3, Cycles: 4, PageCycles: 1},
-    Instruction{Opcode: 26, Name: "NOP", Mode: 6, Size: 1, Cycles: 2, PageCycles: 0},
-    Instruction{Opcode: 27, Name: "SLO", Mode: 3, Size: 0, Cycles: 7, PageCycles: 0},
-    Instruction{Opcode: 28, Name: "NOP", Mode: 2, Size: 3, Cycles: 4, PageCycles: 1},
-    Instruction{Opcode: 29, Name: "ORA", Mode: 2, Size: 3, Cycles: 4, PageCycles: 1},
-    Instruction{Opcode: 30, Name: "ASL", Mode: 2, Size: 3, Cycles: 7, PageCycles: 0},
-    Instruction{Opcode: 31, Name: "SLO", Mode: 2, Size: 0, Cycles: 7, PageCycles: 0},
-    Instruction{Opcode: 32, Name: "JSR", Mode: 1, Size: 3, Cycles: 6, PageCycles: 0},
-    Instruction{Opcode: 33, Name: "AND", Mode: 7, Size: 2, Cycles: 6, PageCycles: 0},
-    Instruction{Opcode: 34, Name: "KIL", Mode: 6, Size: 0, Cycles: 2, PageCycles: 0},
-    Instruction{Opcode: 35, Name: "RLA", Mode: 7, Size: 0, Cycles: 8, PageCycles: 0},
-    Instruction{Opcode: 36, Name: "BIT", Mode: 11, Size: 2, Cycles: 3, PageCycles: 0},
-    Instruction{Opcode: 37, Name: "AND", Mode: 11, Size: 2, Cycles: 3, PageCycles: 0},
-    Instruction{Opcode: 38, Name: "ROL", Mode: 11, Size: 2, Cycles: 5, PageCycles: 0},
-    Instruction{Opcode: 39, Name: "RLA", Mode: 11, Size: 0, Cycles: 5, PageCycles: 0},
-    Instruction{Opcode: 40, Name: "PLP", Mode: 6, Size: 1, Cycles: 4, PageCycles: 0},
-    Instruction{Opcode: 41, Name: "AND", Mode: 5, Size: 2, Cycles: 2, PageCycles: 0},
-    Instruction{Opcode: 42, Name: "ROL", Mode: 4, Size: 1, Cycles: 2, PageCycles: 0},
-    Instruction{Opcode: 43, Name: "ANC", Mode: 5, Size: 0, Cycles: 2, PageCycles: 0},
-    Instruction{Opcode: 44, Name: "BIT", Mode: 1, Size: 3, Cycles: 4, PageCycles: 0},
-    Instruction{Opcode: 45, Name: "AND", Mode: 1, Size: 3, Cycles: 4, PageCycles: 0},
-    Instruction{Opcode: 46, Name: "ROL", Mode: 1, Size: 3, Cycles: 6, PageCycles: 0},
-    Instruction{Opcode: 47, Name: "RLA", Mode: 1, Size: 0, Cycles: 6, PageCycles: 0},
-    Instruction{Opcode: 48, Name: "BMI", Mode: 10, Size: 2, Cycles: 2, PageCycles: 1},
-    Instruction{Opcode: 49, Name: "AND", Mode: 9, Size: 2, Cycles: 5, PageCycles: 1},
-    Instruction{Opcode: 50, Name: "KIL", Mode: 6, Size: 0, Cycles: 2, PageCycles: 0},
-    Instruction{Opcode: 51, Name: "RLA", Mode: 9, Size: 0, Cycles: 8, PageCycles: 0},
-    Instruction{Opcode: 52, Name: "NOP", Mode: 12, Size: 2, Cycles: 4, PageCycles: 0},
-    Instruction{Opcode: 53, Name: "AND", Mode: 12, Size: 2, Cycles: 4, PageCycles: 0},
-    Instruction{Opcode: 54, Name: "ROL", Mode: 12, Size: 2, Cycles: 6, PageCycles: 0},
-    Instruction{Opcode: 55, Name: "RLA", Mode: 12, Size: 0, Cycles: 6, PageCycles: 0},
-    Instruction{Opcode: 56, Name: "SEC", Mode: 6, Size: 1, Cycles: 2, PageCycles: 0},
-    Instruction{Opcode: 57, Name: "AND", Mode: 3, Size: 3, Cycles: 4, PageCycles: 1},
-    Instruction{Opcode: 58, Name: "NOP", Mode: 6, Size: 1, Cycles: 2, PageCycles: 0},
-    Instruction{Opcode: 59, Name: "RLA", Mode: 3, Size: 0, Cycles: 7, PageCycles: 0},
-    Instruction{Opcode: 60, Name: "NOP", Mode: 2, Size: 3, Cycles: 4, PageCycles: 1},
-    Instruction{Opcode: 61, Name: "AND", Mode: 2, Size: 3, Cycles: 4, PageCycles: 1},
-    Instruction{Opcode: 62, Name: "ROL", Mode: 2, Size: 3, Cycles: 7, PageCycles: 0},
-    Instruction{Opcode: 63, Name: "RLA", Mode: 2, Size: 0, Cycles: 7, PageCycles: 0},
-    Instruction{Opcode: 64, Name: "RTI", Mode: 6, Size: 1, Cycles: 6, PageCycles: 0},
-    Instruction{Opcode: 65, Name: "EOR", Mode: 7, Size: 2, Cycles: 6, PageCycles: 0},
-    Instruction{Opcode: 66, Name: "KIL", Mode: 6, Size: 0, Cycles: 2, PageCycles: 0},
-    Instruction{Opcode: 67, Name: "SRE", Mode: 7, Size: 0, Cycles: 8, PageCycles: 0},
-    Instruction{Opcode: 68, Name: "NOP", Mode: 11, Size: 2, Cycles: 3, PageCycles: 0},
-    Instruction{Opcode: 69, Name: "EOR", Mode: 11, Size: 2, Cycles: 3, PageCycles: 0},
-    Instruction{Opcode: 70, Name: "LSR", Mode: 11, Size: 2, Cycles: 5, PageCycles: 0},
-    Instruction{Opcode: 71, Name: "SRE", Mode: 11, Size: 0, Cycles: 5, PageCycles: 0},
-    Instruction{Opcode: 72, Name: "PHA", Mode: 6, Size: 1, Cycles: 3, PageCycles: 0},
-    Instruction{Opcode: 73, Name: "EOR", Mode: 5, Size: 2, Cycles: 2, PageCycles: 0},
-    Instruction{Opcode: 74, Name: "LSR", Mode: 4, Size: 1, Cycles: 2, PageCycles: 0},
-    Instruction{Opcode: 75, Name: "ALR", Mode: 5, Size: 0, Cycles: 2, PageCycles: 0},
-    Instruction{Opcode: 76, Name: "JMP", Mode: 1, Size: 3, Cycles: 3, PageCycles: 0},
-    Instruction{Opcode: 77, Name: "EOR", Mode: 1, Size: 3, Cycles: 4, PageCycles: 0},
-    Instruction{Opcode: 78, Name: "LSR", Mode: 1, Size: 3, Cycles: 6, PageCycles: 0},
-    Instruction{Opcode: 79, Name: "SRE", Mode: 1, Size: 0, Cycles: 6, PageCycles: 0},
-    Instruction{Opcode: 80, Name: "BVC", Mode: 10, Size: 2, Cycles: 2, PageCycles: 1},
-    Instruction{Opcode: 81, Name: "EOR", Mode: 9, Size: 2, Cycles: 5, PageCycles: 1},
-    Instruction{Opcode: 82, Name: "KIL", Mode: 6, Size: 0, Cycles: 2, PageCycles: 0},
-    Instruction{Opcode: 83, Name: "SRE", Mode: 9, Size: 0, Cycles: 8, PageCycles: 0},
-    Instruction{Opcode: 84, Name: "NOP", Mode: 12, Size: 2, Cycles: 4, PageCycles: 0},
-    Instruction{Opcode: 85, Name: "EOR", Mode: 12, Size: 2, Cycles: 4, PageCycles: 0},
-    Instruction{Opcode: 86, Name: "LSR", Mode: 12, Size: 2, Cycles: 6, PageCycles: 0},
-    Instruction{Opcode: 87, Name: "SRE", Mode: 12, Size: 0, Cycles: 6, PageCycles: 0},
-    Instruction{Opcode: 88, Name: "CLI", Mode: 6, Size: 1, Cycles: 2, PageCycles: 0},
-    Instruction{Opcode: 89, Name: "EOR", Mode: 3, Size: 3, Cycles: 4, PageCycles: 1},
-    Instruction{Opcode: 90, Name: "NOP", Mode: 6, Size: 1, Cycles: 2, PageCycles: 0},
-    Instruction{Opcode: 91, Name: "SRE", Mode: 3, Size: 0, Cycles: 7, PageCycles: 0},
-    Instruction{Opcode: 92, Name: "NOP", Mode: 2, Size: 3, Cycles: 4, PageCycles: 1},
-    Instruction{Opcode: 93, Name: "EOR", Mode: 2, Size: 3, Cycles: 4, PageCycles: 1},
-    Instruction{Opcode: 94, Name: "LSR", Mode: 2, Size: 3, Cycles: 7, PageCycles: 0},
-    Instruction{Opcode: 95, Name: "SRE", Mode: 2, Size: 0, Cycles: 7, PageCycles: 0},
-    Instruction{Opcode: 96, Name: "RTS", Mode: 6, Size: 1, Cycles: 6, PageCycles: 0},
-    Instruction{Opcode: 97, Name: "ADC", Mode: 7, Size: 2, Cycles: 6, PageCycles: 0},
-    Instruction{Opcode: 98, Name: "KIL", Mode: 6, Size: 0, Cycles: 2, PageCycles: 0},
-    Instruction{Opcode: 99, Name: "RRA", Mode: 7, Size: 0, Cycles: 8, PageCycles: 0},
-    Instruction{Opcode: 100, Name: "NOP", Mode: 11, Size: 2, Cycles: 3, PageCycles: 0},
-    Instruction{Opcode: 101, Name: "ADC", Mode: 11, Size: 2, Cycles: 3, PageCycles: 0},
-    Instruction{Opcode: 102, Name: "ROR", Mode: 11, Size: 2, Cycles: 5, PageCycles: 0},
-    Instruction{Opcode: 103, Name: "RRA", Mode: 11, Size: 0, Cycles: 5, PageCycles: 0},
-    Instruction{Opcode: 104, Name: "PLA", Mode: 6, Size: 1, Cycles: 4, PageCycles: 0},
-    Instruction{Opcode: 105, Name: "ADC", Mode: 5, Size: 2, Cycles: 2, PageCycles: 0},
-    Instruction{Opcode: 106, Name: "ROR", Mode: 4, Size: 1, Cycles: 2, PageCycles: 0},
-    Instruction{Opcode: 107, Name: "ARR", Mode: 5, Size: 0, Cycles: 2, PageCycles: 0},
-    Instruction{Opcode: 108, Name: "JMP", Mode: 8, Size: 3, Cycles: 5, PageCycles: 0},
-    Instruction{Opcode: 109, Name: "ADC", Mode: 1, Size: 3, Cycles: 4, PageCycles: 0},
-    Instruction{Opcode: 110, Name: "ROR", Mode: 1, Size: 3, Cycles: 6, PageCycles: 0},
-    Instruction{Opcode: 111, Name: "RRA", Mode: 1, Size: 0, Cycles: 6, PageCycles: 0},
-    Instruction{Opcode: 112, Name: "BVS", Mode: 10, Size: 2, Cycles: 2, PageCycles: 1},
-    Instruction{Opcode: 113, Name: "ADC", Mode: 9, Size: 2, Cycles: 5, PageCycles: 1},
-    Instruction{Opcode: 114, Name: "KIL", Mode: 6, Size: 0, Cycles: 2, PageCycles: 0},
-    Instruction{Opcode: 115, Name: "RRA", Mode: 9, Size: 0, Cycles: 8, PageCycles: 0},
-    Instruction{Opcode: 116, Name: "NOP", Mode: 12, Size: 2, Cycles: 4, PageCycles: 0},
-    Instruction{Opcode: 117, Name: "ADC", Mode: 12, Size: 2, Cycles: 4, PageCycles: 0},
-    Instruction{Opcode: 118, Name: "ROR", Mode: 12, Size: 2, Cycles: 6, PageCycles: 0},
-    Instruction{Opcode: 119, Name: "RRA", Mode: 12, Size: 0, Cycles: 6, PageCycles: 0},
-    Instruction{Opcode: 120, Name: "SEI", Mode: 6, Size: 1, Cycles: 2, PageCycles: 0},
-    Instruction{Opcode: 121, Name: "ADC", Mode: 3, Size: 3, Cycles: 4, PageCycles: 1},
-    Instruction{Opcode: 122, Name: "NOP", Mode: 6, Size: 1, Cycles: 2, PageCycles: 0},
-    Instruction{Opcode: 123, Name: "RRA", Mode: 3, Size: 0, Cycles: 7, PageCycles: 0},
-    Instruction{Opcode: 124, Name: "NOP", Mode: 2, Size: 3, Cycles: 4, PageCycles: 1},
-    Instruction{Opcode: 125, Name: "ADC", Mode: 2, Size: 3, Cycles: 4, PageCycles: 1},
-    Instruction{Opcode: 126, Name: "ROR", Mode: 2, Size: 3, Cycles: 7, PageCycles: 0},
-    Instruction{Opcode: 127, Name: "RRA", Mode: 2, Size: 0, Cycles: 7, PageCycles: 0},
-    Instruction{Opcode: 128, Name: "NOP", Mode: 5, Size: 2, Cycles: 2, PageCycles: 0},
-    Instruction{Opcode: 129, Name: "STA", Mode: 7, Size: 2, Cycles: 6, PageCycles: 0},
-    Instruction{Opcode: 130, Name: "NOP", Mode: 5, Size: 0, Cycles: 2, PageCycles: 0},
-    Instruction{Opcode: 131, Name: "SAX", Mode: 7, Size: 0, Cycles: 6, PageCycles: 0},
-    Instruction{Opcode: 132, Name: "STY", Mode: 11, Size: 2, Cycles: 3, PageCycles: 0},
-    Instruction{Opcode: 133, Name: "STA", Mode: 11, Size: 2, Cycles: 3, PageCycles: 0},
-    Instruction{Opcode: 134, Name: "STX", Mode: 11, Size: 2, Cycles: 3, PageCycles: 0},
-    Instruction{Opcode: 135, Name: "SAX", Mode: 11, Size: 0, Cycles: 3, PageCycles: 0},
-    Instruction{Opcode: 136, Name: "DEY", Mode: 6, Size: 1, Cycles: 2, PageCycles: 0},
-    Instruction{Opcode: 137, Name: "NOP", Mode: 5, Size: 0, Cycles: 2, PageCycles: 0},
-    Instruction{Opcode: 138, Name: "TXA", Mode: 6, Size: 1, Cycles: 2, PageCycles: 0},
-    Instruction{Opcode: 139, Name: "XAA", Mode: 5, Size: 0, Cycles: 2, PageCycles: 0},
-    Instruction{Opcode: 140, Name: "STY", Mode: 1, Size: 3, Cycles: 4, PageCycles: 0},
-    Instruction{Opcode: 141, Name: "STA", Mode: 1, Size: 3, Cycles: 4, PageCycles: 0},
-    Instruction{Opcode: 142, Name: "STX", Mode: 1, Size: 3, Cycles: 4, PageCycles: 0},
-    Instruction{Opcode: 143, Name: "SAX", Mode: 1, Size: 0, Cycles: 4, PageCycles: 0},
-    Instruction{Opcode: 144, Name: "BCC", Mode: 10, Size: 2, Cycles: 2, PageCycles: 1},
-    Instruction{Opcode: 145, Name: "STA", Mode: 9, Size: 2, Cycles: 6, PageCycles: 0},
-    Instruction{Opcode: 146, Name: "KIL", Mode: 6, Size: 0, Cycles: 2, PageCycles: 0},
-    Instruction{Opcode: 147, Name: "AHX", Mode: 9, Size: 0, Cycles: 6, PageCycles: 0},
-    Instruction{Opcode: 148, Name: "STY", Mode: 12, Size: 2, Cycles: 4, PageCycles: 0},
-    Instruction{Opcode: 149, Name: "STA", Mode: 12, Size: 2, Cycles: 4, PageCycles: 0},
-    Instruction{Opcode: 150, Name: "STX", Mode: 13, Size: 2, Cycles: 4, PageCycles: 0},
-    Instruction{Opcode: 151, Name: "SAX", Mode: 13, Size: 0, Cycles: 4, PageCycles: 0},
-    Instruction{Opcode: 152, Name: "TYA", Mode: 6, Size: 1, Cycles: 2, PageCycles: 0},
-    Instruction{Opcode: 153, Name: "STA", Mode: 3, Size: 3, Cycles: 5, PageCycles: 0},
-    Instruction{Opcode: 154, Name: "TXS", Mode: 6, Size: 1, Cycles: 2, PageCycles: 0},
-    Instruction{Opcode: 155, Name: "TAS", Mode: 3, Size: 0, Cycles: 5, PageCycles: 0},
-    Instruction{Opcode: 156, Name: "SHY", Mode: 2, Size: 0, Cycles: 5, PageCycles: 0},
-    Instruction{Opcode: 157, Name: "STA", Mode: 2, Size: 3, Cycles: 5, PageCycles: 0},
-    Instruction{Opcode: 158, Name: "SHX", Mode: 3, Size: 0, Cycles: 5, PageCycles: 0},
-    Instruction{Opcode: 159, Name: "AHX", Mode: 3, Size: 0, Cycles: 5, PageCycles: 0},
-    Instruction{Opcode: 160, Name: "LDY", Mode: 5, Size: 2, Cycles: 2, PageCycles: 0},
-    Instruction{Opcode: 161, Name: "LDA", Mode: 7, Size: 2, Cycles: 6, PageCycles: 0},
-    Instruction{Opcode: 162, Name: "LDX", Mode: 5, Size: 2, Cycles: 2, PageCycles: 0},
-    Instruction{Opcode: 163, Name: "LAX", Mode: 7, Size: 0, Cycles: 6, PageCycles: 0},
-    Instruction{Opcode: 164, Name: "LDY", Mode: 11, Size: 2, Cycles: 3, PageCycles: 0},
-    Instruction{Opcode: 165, Name: "LDA", Mode: 11, Size: 2, Cycles: 3, PageCycles: 0},
-    Instruction{Opcode: 166, Name: "LDX", Mode: 11, Size: 2, Cycles: 3, PageCycles: 0},
-    Instruction{Opcode: 167, Name: "LAX", Mode: 11, Size: 0, Cycles: 3, PageCycles: 0},
-    Instruction{Opcode: 168, Name: "TAY", Mode: 6, Size: 1, Cycles: 2, PageCycles: 0},
-    Instruction{Opcode: 169, Name: "LDA", Mode: 5, Size: 2, Cycles: 2, PageCycles: 0},
-    Instruction{Opcode: 170, Name: "TAX", Mode: 6, Size: 1, Cycles: 2, PageCycles: 0},
-    Instruction{Opcode: 171, Name: "LAX", Mode: 5, Size: 0, Cycles: 2, PageCycles: 0},
-    Instruction{Opcode: 172, Name: "LDY", Mode: 1, Size: 3, Cycles: 4, PageCycles: 0},
-    Instruction{Opcode: 173, Name: "LDA", Mode: 1, Size: 3, Cycles: 4, PageCycles: 0},
-    Instruction{Opcode: 174, Name: "LDX", Mode: 1, Size: 3, Cycles: 4, PageCycles: 0},
-    Instruction{Opcode: 175, Name: "LAX", Mode: 1, Size: 0, Cycles: 4, PageCycles: 0},
-    Instruction{Opcode: 176, Name: "BCS", Mode: 10, Size: 2, Cycles: 2, PageCycles: 1},
-    Instruction{Opcode: 177, Name: "LDA", Mode: 9, Size: 2, Cycles: 5, PageCycles: 1},
-    Instruction{Opcode: 178, Name: "KIL", Mode: 6, Size: 0, Cycles: 2, PageCycles: 0},
-    Instruction{Opcode: 179, Name: "LAX", Mode: 9, Size: 0, Cycles: 5, PageCycles: 1},
-    Instruction{Opcode: 180, Name: "LDY", Mode: 12, Size: 2, Cycles: 4, PageCycles: 0},
-    Instruction{Opcode: 181, Name: "LDA", Mode: 12, Size: 2, Cycles: 4, PageCycles: 0},
-    Instruction{Opcode: 182, Name: "LDX", Mode: 13, Size: 2, Cycles: 4, PageCycles: 0},
-    Instruction{Opcode: 183, Name: "LAX", Mode: 13, Size: 0, Cycles: 4, PageCycles: 0},
-    Instruction{Opcode: 184, Name: "CLV", Mode: 6, Size: 1, Cycles: 2, PageCycles: 0},
-    Instruction{Opcode: 185, Name: "LDA", Mode: 3, Size: 3, Cycles: 4, PageCycles: 1},
-    Instruction{Opcode: 186, Name: "TSX", Mode: 6, Size: 1, Cycles: 2, PageCycles: 0},
-    Instruction{Opcode: 187, Name: "LAS", Mode: 3, Size: 0, Cycles: 4, PageCycles: 1},
-    Instruction{Opcode: 188, Name: "LDY", Mode: 2, Size: 3, Cycles: 4, PageCycles: 1},
-    Instruction{Opcode: 189, Name: "LDA", Mode: 2, Size: 3, Cycles: 4, PageCycles: 1},
-    Instruction{Opcode: 190, Name: "LDX", Mode: 3, Size: 3, Cycles: 4, PageCycles: 1},
-    Instruction{Opcode: 191, Name: "LAX", Mode: 3, Size: 0, Cycles: 4, PageCycles: 1},
-    Instruction{Opcode: 192, Name: "CPY", Mode: 5, Size: 2, Cycles: 2, PageCycles: 0},
-    Instruction{Opcode: 193, Name: "CMP", Mode: 7, Size: 2, Cycles: 6, PageCycles: 0},
-    Instruction{Opcode: 194, Name: "NOP", Mode: 5, Size: 0, Cycles: 2, PageCycles: 0},
-    Instruction{Opcode: 195, Name: "DCP", Mode: 7, Size: 0, Cycles: 8, PageCycles: 0},
-    Instruction{Opcode: 196, Name: "CPY", Mode: 11, Size: 2, Cycles: 3, PageCycles: 0},
-    Instruction{Opcode: 197, Name: "CMP", Mode: 11, Size: 2, Cycles: 3, PageCycles: 0},
-    Instruction{Opcode: 198, Name: "DEC", Mode: 11, Size: 2, Cycles: 5, PageCycles: 0},
-    Instruction{Opcode: 199, Name: "DCP", Mode: 11, Size: 0, Cycles: 5, PageCycles: 0},
-    Instruction{Opcode: 200, Name: "INY", Mode: 6, Size: 1, Cycles: 2, PageCycles: 0},
-    Instruction{Opcode: 201, Name: "CMP", Mode: 5, Size: 2, Cycles: 2, PageCycles: 0},
-    Instruction{Opcode: 202, Name: "DEX", Mode: 6, Size: 1, Cycles: 2, PageCycles: 0},
-    Instruction{Opcode: 203, Name: "AXS", Mode: 5, Size: 0, Cycles: 2, PageCycles: 0},
-    Instruction{Opcode: 204, Name: "CPY", Mode: 1, Size: 3, Cycles: 4, PageCycles: 0},
-    Instruction{Opcode: 205, Name: "CMP", Mode: 1, Size: 3, Cycles: 4, PageCycles: 0},
-    Instruction{Opcode: 206, Name: "DEC", Mode: 1, Size: 3, Cycles: 6, PageCycles: 0},
-    Instruction{Opcode: 207, Name: "DCP", Mode: 1, Size: 0, Cycles: 6, PageCycles: 0},
-    Instruction{Opcode: 208, Name: "BNE", Mode: 10, Size: 2, Cycles: 2, PageCycles: 1},
-    Instruction{Opcode: 209, Name: "CMP", Mode: 9, Size: 2, Cycles: 5, PageCycles: 1},
-    Instruction{Opcode: 210, Name: "KIL", Mode: 6, Size: 0, Cycles: 2, PageCycles: 0},
-    Instruction{Opcode: 211, Name: "DCP", Mode: 9, Size: 0, Cycles: 8, PageCycles: 0},
-    Instruction{Opcode: 212, Name: "NOP", Mode: 12, Size: 2, Cycles: 4, PageCycles: 0},
-    Instruction{Opcode: 213, Name: "CMP", Mode: 12, Size: 2, Cycles: 4, PageCycles: 0},
-    Instruction{Opcode: 214, Name: "DEC", Mode: 12, Size: 2, Cycles: 6, PageCycles: 0},
-    Instruction{Opcode: 215, Name: "DCP", Mode: 12, Size: 0, Cycles: 6, PageCycles: 0},
-    Instruction{Opcode: 216, Name: "CLD", Mode: 6, Size: 1, Cycles: 2, PageCycles: 0},
-    Instruction{Opcode: 217, Name: "CMP", Mode: 3, Size: 3, Cycles: 4, PageCycles: 1},
-    Instruction{Opcode: 218, Name: "NOP", Mode: 6, Size: 1, Cycles: 2, PageCycles: 0},
-    Instruction{Opcode: 219, Name: "DCP", Mode: 3, Size: 0, Cycles: 7, PageCycles: 0},
-    Instruction{Opcode: 220, Name: "NOP", Mode: 2, Size: 3, Cycles: 4, PageCycles: 1},
-    Instruction{Opcode: 221, Name: "CMP", Mode: 2, Size: 3, Cycles: 4, PageCycles: 1},
-    Instruction{Opcode: 222, Name: "DEC", Mode: 2, Size: 3, Cycles: 7, PageCycles: 0},
-    Instruction{Opcode: 223, Name: "DCP", Mode: 2, Size: 0, Cycles: 7, PageCycles: 0},
-    Instruction{Opcode: 224, Name: "CPX", Mode: 5, Size: 2, Cycles: 2, PageCycles: 0},
-    Instruction{Opcode: 225, Name: "SBC", Mode: 7, Size: 2, Cycles: 6, PageCycles: 0},
-    Instruction{Opcode: 226, Name: "NOP", Mode: 5, Size: 0, Cycles: 2, PageCycles: 0},
-    Instruction{Opcode: 227, Name: "ISC", Mode: 7, Size: 0, Cycles: 8, PageCycles: 0},
-    Instruction{Opcode: 228, Name: "CPX", Mode: 11, Size: 2, Cycles: 3, PageCycles: 0},
-    Instruction{Opcode: 229, Name: "SBC", Mode: 11, Size: 2, Cycles: 3, PageCycles: 0},
-    Instruction{Opcode: 230, Name: "INC", Mode: 11, Size: 2, Cycles: 5, PageCycles: 0},
-    Instruction{Opcode: 231, Name: "ISC", Mode: 11, Size: 0, Cycles: 5, PageCycles: 0},
-    Instruction{Opcode: 232, Name: "INX", Mode: 6, Size: 1, Cycles: 2, PageCycles: 0},
-    Instruction{Opcode: 233, Name: "SBC", Mode: 5, Size: 2, Cycles: 2, PageCycles: 0},
-    Instruction{Opcode: 234, Name: "NOP", Mode: 6, Size: 1, Cycles: 2, PageCycles: 0},
-    Instruction{Opcode: 235, Name: "SBC", Mode: 5, Size: 0, Cycles: 2, PageCycles: 0},
-    Instruction{Opcode: 236, Name: "CPX", Mode: 1, Size: 3, Cycles: 4, PageCycles: 0},
-    Instruction{Opcode: 237, Name: "SBC", Mode: 1, Size: 3, Cycles: 4, PageCycles: 0},
-    Instruction{Opcode: 238, Name: "INC", Mode: 1, Size: 3, Cycles: 6, PageCycles: 0},
-    Instruction{Opcode: 239, Name: "ISC", Mode: 1, Size: 0, Cycles: 6, PageCycles: 0},
-    Instruction{Opcode: 240, Name: "BEQ", Mode: 10, Size: 2, Cycles: 2, PageCycles: 1},
-    Instruction{Opcode: 241, Name: "SBC", Mode: 9, Size: 2, Cycles: 5, PageCycles: 1},
-    Instruction{Opcode: 242, Name: "KIL", Mode: 6, Size: 0, Cycles: 2, PageCycles: 0},
-    Instruction{Opcode: 243, Name: "ISC", Mode: 9, Size: 0, Cycles: 8, PageCycles: 0},
-    Instruction{Opcode: 244, Name: "NOP", Mode: 12, Size: 2, Cycles: 4, PageCycles: 0},
-    Instruction{Opcode: 245, Name: "SBC", Mode: 12, Size: 2, Cycles: 4, PageCycles: 0},
-    Instruction{Opcode: 246, Name: "INC", Mode: 12, Size: 2, Cycles: 6, PageCycles: 0},
-    Instruction{Opcode: 247, Name: "ISC", Mode: 12, Size: 0, Cycles: 6, PageCycles: 0},
-    Instruction{Opcode: 248, Name: "SED", Mode: 6, Size: 1, Cycles: 2, PageCycles: 0},
-    Instruction{Opcode: 249, Name: "SBC", Mode: 3, Size: 3, Cycles: 4, PageCycles: 1},
-    Instruction{Opcode: 250, Name: "NOP", Mode: 6, Size: 1, Cycles: 2, PageCycles: 0},
-    Instruction{Opcode: 251, Name: "ISC", Mode: 3, Size: 0, Cycles: 7, PageCycles: 0},
-    Instruction{Opcode: 252, Name: "NOP", Mode: 2, Size: 3, Cycles: 4, PageCycles: 1},
-    Instruction{Opcode: 253, Name: "SBC", Mode: 2, Size: 3, Cycles: 4, PageCycles: 1},
-    Instruction{Opcode: 254, Name: "INC", Mode: 2, Size: 3, Cycles: 7, PageCycles: 0},
-    Instruction{Opcode: 255, Name: "ISC", Mode: 2, Size: 0, Cycles: 7, PageCycles: 0},
-}
 
 // Mirroring Modes
 const (
@@ -646,4 +568,5 @@ func init() {
         b := byte(c)
         Palette[i] = color.RGBA{r, g, b, 0xFF}
     }
+    emphasisPalette = emphasize(Palette)
 }
\ No newline at end of file
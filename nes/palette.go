@@ -0,0 +1,198 @@
+package nes
+
+import (
+	"fmt"
+	"image/color"
+	"io/ioutil"
+	"math"
+)
+
+// emphasisFactor is how much each color emphasis bit of PPUMASK
+// attenuates the video signal, during the part of the subcarrier cycle
+// that bit's color isn't in (see CompositeSignal)
+const emphasisFactor = 0.746
+
+// emphasisFactors is how much each combination of emphasis bits scales red,
+// green and blue. They're taken from the signal model by decoding white
+// with and without emphasis, so that palettes loaded from 64-color files
+// darken and tint the way generated ones do; with all three bits set the
+// signal is attenuated throughout and every channel darkens.
+var emphasisFactors = func () (factors [8][3]float64) {
+	cos, sin := DecodeCarrier(DefaultPaletteParams)
+	decode := func (pixel uint16) [3]float64 {
+		var y, i, q float64
+		for p := 0; p < 12; p++ {
+			v := CompositeSignal(pixel, p)
+			y += v / 12
+			i += v * cos[p] / 12
+			q += v * sin[p] / 12
+		}
+		r, g, b := yiqToRGB(y, i, q, DefaultPaletteParams)
+		return [3]float64{r, g, b}
+	}
+	white := decode(0x30)
+	for e := range factors {
+		rgb := decode(uint16(e<<6 | 0x30))
+		for c := range rgb {
+			factors[e][c] = math.Min(rgb[c]/white[c], 1)
+		}
+	}
+	return factors
+}()
+
+// emphasize expands a 64-color palette to the 512 entries addressed by
+// emphasis<<6 | index, where emphasis is PPUMASK bits 5-7 (red, green, blue)
+func emphasize(palette [64]color.RGBA) [512]color.RGBA {
+	var result [512]color.RGBA
+	for e := 0; e < 8; e++ {
+		f := emphasisFactors[e]
+		for i, c := range palette {
+			r, g, b := float64(c.R), float64(c.G), float64(c.B)
+			// columns $xE and $xF are black and stay black
+			if i&0x0F < 0x0E {
+				r, g, b = r*f[0], g*f[1], b*f[2]
+			}
+			result[e<<6|i] = color.RGBA{byte(r), byte(g), byte(b), 0xFF}
+		}
+	}
+	return result
+}
+
+// LoadPalette reads a .pal file: either 64 RGB triples (192 bytes), which
+// get emphasis applied, or 512 triples (1536 bytes) that include the
+// emphasized colors in the same order as the 512-entry palette.
+func LoadPalette(path string) ([512]color.RGBA, error) {
+	var result [512]color.RGBA
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return result, err
+	}
+	switch len(data) {
+	case 64 * 3:
+		var base [64]color.RGBA
+		for i := range base {
+			base[i] = color.RGBA{data[i*3], data[i*3+1], data[i*3+2], 0xFF}
+		}
+		return emphasize(base), nil
+	case 512 * 3:
+		for i := range result {
+			result[i] = color.RGBA{data[i*3], data[i*3+1], data[i*3+2], 0xFF}
+		}
+		return result, nil
+	}
+	return result, fmt.Errorf("invalid .pal file: %d bytes (want 192 or 1536)", len(data))
+}
+
+// GeneratePalette computes the 512-entry palette by simulating the NTSC
+// signal the PPU outputs for each color and decoding it the way a TV does.
+// Based on Bisqwit's palette generator:
+// http://wiki.nesdev.com/w/index.php/NTSC_video
+func GeneratePalette(params PaletteParams) [512]color.RGBA {
+	cos, sin := DecodeCarrier(params)
+	var result [512]color.RGBA
+	for pixel := range result {
+		// average the signal over one cycle of the color subcarrier
+		var y, i, q float64
+		for p := 0; p < 12; p++ {
+			v := CompositeSignal(uint16(pixel), p)
+			y += v / 12
+			i += v * cos[p] / 12
+			q += v * sin[p] / 12
+		}
+		result[pixel] = DecodeYIQ(y, i, q, params)
+	}
+	return result
+}
+
+// CompositeSignal returns the level of the PPU's video signal for a pixel
+// (emphasis<<6 | color) at one of the 12 phases of the color subcarrier,
+// scaled so that black is 0 and white is 1.
+func CompositeSignal(pixel uint16, phase int) float64 {
+	// voltage levels, relative to sync, of the low and high halves of the
+	// signal for each of the four luma rows
+	levels := [8]float64{
+		0.350, 0.518, 0.962, 1.550, // low
+		1.094, 1.506, 1.962, 1.962, // high
+	}
+	const black, white = 0.518, 1.962
+
+	e := int(pixel >> 6) & 7
+	c := int(pixel & 0x0F)
+	level := int(pixel >> 4) & 3
+	if c > 13 {
+		level = 1 // columns $xE and $xF are black
+	}
+
+	// the signal is a square wave, high for half of the subcarrier cycle
+	inPhase := func (c int) bool {
+		return (c+phase)%12 < 6
+	}
+	var signal float64
+	switch {
+	case c == 0:
+		signal = levels[level+4]
+	case c >= 13:
+		signal = levels[level]
+	case inPhase(c):
+		signal = levels[level+4]
+	default:
+		signal = levels[level]
+	}
+	if c < 14 && ((e&1 != 0 && inPhase(0)) || (e&2 != 0 && inPhase(4)) || (e&4 != 0 && inPhase(8))) {
+		signal *= emphasisFactor
+	}
+	return (signal - black) / (white - black)
+}
+
+// DecodeCarrier returns the reference waves a TV multiplies the signal by
+// at each subcarrier phase to recover I and Q.
+func DecodeCarrier(params PaletteParams) (cos, sin [12]float64) {
+	hue := params.Hue * math.Pi / 180
+	for p := 0; p < 12; p++ {
+		// p+4 lines the decoder up with the color burst, so that a hue
+		// of 0 gives the same colors as the built-in palette
+		cos[p] = math.Cos(math.Pi*float64(p+4)/6 + hue)
+		sin[p] = math.Sin(math.Pi*float64(p+4)/6 + hue)
+	}
+	return cos, sin
+}
+
+// DecodeYIQ converts a decoded signal to RGB, applying the contrast,
+// brightness, saturation and gamma adjustments of params.
+func DecodeYIQ(y, i, q float64, params PaletteParams) color.RGBA {
+	gammaFix := func (f float64) byte {
+		if f <= 0 {
+			return 0
+		}
+		if params.Gamma != 2.2 {
+			f = math.Pow(f, 2.2/params.Gamma)
+		}
+		f *= 255
+		if f > 255 {
+			return 255
+		}
+		return byte(f)
+	}
+	r, g, b := yiqToRGB(y, i, q, params)
+	return color.RGBA{gammaFix(r), gammaFix(g), gammaFix(b), 0xFF}
+}
+
+// yiqToRGB applies the contrast, brightness and saturation of params to a
+// decoded signal and converts it to linear RGB, unclamped
+func yiqToRGB(y, i, q float64, params PaletteParams) (r, g, b float64) {
+	y = y*params.Contrast + params.Brightness
+	i *= params.Saturation * params.Contrast
+	q *= params.Saturation * params.Contrast
+	return y + 0.946882*i + 0.623557*q, y - 0.274788*i - 0.635691*q, y - 1.108545*i + 1.709007*q
+}
+
+// SetPalette sets the colors the console renders with.
+func SetPalette(console *Console, palette [512]color.RGBA) {
+	console.PPU.palette = palette
+	console.PPU.frontStale = console.PPU.indexed
+}
+
+// ActivePalette returns the colors the console renders with.
+func ActivePalette(console *Console) [512]color.RGBA {
+	return console.PPU.palette
+}
@@ -0,0 +1,71 @@
+package nes
+
+// APUChannels returns the recent output and current state of the five APU
+// channels: pulse 1, pulse 2, triangle, noise and DMC.
+func APUChannels(console *Console) [5]ChannelState {
+	apu := console.APU
+	var result [5]ChannelState
+
+	// copy a ring buffer out oldest first
+	samples := func (i int) []byte {
+		s := make([]byte, scopeLength)
+		n := copy(s, apu.scope[i][apu.scopeIndex:])
+		copy(s[n:], apu.scope[i][:apu.scopeIndex])
+		return s
+	}
+
+	pulse := func (p *Pulse, name string, i int) ChannelState {
+		volume := p.constantVolume
+		if p.envelopeEnabled {
+			volume = p.envelopeVolume
+		}
+		return ChannelState{
+			Name: name,
+			Samples: samples(i),
+			Max: 15,
+			Enabled: p.enabled && p.lengthValue > 0 && p.timerPeriod >= 8,
+			Period: p.timerPeriod,
+			Frequency: CPUFrequency / (16 * (float64(p.timerPeriod) + 1)),
+			Volume: volume,
+			Duty: p.dutyMode,
+		}
+	}
+	result[0] = pulse(&apu.pulse1, "PULSE1", 0)
+	result[1] = pulse(&apu.pulse2, "PULSE2", 1)
+
+	t := &apu.triangle
+	result[2] = ChannelState{
+		Name: "TRIANGLE",
+		Samples: samples(2),
+		Max: 15,
+		Enabled: t.enabled && t.lengthValue > 0 && t.counterValue > 0,
+		Period: t.timerPeriod,
+		Frequency: CPUFrequency / (32 * (float64(t.timerPeriod) + 1)),
+		Volume: 15,
+	}
+
+	n := &apu.noise
+	volume := n.constantVolume
+	if n.envelopeEnabled {
+		volume = n.envelopeVolume
+	}
+	result[3] = ChannelState{
+		Name: "NOISE",
+		Samples: samples(3),
+		Max: 15,
+		Enabled: n.enabled && n.lengthValue > 0,
+		Period: n.timerPeriod,
+		Volume: volume,
+	}
+
+	d := &apu.dmc
+	result[4] = ChannelState{
+		Name: "DMC",
+		Samples: samples(4),
+		Max: 127,
+		Enabled: d.enabled && d.currentLength > 0,
+		Period: uint16(d.tickPeriod),
+		Volume: d.value,
+	}
+	return result
+}
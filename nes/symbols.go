@@ -0,0 +1,343 @@
+package nes
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// FindSymbols looks for debug info next to a ROM: rom.dbg or rom.nes.dbg
+// (from ld65's --dbgfile), or NESASM's rom.fns. It returns "" if there's
+// none.
+func FindSymbols(romPath string) string {
+	base := strings.TrimSuffix(romPath, filepath.Ext(romPath))
+	for _, path := range []string{base + ".dbg", romPath + ".dbg", base + ".fns"} {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// LoadSymbols reads the debug info at path, a ca65/ld65 .dbg file or a
+// NESASM .fns file, and attaches it to the console. From then on the
+// disassembler, the trace logger and the debugger show labels and source
+// lines. Labels and lines in PRG ROM are tied to their bank, so they only
+// show while the mapper has that bank switched in; NESASM doesn't say
+// which bank, so its labels apply to whatever is at their address.
+func LoadSymbols(console *Console, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	var symbols *Symbols
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".dbg":
+		symbols, err = readDbg(file, len(console.Cartridge.PRG))
+	case ".fns":
+		symbols, err = readFns(file)
+	default:
+		return fmt.Errorf("%s: unknown debug info format (want .dbg or .fns)", path)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	indexSymbols(symbols)
+	console.Symbols = symbols
+	return nil
+}
+
+// readDbg reads the debug info ld65 writes. Each line is a record type
+// and comma separated attributes:
+//
+//   seg	id=1,name="CODE",start=0x008000,size=0x0123,addrsize=absolute,type=ro,oname="game.nes",ooffs=16
+//   span	id=4,seg=1,start=0,size=3
+//   line	id=7,file=0,line=12,span=4
+//   sym	id=2,name="reset",addrsize=absolute,scope=0,def=9,val=0x8000,seg=1,type=lab
+//
+// A segment's offset in the output file (ooffs) places it in PRG ROM,
+// after the 16 byte header if the file is a .nes.
+func readDbg(r io.Reader, prgSize int) (*Symbols, error) {
+	type segment struct {
+		start  int
+		offset int // of its start in PRG ROM, or -1
+	}
+	type span struct {
+		segment, start, size int
+	}
+	type line struct {
+		file, line int
+		spans      []int
+	}
+	files := map[int]string{}
+	segments := map[int]segment{}
+	spans := map[int]span{}
+	var lines []line
+	var labels []map[string]string
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), "\t", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		attributes := dbgAttributes(fields[1])
+		number := func (name string) int {
+			n, _ := strconv.ParseInt(attributes[name], 0, 64)
+			return int(n)
+		}
+		switch fields[0] {
+		case "file":
+			files[number("id")] = attributes["name"]
+		case "seg":
+			s := segment{number("start"), -1}
+			if output, ok := attributes["oname"]; ok {
+				s.offset = number("ooffs")
+				if strings.EqualFold(filepath.Ext(output), ".nes") {
+					s.offset -= 16
+				}
+			}
+			segments[number("id")] = s
+		case "span":
+			spans[number("id")] = span{number("seg"), number("start"), number("size")}
+		case "line":
+			if number("type") == 2 {
+				// a line inside a macro; its invocation covers it
+				continue
+			}
+			l := line{file: number("file"), line: number("line")}
+			for _, id := range strings.Split(attributes["span"], "+") {
+				if id, err := strconv.Atoi(id); err == nil {
+					l.spans = append(l.spans, id)
+				}
+			}
+			lines = append(lines, l)
+		case "sym":
+			if attributes["type"] == "lab" {
+				labels = append(labels, attributes)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// locate places an offset into a segment
+	locate := func (segmentID, start int) (uint16, int) {
+		s := segments[segmentID]
+		address := s.start + start
+		offset := -1
+		if s.offset >= 0 && address >= 0x8000 && s.offset+start >= 0 && s.offset+start < prgSize {
+			offset = s.offset + start
+		}
+		return uint16(address), offset
+	}
+	symbols := &Symbols{}
+	for _, attributes := range labels {
+		value, _ := strconv.ParseInt(attributes["val"], 0, 64)
+		l := Label{Name: attributes["name"], Address: uint16(value), Offset: -1}
+		if id, err := strconv.Atoi(attributes["seg"]); err == nil {
+			_, l.Offset = locate(id, int(value) - segments[id].start)
+		}
+		symbols.Labels = append(symbols.Labels, l)
+	}
+	for _, l := range lines {
+		for _, id := range l.spans {
+			s, ok := spans[id]
+			if !ok || s.size == 0 {
+				continue
+			}
+			address, offset := locate(s.segment, s.start)
+			symbols.Lines = append(symbols.Lines, SourceLine{files[l.file], l.line, address, offset, s.size})
+		}
+	}
+	return symbols, nil
+}
+
+// dbgAttributes splits a .dbg record's attributes, unquoting strings
+func dbgAttributes(s string) map[string]string {
+	attributes := map[string]string{}
+	for len(s) > 0 {
+		end, quoted := 0, false
+		for ; end < len(s) && (quoted || s[end] != ','); end++ {
+			if s[end] == '"' {
+				quoted = !quoted
+			}
+		}
+		if i := strings.IndexByte(s[:end], '='); i >= 0 {
+			value := s[i+1:end]
+			if unquoted, err := strconv.Unquote(value); err == nil {
+				value = unquoted
+			}
+			attributes[s[:i]] = value
+		}
+		if end < len(s) {
+			end++
+		}
+		s = s[end:]
+	}
+	return attributes
+}
+
+var fnsLabel = regexp.MustCompile(`^\s*([A-Za-z_.@][\w.@]*)\s*=\s*\$([0-9A-Fa-f]{1,4})\s*$`)
+
+// readFns reads NESASM's list of labels, "name = $C000" a line, with
+// comments starting with ;
+func readFns(r io.Reader) (*Symbols, error) {
+	symbols := &Symbols{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		m := fnsLabel.FindStringSubmatch(scanner.Text())
+		if m == nil {
+			continue
+		}
+		address, _ := strconv.ParseUint(m[2], 16, 16)
+		symbols.Labels = append(symbols.Labels, Label{m[1], uint16(address), -1})
+	}
+	return symbols, scanner.Err()
+}
+
+func indexSymbols(symbols *Symbols) {
+	symbols.labels = map[symbolKey]*Label{}
+	symbols.lines = map[symbolKey]*SourceLine{}
+	for i := range symbols.Labels {
+		l := &symbols.Labels[i]
+		key := symbolKey{l.Offset, l.Address}
+		if l.Offset >= 0 {
+			key.address = 0
+		}
+		if _, ok := symbols.labels[key]; !ok {
+			symbols.labels[key] = l
+		}
+	}
+	for i := range symbols.Lines {
+		l := &symbols.Lines[i]
+		for j := 0; j < l.Size; j++ {
+			key := symbolKey{-1, l.Address + uint16(j)}
+			if l.Offset >= 0 {
+				key = symbolKey{l.Offset + j, 0}
+			}
+			if _, ok := symbols.lines[key]; !ok {
+				symbols.lines[key] = l
+			}
+		}
+	}
+}
+
+// labelAt finds the label for the byte of PRG ROM at offset (-1 for
+// none), which the CPU sees at address: one tied to that byte, or failing
+// that one at the address
+func labelAt(symbols *Symbols, offset int, address uint16) (*Label, bool) {
+	if l, ok := symbols.labels[symbolKey{offset, 0}]; ok && offset >= 0 {
+		return l, true
+	}
+	l, ok := symbols.labels[symbolKey{-1, address}]
+	return l, ok
+}
+
+// lineAt finds the source line for a byte as labelAt does
+func lineAt(symbols *Symbols, offset int, address uint16) (*SourceLine, bool) {
+	if l, ok := symbols.lines[symbolKey{offset, 0}]; ok && offset >= 0 {
+		return l, true
+	}
+	l, ok := symbols.lines[symbolKey{-1, address}]
+	return l, ok
+}
+
+// prgOffset is the byte of PRG ROM the CPU sees at address now, or -1
+func prgOffset(console *Console, address uint16) int {
+	if address < 0x8000 {
+		return -1
+	}
+	return mapperOffset(console.Mapper, address)
+}
+
+// SymbolAt returns the label at a CPU address, as the mapper's banks are
+// now
+func SymbolAt(console *Console, address uint16) (string, bool) {
+	if console.Symbols == nil {
+		return "", false
+	}
+	if l, ok := labelAt(console.Symbols, prgOffset(console, address), address); ok {
+		return l.Name, true
+	}
+	return "", false
+}
+
+// SourceAt returns the source line that assembled to the byte at a CPU
+// address, as the mapper's banks are now
+func SourceAt(console *Console, address uint16) (SourceLine, bool) {
+	if console.Symbols == nil {
+		return SourceLine{}, false
+	}
+	if l, ok := lineAt(console.Symbols, prgOffset(console, address), address); ok {
+		return *l, true
+	}
+	return SourceLine{}, false
+}
+
+// sourceStart returns the source line starting at a CPU address, for
+// commenting disassembly
+func sourceStart(symbols *Symbols, offset int, address uint16) (*SourceLine, bool) {
+	l, ok := lineAt(symbols, offset, address)
+	if !ok || l.Offset >= 0 && l.Offset != offset || l.Offset < 0 && l.Address != address {
+		return nil, false
+	}
+	return l, true
+}
+
+// CurrentAddress is where the CPU sees a label or line now: at its
+// address, outside PRG ROM, or wherever the mapper has put its bank. It
+// reports false while the bank is switched out.
+func CurrentAddress(console *Console, address uint16, offset int) (uint16, bool) {
+	if offset < 0 {
+		return address, true
+	}
+	// the mappers switch banks of 8K at the smallest
+	for window := 0x8000; window < 0x10000; window += 0x2000 {
+		base := mapperOffset(console.Mapper, uint16(window))
+		if offset >= base && offset < base+0x2000 {
+			return uint16(window + offset - base), true
+		}
+	}
+	return 0, false
+}
+
+// SymbolAddress finds a label by name and returns where the CPU sees it
+// now
+func SymbolAddress(console *Console, name string) (uint16, error) {
+	if console.Symbols != nil {
+		for _, l := range console.Symbols.Labels {
+			if l.Name == name {
+				if address, ok := CurrentAddress(console, l.Address, l.Offset); ok {
+					return address, nil
+				}
+				return 0, fmt.Errorf("%s is in a bank that isn't switched in", name)
+			}
+		}
+	}
+	return 0, fmt.Errorf("no symbol %s", name)
+}
+
+// FindLine finds the first line at or after line in a source file (named
+// as in the debug info, or by its base name) that assembled to something
+func FindLine(symbols *Symbols, file string, line int) (SourceLine, bool) {
+	var found SourceLine
+	ok := false
+	for _, l := range symbols.Lines {
+		if l.File != file && filepath.Base(l.File) != filepath.Base(file) || l.Line < line {
+			continue
+		}
+		if !ok || l.Line < found.Line || l.Line == found.Line && l.Offset < found.Offset {
+			found, ok = l, true
+		}
+	}
+	return found, ok
+}
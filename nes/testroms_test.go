@@ -0,0 +1,227 @@
+package nes_test
+
+// Accuracy tests that run test ROMs headlessly and read their verdicts.
+// The ROMs aren't part of the repository: point NES_TEST_ROMS at a copy of
+// https://github.com/christopherpow/nes-test-roms (the default is
+// testdata/roms). Tests whose ROMs are missing are skipped, and go test -v
+// ends with a table of what passes.
+//
+// Suites that only show their verdict on screen (sprite_hit_tests,
+// blargg_ppu_tests) pass when the last frame matches a hash in frameHashes.
+// A ROM without one is reported as "no hash" with its frame's hash; set
+// NES_TEST_FRAMES to a directory to save the frames as PNGs, and add the
+// hash once its frame shows a pass.
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"text/tabwriter"
+
+	"github.com/BrianWill/nes/nes"
+	"github.com/BrianWill/nes/tracediff"
+)
+
+// a set of test ROMs and how to tell whether one passed
+type romTest struct {
+	patterns []string // globs under the ROM directory
+	// check runs the ROM at path (name under the ROM directory) and
+	// reports whether it passed, or why not; "" for no verdict
+	check func (t *testing.T, console *nes.Console, path, name string) (bool, string)
+}
+
+var romTests = []romTest{
+	{[]string{"nestest.nes", "other/nestest.nes"}, checkNestest},
+	{[]string{"instr_test-v5/rom_singles/*.nes"}, checkStatus},
+	{[]string{"instr_timing/rom_singles/*.nes"}, checkStatus},
+	{[]string{"cpu_interrupts_v2/rom_singles/*.nes"}, checkStatus},
+	{[]string{"ppu_vbl_nmi/rom_singles/*.nes"}, checkStatus},
+	{[]string{"apu_test/rom_singles/*.nes"}, checkStatus},
+	{[]string{"mmc3_test_2/rom_singles/*.nes"}, checkStatus},
+	{[]string{"sprite_hit_tests_2005.10.05/*.nes"}, checkFrame},
+	{[]string{"blargg_ppu_tests_2005.09.15b/*.nes"}, checkFrame},
+}
+
+// frameHashes are the SHA-1s of the last frame of ROMs that only show
+// their verdict on screen (see checkFrame), keyed by path under the ROM
+// directory. Add one only after checking the frame by eye.
+var frameHashes = map[string]string{}
+
+// how long a ROM may run, in emulated seconds
+const romTimeout = 30
+
+func TestROMs(t *testing.T) {
+	if testing.Short() {
+		t.Skip("test ROMs are slow")
+	}
+	dir := os.Getenv("NES_TEST_ROMS")
+	if dir == "" {
+		dir = filepath.Join("testdata", "roms")
+	}
+
+	found := 0
+	var table bytes.Buffer
+	w := tabwriter.NewWriter(&table, 0, 8, 2, ' ', 0)
+	for _, test := range romTests {
+		var paths []string
+		for _, pattern := range test.patterns {
+			matches, _ := filepath.Glob(filepath.Join(dir, pattern))
+			paths = append(paths, matches...)
+		}
+		if len(paths) == 0 {
+			fmt.Fprintf(w, "%s\tmissing\t\n", test.patterns[0])
+			continue
+		}
+		found += len(paths)
+		for _, path := range paths {
+			name, _ := filepath.Rel(dir, path)
+			result := "skipped"
+			t.Run(name, func (t *testing.T) {
+				console, err := nes.NewConsole(path)
+				if err != nil {
+					result = "error"
+					t.Fatal(err)
+				}
+				pass, message := test.check(t, console, path, name)
+				switch {
+				case pass:
+					result = "pass"
+				case message == "":
+					result = "no hash"
+					t.Skip("no verdict")
+				default:
+					result = "FAIL"
+					t.Error(message)
+				}
+			})
+			fmt.Fprintf(w, "%s\t%s\t\n", name, result)
+		}
+	}
+	w.Flush()
+	if found == 0 {
+		t.Skipf("no test ROMs in %s (set NES_TEST_ROMS)", dir)
+	}
+	t.Log("results:\n" + table.String())
+}
+
+// checkStatus reads the verdict of blargg's later tests, which write it
+// to cartridge RAM: $6001-$6003 hold DE B0 61 once the test is running,
+// $6000 holds $80 while it runs, $81 when it needs the reset button
+// pressed, and then the result code, 0 for a pass; text describing the
+// result starts at $6004.
+func checkStatus(t *testing.T, console *nes.Console, path, name string) (bool, string) {
+	resetAt := -1
+	for frame := 0; frame < romTimeout*60; frame++ {
+		nes.StepSeconds(console, 1.0/60)
+		if nes.Peek(console, 0x6001) != 0xDE || nes.Peek(console, 0x6002) != 0xB0 ||
+				nes.Peek(console, 0x6003) != 0x61 {
+			continue
+		}
+		switch status := nes.Peek(console, 0x6000); {
+		case status == 0x80:
+			resetAt = -1
+		case status == 0x81:
+			// the test wants reset pressed at least 100 ms from now
+			if resetAt < 0 {
+				resetAt = frame + 10
+			} else if frame == resetAt {
+				nes.Reset(console)
+			}
+		case status == 0:
+			return true, ""
+		default:
+			return false, fmt.Sprintf("result %d: %s", status, statusText(console))
+		}
+	}
+	return false, "timed out: " + statusText(console)
+}
+
+func statusText(console *nes.Console) string {
+	var text []byte
+	for address := uint16(0x6004); address < 0x7000; address++ {
+		c := nes.Peek(console, address)
+		if c == 0 {
+			break
+		}
+		text = append(text, c)
+	}
+	return strings.TrimSpace(string(text))
+}
+
+// checkNestest runs nestest in its automated mode, from $C000 to the
+// final RTS at $C66E, and reads the error codes it leaves at $02 (for the
+// official instructions) and $03 (the unofficial ones). When nestest.log
+// is next to the ROM, the trace is compared with it too.
+func checkNestest(t *testing.T, console *nes.Console, path, name string) (bool, string) {
+	var trace bytes.Buffer
+	nes.TraceTo(console, &trace)
+	console.CPU.PC = 0xC000
+	d := nes.EnableDebugger(console)
+	nes.AddBreakpoint(d, nes.Breakpoint{Kind: nes.BreakExecute, Start: 0xC66E})
+	stop := nes.Continue(console, romTimeout*nes.CPUFrequency)
+	if stop.Reason != nes.StopBreakpoint {
+		nes.StopTrace(console)
+		return false, fmt.Sprintf("didn't reach $C66E; stopped at $%04X", console.CPU.PC)
+	}
+	// nestest.log ends with the RTS itself
+	nes.StepInto(console)
+	nes.StopTrace(console)
+
+	if reference, err := os.Open(strings.TrimSuffix(path, ".nes") + ".log"); err == nil {
+		defer reference.Close()
+		divergence, err := tracediff.Diff(&trace, reference, 5)
+		if err != nil {
+			return false, err.Error()
+		}
+		if divergence != nil {
+			var report bytes.Buffer
+			tracediff.Print(&report, divergence)
+			return false, report.String()
+		}
+	}
+	if official, unofficial := nes.Peek(console, 2), nes.Peek(console, 3); official != 0 || unofficial != 0 {
+		return false, fmt.Sprintf("error codes $%02X (official) and $%02X (unofficial)", official, unofficial)
+	}
+	return true, ""
+}
+
+// checkFrame runs a ROM that shows its verdict only on screen for a few
+// seconds and compares a hash of the frame with frameHashes. Without a
+// known hash there's no verdict, and the hash is logged instead.
+func checkFrame(t *testing.T, console *nes.Console, path, name string) (bool, string) {
+	nes.SetIndexedOutput(console, true)
+	nes.StepSeconds(console, 5)
+	hash := sha1.New()
+	binary.Write(hash, binary.LittleEndian, nes.IndexedBuffer(console))
+	sum := fmt.Sprintf("%x", hash.Sum(nil))
+	if dir := os.Getenv("NES_TEST_FRAMES"); dir != "" {
+		file := strings.Replace(strings.TrimSuffix(name, ".nes"), string(filepath.Separator), "_", -1) + ".png"
+		if err := saveFrame(console, filepath.Join(dir, file)); err != nil {
+			t.Log(err)
+		}
+	}
+	known, ok := frameHashes[name]
+	switch {
+	case !ok:
+		t.Logf("no known frame hash; this run's is %s", sum)
+		return false, ""
+	case known != sum:
+		return false, "frame hash " + sum + ", want " + known
+	}
+	return true, ""
+}
+
+func saveFrame(console *nes.Console, path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return png.Encode(file, nes.Buffer(console))
+}
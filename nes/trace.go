@@ -0,0 +1,192 @@
+package nes
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	"github.com/BrianWill/nes/cpu6502"
+)
+
+// StartTrace begins logging every instruction, before it executes, to the
+// file at path in the format of nestest.log (as written by Nintendulator):
+//
+//   C000  4C F5 C5  JMP $C5F5                       A:00 X:00 Y:00 P:24 SP:FD PPU:  0, 21 CYC:7
+//
+// Set the returned Tracer's start and stop conditions to log only part of
+// a run, and its PC range to leave out instructions in between.
+// With the Tracer's Symbols set and debug info loaded (see LoadSymbols),
+// operands show labels and a labelled instruction is preceded by a line
+// with its label.
+func StartTrace(console *Console, path string) (*Tracer, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	t := TraceTo(console, file)
+	t.file = file
+	return t, nil
+}
+
+// TraceTo is StartTrace for a log written to w rather than a file
+func TraceTo(console *Console, w io.Writer) *Tracer {
+	StopTrace(console)
+	t := &Tracer{
+		StartPC:  -1,
+		StopPC:   -1,
+		PCEnd:    0xFFFF,
+		FrameEnd: math.MaxUint64,
+		w:        bufio.NewWriter(w),
+	}
+	console.Tracer = t
+	return t
+}
+
+// StopTrace ends logging and closes the file StartTrace opened, returning
+// the first error writing the log
+func StopTrace(console *Console) error {
+	t := console.Tracer
+	if t == nil {
+		return nil
+	}
+	console.Tracer = nil
+	if err := t.w.Flush(); t.err == nil {
+		t.err = err
+	}
+	if t.file != nil {
+		if err := t.file.Close(); t.err == nil {
+			t.err = err
+		}
+	}
+	return t.err
+}
+
+// TraceDone reports whether a tracer's stop condition has been met, after
+// which it logs nothing more
+func TraceDone(t *Tracer) bool {
+	return t.done
+}
+
+// traceInstruction logs the instruction at the PC if the tracer has
+// started and not stopped, and the PC is in its range. Called from stepCPU
+// before the opcode fetch.
+func traceInstruction(console *Console) {
+	t := console.Tracer
+	cpu := console.CPU
+	ppu := console.PPU
+	if ppu.Frame > t.FrameEnd {
+		t.done = true
+	}
+	if t.err != nil || t.done {
+		return
+	}
+	if !t.started {
+		if ppu.Frame < t.FrameStart || t.StartPC >= 0 && int(cpu.PC) != t.StartPC {
+			return
+		}
+		t.started = true
+	}
+	if int(cpu.PC) == t.StopPC {
+		// the instruction it stops at is the last logged
+		t.done = true
+	}
+	if cpu.PC < t.PCStart || cpu.PC > t.PCEnd {
+		return
+	}
+	read := func (address uint16) byte {
+		return Peek(console, address)
+	}
+	opcode := read(cpu.PC)
+	instruction := cpu6502.Instructions[opcode]
+	unofficial := " "
+	if !official[opcode] {
+		unofficial = "*"
+	}
+	text := traceOperands(console, cpu.PC, t.Symbols)
+	line := formatInstruction(read, cpu.PC, int(instruction.Size), "")
+	if name, ok := SymbolAt(console, cpu.PC); ok && t.Symbols {
+		if _, t.err = fmt.Fprintf(t.w, "%s:\n", name); t.err != nil {
+			return
+		}
+	}
+	_, t.err = fmt.Fprintf(t.w, "%s%s%-32sA:%02X X:%02X Y:%02X P:%02X SP:%02X PPU:%3d,%3d CYC:%d\n",
+		line[:15], unofficial, text, cpu.A, cpu.X, cpu.Y, cpu6502.Flags(&cpu.CPU), cpu.SP, ppu.ScanLine, ppu.Cycle, cpu.Cycles)
+}
+
+// traceOperands formats the instruction at address the way nestest.log
+// does: with the effective address and the value there, as they are
+// before the instruction runs. With symbols, addresses show as labels.
+func traceOperands(console *Console, address uint16, symbols bool) string {
+	cpu := console.CPU
+	read := func (address uint16) byte {
+		return Peek(console, address)
+	}
+	name := func (address uint16, digits int) string {
+		if label, ok := SymbolAt(console, address); ok && symbols {
+			return label
+		}
+		return fmt.Sprintf("$%0*X", digits, address)
+	}
+	// read16 reads a little-endian word without carrying into the high
+	// byte's page, as the CPU does for zero page and JMP ($xxFF)
+	read16 := func (address uint16) uint16 {
+		high := address&0xFF00 | uint16(byte(address)+1)
+		return uint16(read(address)) | uint16(read(high))<<8
+	}
+	instruction := cpu6502.Instructions[read(address)]
+	mnemonic := instruction.Name
+	if mnemonic == "ISC" {
+		mnemonic = "ISB" // nestest.log's name for it
+	}
+	operand := uint16(read(address + 1))
+	if instruction.Size == 3 {
+		operand |= uint16(read(address + 2)) << 8
+	}
+	jump := mnemonic == "JMP" || mnemonic == "JSR"
+
+	var text string
+	switch instruction.Mode {
+	case cpu6502.ModeAbsolute:
+		text = name(operand, 4)
+		if !jump {
+			text += fmt.Sprintf(" = %02X", read(operand))
+		}
+	case cpu6502.ModeAbsoluteX:
+		effective := operand + uint16(cpu.X)
+		text = fmt.Sprintf("%s,X @ %04X = %02X", name(operand, 4), effective, read(effective))
+	case cpu6502.ModeAbsoluteY:
+		effective := operand + uint16(cpu.Y)
+		text = fmt.Sprintf("%s,Y @ %04X = %02X", name(operand, 4), effective, read(effective))
+	case cpu6502.ModeAccumulator:
+		text = "A"
+	case cpu6502.ModeImmediate:
+		text = fmt.Sprintf("#$%02X", operand)
+	case cpu6502.ModeImplied:
+	case cpu6502.ModeIndexedIndirect:
+		pointer := byte(operand) + cpu.X
+		effective := read16(uint16(pointer))
+		text = fmt.Sprintf("(%s,X) @ %02X = %04X = %02X", name(operand, 2), pointer, effective, read(effective))
+	case cpu6502.ModeIndirect:
+		text = fmt.Sprintf("(%s) = %04X", name(operand, 4), read16(operand))
+	case cpu6502.ModeIndirectIndexed:
+		base := read16(operand)
+		effective := base + uint16(cpu.Y)
+		text = fmt.Sprintf("(%s),Y = %04X @ %04X = %02X", name(operand, 2), base, effective, read(effective))
+	case cpu6502.ModeRelative:
+		text = name(address + 2 + uint16(int8(operand)), 4)
+	case cpu6502.ModeZeroPage:
+		text = fmt.Sprintf("%s = %02X", name(operand, 2), read(operand))
+	case cpu6502.ModeZeroPageX:
+		effective := byte(operand) + cpu.X
+		text = fmt.Sprintf("%s,X @ %02X = %02X", name(operand, 2), effective, read(uint16(effective)))
+	case cpu6502.ModeZeroPageY:
+		effective := byte(operand) + cpu.Y
+		text = fmt.Sprintf("%s,Y @ %02X = %02X", name(operand, 2), effective, read(uint16(effective)))
+	}
+	if text == "" {
+		return mnemonic
+	}
+	return mnemonic + " " + text
+}
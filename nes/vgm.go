@@ -0,0 +1,168 @@
+package nes
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+)
+
+// VGM 1.71 file format
+// http://vgmrips.net/wiki/VGM_Specification
+const (
+	vgmVersion     = 0x171
+	vgmHeaderSize  = 0x100
+	vgmSampleRate  = 44100
+	vgmCmdAPUWrite = 0xB4 // aa dd: write dd to NES APU register $4000+aa
+	vgmCmdWait     = 0x61 // nn nn: wait n samples
+	vgmCmdWait735  = 0x62 // wait 1/60 second
+	vgmCmdWait882  = 0x63 // wait 1/50 second
+	vgmCmdWaitN    = 0x70 // 0x7n: wait n+1 samples
+	vgmCmdEnd      = 0x66
+	vgmCmdBlock    = 0x67 // 0x66 tt ss ss ss ss: data block of type tt and size s
+	vgmBlockAPURAM = 0xC2 // NES APU RAM write: 16-bit start address then data
+)
+
+// StartVGM begins logging APU register writes. The current register
+// state is written first so the log plays back from the right state:
+// $4015 comes first, since writes to the length counters of disabled
+// channels are ignored, then the channel registers and $4017.
+func StartVGM(console *Console) {
+	l := &VGMLogger{
+		startCycle: console.CPU.Cycles,
+		dmcSamples: make(map[uint16][]byte),
+	}
+	console.VGM = l
+	apu := console.APU
+	write := func (address uint16) {
+		l.data = append(l.data, vgmCmdAPUWrite, byte(address-0x4000), apu.registers[address-0x4000])
+	}
+	status := apu.registers[0x15]
+	l.data = append(l.data, vgmCmdAPUWrite, 0x15, status&^16)
+	for address := uint16(0x4000); address <= 0x4013; address++ {
+		write(address)
+	}
+	// enabling the DMC starts its sample over, so only do it for a
+	// sample the game set up and is still playing
+	const dmcSample = 1<<0x12 | 1<<0x13
+	if status&16 == 16 && apu.written&dmcSample == dmcSample && apu.dmc.currentLength > 0 {
+		logDMCSample(console)
+		l.data = append(l.data, vgmCmdAPUWrite, 0x15, status)
+	}
+	write(0x4017)
+}
+
+// StopVGM ends logging and writes the log to path as a .vgm file.
+func StopVGM(console *Console, path string) error {
+	l := console.VGM
+	if l == nil {
+		return nil
+	}
+	console.VGM = nil
+	logVGMWait(l, console.CPU.Cycles)
+	l.data = append(l.data, vgmCmdEnd)
+
+	header := make([]byte, vgmHeaderSize)
+	copy(header[0x00:], "Vgm ")
+	binary.LittleEndian.PutUint32(header[0x04:], uint32(vgmHeaderSize+len(l.data)-0x04)) // EOF offset
+	binary.LittleEndian.PutUint32(header[0x08:], vgmVersion)
+	binary.LittleEndian.PutUint32(header[0x18:], uint32(l.samples)) // total samples
+	binary.LittleEndian.PutUint32(header[0x24:], 60)                // rate
+	binary.LittleEndian.PutUint32(header[0x34:], vgmHeaderSize-0x34) // data offset
+	binary.LittleEndian.PutUint32(header[0x84:], CPUFrequency)      // NES APU clock
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := file.Write(header); err != nil {
+		return err
+	}
+	_, err = file.Write(l.data)
+	return err
+}
+
+// logVGMWrite records a write to an APU register. Called from writeByte
+// before the write takes effect. None of the supported mappers have
+// expansion audio, so only the 2A03 registers are ever logged.
+func logVGMWrite(console *Console, address uint16, value byte) {
+	l := console.VGM
+	logVGMWait(l, console.CPU.Cycles)
+	if address == 0x4015 && value&16 == 16 {
+		// the DMC is (re)started from the sample address, so the player
+		// needs the sample bytes before it sees the enable
+		logDMCSample(console)
+	}
+	l.data = append(l.data, vgmCmdAPUWrite, byte(address-0x4000), value)
+}
+
+// logVGMWait emits wait commands to bring the log up to the given cpu cycle
+func logVGMWait(l *VGMLogger, cycle uint64) {
+	sample := (cycle - l.startCycle) * vgmSampleRate / CPUFrequency
+	if sample <= l.samples {
+		return
+	}
+	n := sample - l.samples
+	l.samples = sample
+	for n > 0 {
+		switch {
+		case n == 735:
+			l.data = append(l.data, vgmCmdWait735)
+			n = 0
+		case n == 882:
+			l.data = append(l.data, vgmCmdWait882)
+			n = 0
+		case n <= 16:
+			l.data = append(l.data, vgmCmdWaitN+byte(n-1))
+			n = 0
+		default:
+			wait := n
+			if wait > 0xFFFF {
+				wait = 0xFFFF
+			}
+			l.data = append(l.data, vgmCmdWait, byte(wait), byte(wait>>8))
+			n -= wait
+		}
+	}
+}
+
+// logDMCSample emits a data block holding the current DMC sample unless
+// an identical one has already been sent for the same address
+func logDMCSample(console *Console) {
+	l := console.VGM
+	d := &console.APU.dmc
+
+	// read the sample the way the DMC reader does, wrapping $FFFF to $8000,
+	// but with Peek so that logging doesn't disturb the open bus,
+	// watchpoints or the code/data logger
+	address := d.sampleAddress
+	start := address
+	var chunk []byte
+	flush := func () {
+		if len(chunk) == 0 {
+			return
+		}
+		if sent, ok := l.dmcSamples[start]; ok && bytes.Equal(sent, chunk) {
+			chunk = nil
+			return
+		}
+		l.dmcSamples[start] = chunk
+		size := make([]byte, 4)
+		binary.LittleEndian.PutUint32(size, uint32(len(chunk)+2))
+		l.data = append(l.data, vgmCmdBlock, 0x66, vgmBlockAPURAM)
+		l.data = append(l.data, size...)
+		l.data = append(l.data, byte(start), byte(start>>8))
+		l.data = append(l.data, chunk...)
+		chunk = nil
+	}
+	for i := uint16(0); i < d.sampleLength; i++ {
+		chunk = append(chunk, Peek(console, address))
+		address++
+		if address == 0 {
+			flush()
+			address = 0x8000
+			start = address
+		}
+	}
+	flush()
+}
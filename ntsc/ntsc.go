@@ -0,0 +1,202 @@
+// Package ntsc simulates the NES's composite video signal and a TV decoding
+// it, in the spirit of Blargg's nes_ntsc. It turns indexed PPU output (see
+// nes.IndexedBuffer) into an RGB image with the color artifacts, dot crawl
+// and blurring of a real TV.
+package ntsc
+
+import (
+	"image"
+	"sync"
+
+	"github.com/BrianWill/nes/nes"
+)
+
+// Setup controls how the signal is decoded.
+type Setup struct {
+	Sharpness float64 // -1 to 1: width of the luma filter
+	Artifacts float64 // 0 to 1: chroma leaking into luma (dot crawl)
+	Fringing  float64 // 0 to 1: luma edges leaking into chroma (color fringes)
+	Bleed     float64 // 0 to 1: how far color smears horizontally
+	Params    nes.PaletteParams
+}
+
+var (
+	Composite = Setup{Sharpness: 0, Artifacts: 1, Fringing: 1, Bleed: 0.5, Params: nes.DefaultPaletteParams}
+	SVideo    = Setup{Sharpness: 0.2, Artifacts: 0, Fringing: 0, Bleed: 0.5, Params: nes.DefaultPaletteParams}
+	RGB       = Setup{Sharpness: 1, Artifacts: 0, Fringing: 0, Bleed: 0, Params: nes.DefaultPaletteParams}
+)
+
+const (
+	samplesPerPixel = 8 // the PPU's signal has 8 subcarrier half-phases per dot
+	outputStep      = 4 // signal samples per output pixel
+	outputsPerPixel = samplesPerPixel / outputStep
+	Width           = 256 * outputsPerPixel
+	Height          = 240
+
+	// how many pixels to either side of a pixel its signal reaches after
+	// filtering (the widest filter is 36 samples, plus 12 for fringing)
+	kernelRadius = 4
+	kernelSize   = (2*kernelRadius + 1) * outputsPerPixel
+)
+
+// a pixel's contribution to the decoded y, i and q of nearby output pixels,
+// for each of the three subcarrier phases a pixel can start on
+type kernels [3][512][kernelSize][3]float64
+
+var cache struct {
+	sync.Mutex
+	setup   Setup
+	kernels *kernels
+}
+
+// NewImage returns an image of the size Filter produces.
+func NewImage() *image.RGBA {
+	return image.NewRGBA(image.Rect(0, 0, Width, Height))
+}
+
+// Filter decodes a 256x240 indexed frame into dst, which must be Width x
+// Height. frame is the PPU frame number; the subcarrier phase shifts from
+// frame to frame, which is what makes the artifacts crawl.
+func Filter(dst *image.RGBA, src []uint16, frame uint64, setup Setup) {
+	// decoding is linear, so rather than simulating every scanline's
+	// signal, each pixel's effect on its neighbors is simulated once per setup
+	cache.Lock()
+	if cache.kernels == nil || cache.setup != setup {
+		cache.setup = setup
+		cache.kernels = makeKernels(setup)
+	}
+	k := cache.kernels
+	cache.Unlock()
+
+	for row := 0; row < Height; row++ {
+		// a scanline is 341 dots, which is 4 phases more than a whole
+		// number of subcarrier cycles
+		phase := int((frame%3)*4+uint64(row)*4) % 12
+		line := src[row*256 : row*256+256]
+		for x := 0; x < Width; x++ {
+			var y, i, q float64
+			center := x / outputsPerPixel
+			for p := center - kernelRadius; p <= center+kernelRadius; p++ {
+				if p < 0 || p >= 256 {
+					continue
+				}
+				pixelPhase := (phase + p*samplesPerPixel) % 12 / 4
+				c := &k[pixelPhase][line[p]&0x1FF][x-(p-kernelRadius)*outputsPerPixel]
+				y += c[0]
+				i += c[1]
+				q += c[2]
+			}
+			dst.SetRGBA(x, row, nes.DecodeYIQ(y, i, q, setup.Params))
+		}
+	}
+}
+
+// makeKernels decodes a line holding a single pixel, for every pixel value
+// and starting phase, and records what it adds to each output pixel nearby
+func makeKernels(setup Setup) *kernels {
+	k := &kernels{}
+	const center = kernelRadius
+	samples := (2*kernelRadius + 1) * samplesPerPixel
+	sig := make([]float64, samples)
+	lum := make([]float64, samples)
+	for pixelPhase := 0; pixelPhase < 3; pixelPhase++ {
+		// phase of the line's first sample that starts the center pixel
+		// on pixelPhase
+		phase := (pixelPhase*4 - center*samplesPerPixel%12 + 12) % 12
+		for pixel := 0; pixel < 512; pixel++ {
+			var luma float64
+			for p := 0; p < 12; p++ {
+				luma += nes.CompositeSignal(uint16(pixel), p) / 12
+			}
+			for n := range sig {
+				sig[n] = 0
+				lum[n] = 0
+			}
+			for n := center * samplesPerPixel; n < (center+1)*samplesPerPixel; n++ {
+				sig[n] = nes.CompositeSignal(uint16(pixel), (phase+n)%12)
+				lum[n] = luma
+			}
+			y, i, q := decodeLine(sig, lum, phase, setup)
+			for x := range k[pixelPhase][pixel] {
+				k[pixelPhase][pixel][x] = [3]float64{y[x], i[x], q[x]}
+			}
+		}
+	}
+	return k
+}
+
+// decodeLine simulates a TV decoding one scanline of signal (sig), given
+// the luma of the pixel at each sample (lum) and the subcarrier phase of
+// the first sample. It returns y, i and q at each output pixel.
+func decodeLine(sig, lum []float64, phase int, setup Setup) (ys, is, qs []float64) {
+	cos, sin := nes.DecodeCarrier(setup.Params)
+
+	// filter widths, in signal samples. Box filters a multiple of 12 wide
+	// cancel the subcarrier completely; the notch a TV uses to separate
+	// luma from chroma is narrower and lets some through.
+	lumaWidth := 1 + int((1-setup.Sharpness)*3+0.5)
+	chromaWidth := 12 * (1 + int(setup.Bleed*2+0.5))
+	const notchWidth = 10
+
+	// split the signal into its luma and chroma parts so that the
+	// crosstalk between them can be controlled
+	crosstalk := make([]float64, len(sig))
+	i := make([]float64, len(sig))
+	q := make([]float64, len(sig))
+	for n := range sig {
+		p := (phase + n) % 12
+		c := sig[n] - lum[n]
+		crosstalk[n] = c
+		i[n] = c * cos[p]
+		q[n] = c * sin[p]
+	}
+	if setup.Fringing != 0 {
+		// luma detail within a subcarrier cycle looks like color
+		edges := boxFilter(lum, 12)
+		for n := range lum {
+			p := (phase + n) % 12
+			f := setup.Fringing * (lum[n] - edges[n])
+			i[n] += f * cos[p]
+			q[n] += f * sin[p]
+		}
+	}
+	y := boxFilter(lum, lumaWidth)
+	if setup.Artifacts != 0 {
+		// chroma the notch fails to remove shows up as dot crawl
+		crosstalk = boxFilter(crosstalk, notchWidth)
+		for n := range y {
+			y[n] += setup.Artifacts * crosstalk[n]
+		}
+	}
+	i = boxFilter(i, chromaWidth)
+	q = boxFilter(q, chromaWidth)
+
+	for n := outputStep / 2; n < len(sig); n += outputStep {
+		ys = append(ys, y[n])
+		is = append(is, i[n])
+		qs = append(qs, q[n])
+	}
+	return ys, is, qs
+}
+
+// boxFilter returns the moving average of s over width samples, centered
+// on each sample. Samples off the ends count as 0.
+func boxFilter(s []float64, width int) []float64 {
+	prefix := make([]float64, len(s)+1)
+	for n, v := range s {
+		prefix[n+1] = prefix[n] + v
+	}
+	result := make([]float64, len(s))
+	for n := range s {
+		lo := n - width/2
+		hi := lo + width
+		if lo < 0 {
+			lo = 0
+		}
+		if hi > len(s) {
+			hi = len(s)
+		}
+		result[n] = (prefix[hi] - prefix[lo]) / float64(width)
+	}
+	return result
+}
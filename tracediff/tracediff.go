@@ -0,0 +1,245 @@
+// Package tracediff compares instruction traces, line by line, with traces
+// written by other emulators: Nintendulator's nestest.log format (which the
+// nes package writes too), FCEUX's trace logger and Mesen's.
+package tracediff
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// An Entry is one line of a trace: the state before an instruction runs.
+// Values holds whichever of Fields the line gives.
+type Entry struct {
+	Line   int // line number in the trace, from 1
+	Text   string
+	Values map[string]int64
+}
+
+// Fields are the values an entry can hold, in the order they're compared.
+// P leaves out the B and U bits, which aren't really flags and which
+// emulators show differently. "dot" is scanline*341 + dot.
+var Fields = []string{"pc", "a", "x", "y", "p", "sp", "dot", "cycle"}
+
+// dotsPerFrame is how far "dot" goes before it wraps
+const dotsPerFrame = 262 * 341
+
+var (
+	fceuxPC    = regexp.MustCompile(`\$([0-9A-Fa-f]{4}):`)
+	leadingPC  = regexp.MustCompile(`^\s*\$?([0-9A-Fa-f]{4})\b`)
+	registers  = regexp.MustCompile(`\b(A|X|Y|SP|S):([0-9A-Fa-f]{2})\b`)
+	status     = regexp.MustCompile(`\bP:([0-9A-Fa-f]{2}\b|[NnVvUuBbDdIiZzCc.-]{8})`)
+	nestestPPU = regexp.MustCompile(`\bPPU:\s*(-?\d+),\s*(-?\d+)`)
+	scanline   = regexp.MustCompile(`\b(?:SL|V):\s*(-?\d+)`)
+	dot        = regexp.MustCompile(`\b(?:CYC|H):\s*(\d+)`)
+	cycle      = regexp.MustCompile(`\bCYC:(\d+)`)
+	mesenCycle = regexp.MustCompile(`\b(?:CPU Cycle|Cycle|Cy):\s*(\d+)`)
+	fceuxCycle = regexp.MustCompile(`(?:^|\s)c(\d+)\b`)
+)
+
+// Parse reads a trace line in any of the supported formats, reporting
+// false for lines that aren't instructions, such as headers.
+func Parse(text string) (Entry, bool) {
+	e := Entry{Text: text, Values: map[string]int64{}}
+	hex := func (s string) int64 {
+		n, _ := strconv.ParseInt(s, 16, 32)
+		return n
+	}
+	decimal := func (s string) int64 {
+		n, _ := strconv.ParseInt(s, 10, 64)
+		return n
+	}
+
+	if m := fceuxPC.FindStringSubmatch(text); m != nil {
+		e.Values["pc"] = hex(m[1])
+	} else if m := leadingPC.FindStringSubmatch(text); m != nil {
+		e.Values["pc"] = hex(m[1])
+	} else {
+		return e, false
+	}
+	for _, m := range registers.FindAllStringSubmatch(text, -1) {
+		name := strings.ToLower(m[1])
+		if name == "s" {
+			name = "sp"
+		}
+		e.Values[name] = hex(m[2])
+	}
+	if _, ok := e.Values["a"]; !ok {
+		return e, false
+	}
+	if m := status.FindStringSubmatch(text); m != nil {
+		var p int64
+		if len(m[1]) == 2 {
+			p = hex(m[1])
+		} else {
+			// NV-BDIZC, upper case for set
+			for i, c := range m[1] {
+				if c >= 'A' && c <= 'Z' {
+					p |= 0x80 >> uint(i)
+				}
+			}
+		}
+		e.Values["p"] = p &^ 0x30
+	}
+
+	// nestest.log gives the CPU cycle as CYC, after PPU:; older
+	// Nintendulator logs and Mesen give the dot as CYC or H, next to SL or V
+	if m := nestestPPU.FindStringSubmatch(text); m != nil {
+		e.Values["dot"] = position(decimal(m[1]), decimal(m[2]))
+		if m := cycle.FindStringSubmatch(text); m != nil {
+			e.Values["cycle"] = decimal(m[1])
+		}
+	} else if m := scanline.FindStringSubmatch(text); m != nil {
+		if d := dot.FindStringSubmatch(text); d != nil {
+			e.Values["dot"] = position(decimal(m[1]), decimal(d[1]))
+		}
+	}
+	if _, ok := e.Values["cycle"]; !ok {
+		if m := mesenCycle.FindStringSubmatch(text); m != nil {
+			e.Values["cycle"] = decimal(m[1])
+		} else if m := fceuxCycle.FindStringSubmatch(text); m != nil {
+			e.Values["cycle"] = decimal(m[1])
+		}
+	}
+	return e, true
+}
+
+// position numbers a scanline and dot; some emulators call the
+// pre-render line -1 rather than 261
+func position(line, dot int64) int64 {
+	if line < 0 {
+		line += 262
+	}
+	return line*341 + dot
+}
+
+// A Divergence is the first place two traces disagree
+type Divergence struct {
+	Ours, Reference Entry
+	OursEnded       bool     // ours ran out of lines where the reference has Reference
+	Before          []Entry  // the reference's lines leading up to it
+	After           []Entry  // and following it
+	Fields          []string // the fields that differ
+	Messages        []string // how each of them differs
+}
+
+// Diff reads ours and reference a line at a time, skipping lines that
+// don't parse, and returns where they first differ, or nil if they agree
+// until the reference ends. Ours ending first is a divergence. Only the
+// fields both traces give are compared. Timing (the dot and the CPU cycle)
+// is compared by how far it moved since the line before, since emulators
+// power on at different points; context is how many of the reference's
+// lines to keep on either side for the report.
+func Diff(ours, reference io.Reader, context int) (*Divergence, error) {
+	next := func (scanner *bufio.Scanner, line *int) (Entry, bool) {
+		for scanner.Scan() {
+			*line++
+			if e, ok := Parse(scanner.Text()); ok {
+				e.Line = *line
+				return e, true
+			}
+		}
+		return Entry{}, false
+	}
+	ourScanner := bufio.NewScanner(ours)
+	refScanner := bufio.NewScanner(reference)
+	var ourLine, refLine int
+	var before []Entry
+	var lastOurs, lastRef *Entry
+	// after adds the reference's next lines to a divergence
+	after := func (d *Divergence) (*Divergence, error) {
+		for len(d.After) < context {
+			r, ok := next(refScanner, &refLine)
+			if !ok {
+				break
+			}
+			d.After = append(d.After, r)
+		}
+		return d, refScanner.Err()
+	}
+	for {
+		r, ok := next(refScanner, &refLine)
+		if !ok {
+			break
+		}
+		o, ok := next(ourScanner, &ourLine)
+		if !ok {
+			if err := ourScanner.Err(); err != nil {
+				return nil, err
+			}
+			return after(&Divergence{
+				Ours:      Entry{Line: ourLine},
+				Reference: r,
+				OursEnded: true,
+				Before:    before,
+				Messages:  []string{fmt.Sprintf("ours ended after line %d; the reference continues", ourLine)},
+			})
+		}
+		d := &Divergence{Ours: o, Reference: r, Before: before}
+		for _, field := range Fields {
+			ov, ok1 := o.Values[field]
+			rv, ok2 := r.Values[field]
+			if !ok1 || !ok2 {
+				continue
+			}
+			switch field {
+			case "dot", "cycle":
+				if lastOurs == nil {
+					continue
+				}
+				ov -= lastOurs.Values[field]
+				rv -= lastRef.Values[field]
+				if field == "dot" {
+					ov = (ov + dotsPerFrame) % dotsPerFrame
+					rv = (rv + dotsPerFrame) % dotsPerFrame
+				}
+				if ov != rv {
+					d.Fields = append(d.Fields, field)
+					d.Messages = append(d.Messages, fmt.Sprintf(
+						"%s: %d since the previous line in the reference, %d in ours", field, rv, ov))
+				}
+			default:
+				if ov != rv {
+					d.Fields = append(d.Fields, field)
+					d.Messages = append(d.Messages, fmt.Sprintf(
+						"%s: %02X in the reference, %02X in ours", field, rv, ov))
+				}
+			}
+		}
+		if len(d.Fields) > 0 {
+			return after(d)
+		}
+		before = append(before, r)
+		if len(before) > context {
+			before = before[1:]
+		}
+		lastOurs, lastRef = &o, &r
+	}
+	return nil, refScanner.Err()
+}
+
+// Print writes a report of a divergence to w
+func Print(w io.Writer, d *Divergence) {
+	fmt.Fprintf(w, "traces diverge at line %d of the reference (line %d of ours)\n", d.Reference.Line, d.Ours.Line)
+	for _, e := range d.Before {
+		fmt.Fprintf(w, "  %s\n", e.Text)
+	}
+	ours := d.Ours.Text
+	if d.OursEnded {
+		ours = "(ended)"
+	}
+	fmt.Fprintf(w, "reference:\n  %s\nours:\n  %s\n", d.Reference.Text, ours)
+	if len(d.After) > 0 {
+		fmt.Fprintln(w, "the reference goes on:")
+		for _, e := range d.After {
+			fmt.Fprintf(w, "  %s\n", e.Text)
+		}
+	}
+	for _, message := range d.Messages {
+		fmt.Fprintln(w, message)
+	}
+}
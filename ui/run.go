@@ -13,17 +13,117 @@ import (
 	"path"
 	"strings"
 
-	//"fmt"
+	"fmt"
 
+	"github.com/BrianWill/nes/filters"
 	"github.com/BrianWill/nes/nes"
+	"github.com/BrianWill/nes/ntsc"
 	"github.com/go-gl/gl/v2.1/gl"
 	"github.com/go-gl/glfw/v3.1/glfw"
 	"github.com/gordonklaus/portaudio"
 )
 
-func Run(paths []string) {
+func Run(paths []string, config Config) {
 	var fontMask image.Image
 
+	// nil for the built-in palette
+	var palette *[512]color.RGBA
+	switch config.Palette {
+	case "":
+	case "ntsc":
+		p := nes.GeneratePalette(config.PaletteParams)
+		palette = &p
+	default:
+		p, err := nes.LoadPalette(config.Palette)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		palette = &p
+	}
+
+	// nil when the NTSC filter is off
+	var ntscSetup *ntsc.Setup
+	{
+		var setup ntsc.Setup
+		switch config.NTSC {
+		case "":
+		case "composite":
+			setup = ntsc.Composite
+		case "svideo":
+			setup = ntsc.SVideo
+		case "rgb":
+			setup = ntsc.RGB
+		default:
+			log.Fatalf("unknown ntsc filter: %s", config.NTSC)
+		}
+		if config.NTSC != "" {
+			setup.Params = config.PaletteParams
+			ntscSetup = &setup
+		}
+	}
+
+	// nil when there's no filter
+	var filter filters.Filter
+	if config.Filter != "" {
+		f, err := filters.Lookup(config.Filter)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		filter = f
+	}
+	var captureFilter filters.Filter
+	if config.FilterCaptures {
+		captureFilter = filter
+	}
+
+	// the part of the frame shown, in texture coordinates, and its shape
+	crop := config.Overscan
+	if crop.Top < 0 || crop.Bottom < 0 || crop.Left < 0 || crop.Right < 0 ||
+		crop.Top+crop.Bottom >= height || crop.Left+crop.Right >= width {
+		log.Fatalf("invalid overscan: %+v", crop)
+	}
+	u0 := float32(crop.Left) / width
+	u1 := 1 - float32(crop.Right)/width
+	v0 := float32(crop.Top) / height
+	v1 := 1 - float32(crop.Bottom)/height
+	pixelAspect := 1.0
+	if config.PixelAspect != "" {
+		var a, b int
+		if n, _ := fmt.Sscanf(config.PixelAspect, "%d:%d", &a, &b); n != 2 || a <= 0 || b <= 0 {
+			log.Fatalf("invalid pixel aspect ratio: %s", config.PixelAspect)
+		}
+		pixelAspect = float64(a) / float64(b)
+	}
+	frameWidth := float64(width-crop.Left-crop.Right) * pixelAspect
+	frameHeight := float64(height-crop.Top-crop.Bottom)
+
+	var initialRAM int
+	switch config.InitialRAM {
+	case "", "zero":
+		initialRAM = nes.RAMZero
+	case "ff":
+		initialRAM = nes.RAMFill
+	case "pattern":
+		initialRAM = nes.RAMPattern
+	case "random":
+		initialRAM = nes.RAMRandom
+	default:
+		log.Fatalf("unknown initial ram: %s", config.InitialRAM)
+	}
+
+	// the image shown for the current frame, after any filtering
+	display := func (v *GameView) *image.RGBA {
+		im := nes.Buffer(v.console)
+		if ntscSetup != nil {
+			ntsc.Filter(v.ntscImage, nes.IndexedBuffer(v.console), v.console.PPU.Frame, *ntscSetup)
+			im = v.ntscImage
+		}
+		if filter != nil {
+			im = filter(im)
+		}
+		return im
+	}
+
 	clampScroll := func (v *MenuView, wrap bool) {
 		n := len(v.paths)
 		rows := n / v.nx
@@ -51,6 +151,93 @@ func Run(paths []string) {
 		}
 	}
 
+	// draws a single row of text with the top left corner at x, y
+	drawText := func (dst draw.Image, text string, x, y int, c color.Color) {
+		for _, ch := range text {
+			if !(ch < 32 || ch > 128) {
+				cx := int((ch-32)%16) * 16
+				cy := int((ch-32)/16) * 16
+				r := image.Rect(x, y, x+16, y+16)
+				sp := image.Pt(cx, cy)
+				draw.DrawMask(dst, r, &image.Uniform{c}, sp, fontMask, sp, draw.Over)
+			}
+			x += 16
+		}
+	}
+
+	// renders the audio visualizer: a waveform lane per APU channel and a
+	// piano roll marking the note each pitched channel is playing
+	drawScope := func (v *GameView) {
+		im := v.scopeImage
+		draw.Draw(im, im.Rect, image.Transparent, image.ZP, draw.Src)
+		shade := &image.Uniform{color.RGBA{0, 0, 0, 160}}
+		colors := []color.RGBA{
+			{255, 96, 96, 255},
+			{255, 192, 64, 255},
+			{96, 224, 96, 255},
+			{96, 160, 255, 255},
+			{224, 96, 255, 255},
+		}
+		channels := nes.APUChannels(v.console)
+		notes := make(map[int]color.RGBA)
+		for c, ch := range channels {
+			top := c * scopeLane
+			draw.Draw(im, image.Rect(0, top, scopeWidth, top+scopeLane-4), shade, image.ZP, draw.Over)
+
+			label := ch.Name
+			if ch.Enabled && ch.Frequency > 0 {
+				name, midi := noteName(ch.Frequency)
+				label = fmt.Sprintf("%-8s %-3s $%03X", ch.Name, name, ch.Period)
+				notes[midi] = colors[c]
+			}
+			drawText(im, label, 4, top+2, colors[c])
+
+			// trigger on a rising edge so periodic waves hold still
+			s := ch.Samples
+			start := len(s) - scopeWidth
+			for i := len(s) - scopeWidth; i > 1; i-- {
+				if s[i-1] < s[i] {
+					start = i
+					break
+				}
+			}
+			bottom := top + scopeLane - 6
+			span := scopeLane - 26
+			prev := bottom - int(s[start])*span/int(ch.Max)
+			for x := 0; x < scopeWidth; x++ {
+				y := bottom - int(s[start+x])*span/int(ch.Max)
+				y1, y2 := prev, y
+				if y1 > y2 {
+					y1, y2 = y2, y1
+				}
+				for yy := y1; yy <= y2; yy++ {
+					im.SetRGBA(x, yy, colors[c])
+				}
+				prev = y
+			}
+		}
+
+		// piano roll, A0 (21) to C8 (108)
+		top := 5 * scopeLane
+		draw.Draw(im, image.Rect(0, top, scopeWidth, scopeHeight), shade, image.ZP, draw.Over)
+		const keyWidth = 5
+		left := (scopeWidth - 88*keyWidth) / 2
+		for midi := 21; midi <= 108; midi++ {
+			x := left + (midi-21)*keyWidth
+			c, ok := notes[midi]
+			if !ok {
+				switch midi % 12 {
+				case 1, 3, 6, 8, 10:
+					c = color.RGBA{48, 48, 48, 255}
+				default:
+					c = color.RGBA{208, 208, 208, 255}
+				}
+			}
+			r := image.Rect(x, top+8, x+keyWidth-1, scopeHeight-8)
+			draw.Draw(im, r, &image.Uniform{c}, image.ZP, draw.Src)
+		}
+	}
+
 	setView := func (d *Director, view View) {
 		// clean up previously used view
 		if d.view != nil {
@@ -58,6 +245,12 @@ func Run(paths []string) {
 			case *GameView:
 				d.window.SetKeyCallback(nil)
 				nes.SetAudioChannel(v.console, nil)
+				if v.console.VGM != nil {
+					saveVGM(v.console)
+				}
+				if v.console.CDL != nil {
+					saveCDL(v)
+				}
 				// save sram
 				cartridge := v.console.Cartridge
 				if cartridge.Battery != 0 {
@@ -80,17 +273,37 @@ func Run(paths []string) {
 						if action == glfw.Press {
 							switch key {
 							case glfw.KeySpace:
-								screenshot(nes.Buffer(v.console))
+								if captureFilter != nil {
+									screenshot(display(v))
+								} else {
+									screenshot(nes.Buffer(v.console))
+								}
 							case glfw.KeyR:
 								nes.Reset(v.console)
+							case glfw.KeyP:
+								nes.PowerCycle(v.console)
 							case glfw.KeyTab:
 								if v.record {
 									v.record = false
-									animation(v.frames)
+									animation(v.frames, nes.ActivePalette(v.console), captureFilter)
 									v.frames = nil
 								} else {
 									v.record = true
 								}
+							case glfw.KeyV:
+								if v.console.VGM != nil {
+									saveVGM(v.console)
+								} else {
+									nes.StartVGM(v.console)
+								}
+							case glfw.KeyC:
+								if v.console.CDL != nil {
+									saveCDL(v)
+								} else {
+									startCDL(v)
+								}
+							case glfw.KeyO:
+								v.scope = !v.scope
 							}
 						}
 					},
@@ -130,6 +343,47 @@ func Run(paths []string) {
 		d.timestamp = glfw.GetTime()
 	}
 
+	// GLFW 3.1 can't move a window to or from a monitor, so this replaces
+	// the window with one sharing its OpenGL objects
+	toggleFullscreen := func (d *Director) {
+		var window *glfw.Window
+		var err error
+		if d.fullscreen {
+			window, err = glfw.CreateWindow(d.windowed[2], d.windowed[3], title, nil, d.window)
+		} else {
+			x, y := d.window.GetPos()
+			w, h := d.window.GetSize()
+			d.windowed = [4]int{x, y, w, h}
+			monitor := glfw.GetPrimaryMonitor()
+			mode := monitor.GetVideoMode()
+			window, err = glfw.CreateWindow(mode.Width, mode.Height, title, monitor, d.window)
+		}
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		if d.fullscreen {
+			window.SetPos(d.windowed[0], d.windowed[1])
+		}
+		d.fullscreen = !d.fullscreen
+
+		// move the callbacks and the context's state over to the new window
+		window.SetKeyCallback(d.window.SetKeyCallback(nil))
+		window.SetCharCallback(d.window.SetCharCallback(nil))
+		d.window.Destroy()
+		d.window = window
+		window.MakeContextCurrent()
+		gl.Enable(gl.TEXTURE_2D)
+		switch v := d.view.(type) {
+		case *GameView:
+			gl.ClearColor(0, 0, 0, 1)
+			window.SetTitle(v.title)
+		case *MenuView:
+			gl.ClearColor(0.333, 0.333, 0.333, 1)
+			window.SetTitle("Select Game")
+		}
+	}
+
 	// returns index in .lookup
 	loadTexture := func (t *Texture, romPath string) int {
 		drawCenteredText := func (dst draw.Image, text string, dx, dy int, c color.Color) {
@@ -276,7 +530,24 @@ func Run(paths []string) {
 		if err != nil {
 			log.Fatalln(err)
 		}
-		setView(d, &GameView{console, path, hash, createTexture(), false, nil})
+		if palette != nil {
+			nes.SetPalette(console, *palette)
+		}
+		nes.SetInitialRAM(console, initialRAM)
+		nes.SetIndexedOutput(console, true)
+		nes.SetUnlimitedSprites(console, config.UnlimitedSprites)
+		v := &GameView{
+			console: console,
+			title: path,
+			hash: hash,
+			texture: createTexture(),
+			scopeTexture: createTexture(),
+			scopeImage: image.NewRGBA(image.Rect(0, 0, scopeWidth, scopeHeight)),
+		}
+		if ntscSetup != nil {
+			v.ntscImage = ntsc.NewImage()
+		}
+		setView(d, v)
 	}
 
 
@@ -338,7 +609,7 @@ func Run(paths []string) {
 	// create window
 	glfw.WindowHint(glfw.ContextVersionMajor, 2)
 	glfw.WindowHint(glfw.ContextVersionMinor, 1)
-	window, err := glfw.CreateWindow(width*scale, height*scale, title, nil, nil)
+	window, err := glfw.CreateWindow(int(frameWidth*scale+0.5), int(frameHeight*scale), title, nil, nil)
 	if err != nil {
 		log.Fatalln(err)
 	}
@@ -371,6 +642,13 @@ func Run(paths []string) {
 	}
 	// main loop
 	for !d.window.ShouldClose() {
+		// F11 toggles fullscreen in any view
+		fullscreenKey := readKey(d.window, glfw.KeyF11)
+		if fullscreenKey && !d.fullscreenKey {
+			toggleFullscreen(d)
+		}
+		d.fullscreenKey = fullscreenKey
+
 		gl.Clear(gl.COLOR_BUFFER_BIT)
 		timestamp := glfw.GetTime()
 		dt := timestamp - d.timestamp
@@ -401,35 +679,49 @@ func Run(paths []string) {
 				nes.StepSeconds(v.console, dt)
 
 				gl.BindTexture(gl.TEXTURE_2D, v.texture)
-				setTexture(nes.Buffer(v.console))
+				if config.FrameBlend {
+					setTexture(blendFrame(v, display(v)))
+				} else {
+					setTexture(display(v))
+				}
 				// draw buffer
 				{
 					w, h := d.window.GetFramebufferSize()
-					s1 := float32(w) / 256
-					s2 := float32(h) / 240
-					f := float32(1 - padding)
-					var x, y float32
-					if s1 >= s2 {
-						x = f * s2 / s1
-						y = f
-					} else {
-						x = f
-						y = f * s1 / s2
-					}
+					x, y := fitFrame(w, h, frameWidth, frameHeight, config.IntegerScale)
 					gl.Begin(gl.QUADS)
-					gl.TexCoord2f(0, 1)
+					gl.TexCoord2f(u0, v1)
 					gl.Vertex2f(-x, -y)
-					gl.TexCoord2f(1, 1)
+					gl.TexCoord2f(u1, v1)
 					gl.Vertex2f(x, -y)
-					gl.TexCoord2f(1, 0)
+					gl.TexCoord2f(u1, v0)
 					gl.Vertex2f(x, y)
-					gl.TexCoord2f(0, 0)
+					gl.TexCoord2f(u0, v0)
 					gl.Vertex2f(-x, y)
 					gl.End()
+
+					if v.scope {
+						drawScope(v)
+						gl.BindTexture(gl.TEXTURE_2D, v.scopeTexture)
+						setTexture(v.scopeImage)
+						// image.RGBA is alpha-premultiplied
+						gl.Enable(gl.BLEND)
+						gl.BlendFunc(gl.ONE, gl.ONE_MINUS_SRC_ALPHA)
+						gl.Begin(gl.QUADS)
+						gl.TexCoord2f(0, 1)
+						gl.Vertex2f(-x, -y)
+						gl.TexCoord2f(1, 1)
+						gl.Vertex2f(x, -y)
+						gl.TexCoord2f(1, 0)
+						gl.Vertex2f(x, y)
+						gl.TexCoord2f(0, 0)
+						gl.Vertex2f(-x, y)
+						gl.End()
+						gl.Disable(gl.BLEND)
+					}
 				}
 				gl.BindTexture(gl.TEXTURE_2D, 0)  // btw: not sure this serves any purpose?
 				if v.record {
-					v.frames = append(v.frames, copyImage(nes.Buffer(v.console)))
+					v.frames = append(v.frames, append([]uint16(nil), nes.IndexedBuffer(v.console)...))
 				}
 			case *MenuView:
 				// check buttons
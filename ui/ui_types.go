@@ -18,12 +18,35 @@ type View interface {
 func (_ *GameView) View() {}
 func (_ *MenuView) View() {}
 
+// settings read from ~/.nes/config.json; command line flags override them
+type Config struct {
+	Palette string                  // "" for the built-in palette, "ntsc" to generate one, or a .pal file
+	PaletteParams nes.PaletteParams // used when Palette is "ntsc" and by the NTSC filter
+	NTSC string                     // NTSC filter: "", "composite", "svideo" or "rgb"
+	Filter string                   // scaler/overlay filter, see filters.Lookup; "" for none
+	FilterCaptures bool             // apply Filter to screenshots and recordings too
+	PixelAspect string              // shape of a pixel, e.g. "8:7" as on an NTSC TV; "" for square
+	IntegerScale bool               // only scale the picture by whole multiples
+	Overscan Overscan               // rows and columns to crop from each edge
+	UnlimitedSprites bool           // draw more than 8 sprites per line (less flicker)
+	FrameBlend bool                 // average each frame with the last to hide flicker
+	InitialRAM string               // RAM at power on: "zero" (default), "ff", "pattern" or "random"
+}
+
+// the edges of the picture a TV would hide behind its bezel
+type Overscan struct {
+	Top, Bottom, Left, Right int
+}
+
 type Director struct {
 	window *glfw.Window
 	audio *Audio
 	view View
 	menuView MenuView
 	timestamp float64
+	fullscreen bool
+	fullscreenKey bool // F11 was down last frame
+	windowed [4]int    // position and size to restore when leaving fullscreen
 }
 
 type Audio struct {
@@ -46,7 +69,13 @@ type GameView struct {
 	hash string
 	texture uint32
 	record bool
-	frames []image.Image
+	frames [][]uint16    // indexed frames (see nes.IndexedBuffer)
+	scope bool            // show the audio visualizer overlay
+	scopeTexture uint32
+	scopeImage *image.RGBA
+	ntscImage *image.RGBA // output of the NTSC filter, if it's on
+	previous *image.RGBA  // last frame displayed, for frame blending
+	blended *image.RGBA
 }
 
 type MenuView struct {
@@ -75,6 +104,9 @@ const (
 	height = 240
 	scale  = 3
 	title  = "NES"
+	scopeWidth = 512    // audio visualizer overlay size
+	scopeHeight = 480
+	scopeLane = 80      // height of each channel's waveform lane
 )
 
 var fontData = []byte{
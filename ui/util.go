@@ -3,16 +3,22 @@ package ui
 import (
 	"crypto/md5"
 	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"image"
 	"image/color"
 	"image/draw"
 	"image/gif"
+	webpalette "image/color/palette"
 	"image/png"
 	"io/ioutil"
+	"log"
+	"math"
 	"os"
 	"path"
+	"strings"
 
+	"github.com/BrianWill/nes/filters"
 	"github.com/BrianWill/nes/nes"
 	"github.com/go-gl/gl/v2.1/gl"
 	"github.com/go-gl/glfw/v3.1/glfw"
@@ -31,6 +37,25 @@ func sramPath(hash string) string {
 	return homeDir + "/.nes/sram/" + hash + ".dat"
 }
 
+func configPath() string {
+	return homeDir + "/.nes/config.json"
+}
+
+// LoadConfig reads the config file, falling back to defaults for anything
+// it doesn't set or if there is no config file.
+func LoadConfig() (Config, error) {
+	config := Config{PaletteParams: nes.DefaultPaletteParams}
+	data, err := ioutil.ReadFile(configPath())
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return config, err
+	}
+	err = json.Unmarshal(data, &config)
+	return config, err
+}
+
 func readKey(window *glfw.Window, key glfw.Key) bool {
 	return window.GetKey(key) == glfw.Press
 }
@@ -82,6 +107,17 @@ func combineButtons(a, b [8]bool) [8]bool {
 	return result
 }
 
+// noteName returns the name (e.g. "C#4") and MIDI number of the note
+// nearest to the given frequency
+func noteName(freq float64) (string, int) {
+	names := []string{"C", "C#", "D", "D#", "E", "F", "F#", "G", "G#", "A", "A#", "B"}
+	midi := int(math.Floor(69 + 12*math.Log2(freq/440) + 0.5))
+	if midi < 0 {
+		return "", midi
+	}
+	return fmt.Sprintf("%s%d", names[midi%12], midi/12-1), midi
+}
+
 func hashFile(path string) (string, error) {
 	data, err := ioutil.ReadFile(path)
 	if err != nil {
@@ -109,6 +145,36 @@ func setTexture(im *image.RGBA) {
 		0, gl.RGBA, gl.UNSIGNED_BYTE, gl.Ptr(im.Pix))
 }
 
+// blendFrame returns the average of im and the previously displayed frame,
+// which turns sprites that flicker at 30 Hz into steady, translucent ones
+func blendFrame(v *GameView, im *image.RGBA) *image.RGBA {
+	if v.previous == nil || v.previous.Rect != im.Rect {
+		v.previous = copyImage(im)
+		v.blended = image.NewRGBA(im.Rect)
+	}
+	for i, a := range im.Pix {
+		b := v.previous.Pix[i]
+		v.blended.Pix[i] = byte((int(a) + int(b) + 1) / 2)
+		v.previous.Pix[i] = a
+	}
+	return v.blended
+}
+
+// fitFrame returns the half width and height, in OpenGL coordinates, of a
+// frame of the given shape displayed as large as it fits in a w x h window.
+// With integer set, the frame is scaled by a whole multiple of its size
+// when the window is big enough for that.
+func fitFrame(w, h int, frameWidth, frameHeight float64, integer bool) (float32, float32) {
+	s1 := float64(w) / frameWidth
+	s2 := float64(h) / frameHeight
+	s := math.Min(s1, s2)
+	if integer && s >= 1 {
+		s = math.Floor(s)
+	}
+	f := float64(1 - padding)
+	return float32(f * s / s1), float32(f * s / s2)
+}
+
 func copyImage(src image.Image) *image.RGBA {
 	dst := image.NewRGBA(src.Bounds())
 	draw.Draw(dst, dst.Rect, src, image.ZP, draw.Src)
@@ -133,18 +199,55 @@ func savePNG(path string, im image.Image) error {
 	return png.Encode(file, im)
 }
 
-func saveGIF(path string, frames []image.Image) error {
-	var palette []color.Color
-	for _, c := range nes.Palette {
-		palette = append(palette, c)
-	}
+// saveGIF writes indexed frames (see nes.IndexedBuffer) to a gif. Each
+// frame gets a palette of exactly the colors it uses, so nothing is
+// quantized unless a filter adds more colors than a gif frame can hold.
+func saveGIF(path string, frames [][]uint16, colors [512]color.RGBA, filter filters.Filter) error {
 	g := gif.GIF{}
 	for i, src := range frames {
 		if i%3 != 0 {
 			continue
 		}
-		dst := image.NewPaletted(src.Bounds(), palette)
-		draw.Draw(dst, dst.Rect, src, image.ZP, draw.Src)
+		var dst *image.Paletted
+		if filter == nil {
+			var palette color.Palette
+			lookup := make(map[uint16]byte)
+			dst = image.NewPaletted(image.Rect(0, 0, 256, 240), nil)
+			for j, c := range src {
+				index, ok := lookup[c]
+				if !ok {
+					if len(palette) == 256 {
+						// only possible with many mid-frame emphasis changes;
+						// the palette RAM holds just 25 distinct colors
+						index = 0
+					} else {
+						index = byte(len(palette))
+						lookup[c] = index
+						palette = append(palette, colors[c])
+					}
+				}
+				dst.Pix[j] = index
+			}
+			dst.Palette = palette
+		} else {
+			im := filter(filters.Indexed(src, colors))
+			var palette color.Palette
+			seen := make(map[color.RGBA]bool)
+			for j := 0; j < len(im.Pix) && len(palette) <= 256; j += 4 {
+				c := color.RGBA{im.Pix[j], im.Pix[j+1], im.Pix[j+2], im.Pix[j+3]}
+				if !seen[c] {
+					seen[c] = true
+					palette = append(palette, c)
+				}
+			}
+			if len(palette) > 256 {
+				dst = image.NewPaletted(im.Rect, webpalette.Plan9)
+				draw.FloydSteinberg.Draw(dst, dst.Rect, im, image.ZP)
+			} else {
+				dst = image.NewPaletted(im.Rect, palette)
+				draw.Draw(dst, dst.Rect, im, image.ZP, draw.Src)
+			}
+		}
 		g.Image = append(g.Image, dst)
 		g.Delay = append(g.Delay, 5)
 	}
@@ -166,16 +269,53 @@ func screenshot(im image.Image) {
 	}
 }
 
-func animation(frames []image.Image) {
+func animation(frames [][]uint16, palette [512]color.RGBA, filter filters.Filter) {
 	for i := 0; i < 1000; i++ {
 		path := fmt.Sprintf("%03d.gif", i)
 		if _, err := os.Stat(path); os.IsNotExist(err) {
-			saveGIF(path, frames)
+			saveGIF(path, frames, palette, filter)
+			return
+		}
+	}
+}
+
+func saveVGM(console *nes.Console) {
+	for i := 0; i < 1000; i++ {
+		path := fmt.Sprintf("%03d.vgm", i)
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			if err := nes.StopVGM(console, path); err != nil {
+				log.Println(err)
+			}
 			return
 		}
 	}
 }
 
+// cdlPath is where the code/data log for a ROM goes: next to it, as
+// FCEUX keeps it
+func cdlPath(romPath string) string {
+	return strings.TrimSuffix(romPath, path.Ext(romPath)) + ".cdl"
+}
+
+// startCDL starts the code/data logger, carrying on from the ROM's .cdl
+// file if there is one
+func startCDL(v *GameView) {
+	err := nes.LoadCDL(v.console, cdlPath(v.title))
+	if os.IsNotExist(err) {
+		nes.StartCDL(v.console)
+	} else if err != nil {
+		log.Println(err)
+	}
+}
+
+// saveCDL stops the code/data logger and saves the log
+func saveCDL(v *GameView) {
+	if err := nes.SaveCDL(v.console, cdlPath(v.title)); err != nil {
+		log.Println(err)
+	}
+	nes.StopCDL(v.console)
+}
+
 func writeSRAM(filename string, sram []byte) error {
 	dir, _ := path.Split(filename)
 	if err := os.MkdirAll(dir, 0755); err != nil {